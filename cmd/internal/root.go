@@ -17,10 +17,12 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 
+	"code.cloudfoundry.org/quarks-operator/pkg/bosh/bpmconverter"
 	"code.cloudfoundry.org/quarks-operator/pkg/kube/operator"
 	"code.cloudfoundry.org/quarks-operator/pkg/kube/util/boshdns"
 	"code.cloudfoundry.org/quarks-operator/pkg/kube/util/logrotate"
 	"code.cloudfoundry.org/quarks-operator/pkg/kube/util/operatorimage"
+	"code.cloudfoundry.org/quarks-operator/pkg/kube/util/withops"
 	"code.cloudfoundry.org/quarks-operator/version"
 	"code.cloudfoundry.org/quarks-utils/pkg/cmd"
 	"code.cloudfoundry.org/quarks-utils/pkg/config"
@@ -92,6 +94,8 @@ var rootCmd = &cobra.Command{
 		cfg.WebhookUseServiceRef = useServiceRef
 		cfg.MaxBoshDeploymentWorkers = viper.GetInt("max-boshdeployment-workers")
 		logrotate.SetInterval(viper.GetInt("logrotate-interval"))
+		bpmconverter.SetTagLabels(viper.GetBool("tag-labels"))
+		withops.SetDefaultFileBaseDir(viper.GetString("file-references-dir"))
 
 		cmd.CtxTimeOut(cfg)
 
@@ -152,31 +156,37 @@ func init() {
 
 	pf.StringP("bosh-dns-docker-image", "", "coredns/coredns:1.6.3", "The docker image used for emulating bosh DNS (a CoreDNS image)")
 	pf.String("cluster-domain", "cluster.local", "The Kubernetes cluster domain")
+	pf.String("file-references-dir", "", "Directory of the files, which BOSHDeployments can reference, file references are disabled if empty")
 	pf.IntP("logrotate-interval", "i", 24*60, "Interval between logrotate calls for instance groups in minutes")
 	pf.Int("max-boshdeployment-workers", 1, "Maximum number of workers concurrently running BOSHDeployment controller")
 	pf.StringP("operator-webhook-service-host", "w", "", "Hostname/IP under which the webhook server can be reached from the cluster")
 	pf.StringP("operator-webhook-service-port", "p", "2999", "Port the webhook server listens on")
 	pf.BoolP("operator-webhook-use-service-reference", "x", false, "If true the webhook service is targeted using a service reference instead of a URL")
+	pf.Bool("tag-labels", false, "If true the tags of BOSH manifests are added as labels to the pods")
 
 	for _, name := range []string{
 		"bosh-dns-docker-image",
 		"cluster-domain",
+		"file-references-dir",
 		"logrotate-interval",
 		"max-boshdeployment-workers",
 		"operator-webhook-service-host",
 		"operator-webhook-service-port",
 		"operator-webhook-use-service-reference",
+		"tag-labels",
 	} {
 		viper.BindPFlag(name, pf.Lookup(name))
 	}
 
 	argToEnv["bosh-dns-docker-image"] = "BOSH_DNS_DOCKER_IMAGE"
 	argToEnv["cluster-domain"] = "CLUSTER_DOMAIN"
+	argToEnv["file-references-dir"] = "FILE_REFERENCES_DIR"
 	argToEnv["logrotate-interval"] = "LOGROTATE_INTERVAL"
 	argToEnv["max-boshdeployment-workers"] = "MAX_BOSHDEPLOYMENT_WORKERS"
 	argToEnv["operator-webhook-service-host"] = "CF_OPERATOR_WEBHOOK_SERVICE_HOST"
 	argToEnv["operator-webhook-service-port"] = "CF_OPERATOR_WEBHOOK_SERVICE_PORT"
 	argToEnv["operator-webhook-use-service-reference"] = "CF_OPERATOR_WEBHOOK_USE_SERVICE_REFERENCE"
+	argToEnv["tag-labels"] = "TAG_LABELS"
 
 	// Add env variables to help
 	cmd.AddEnvToUsage(rootCmd, argToEnv)
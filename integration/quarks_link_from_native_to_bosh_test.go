@@ -129,6 +129,43 @@ var _ = Describe("QuarksLink from native provider to explicit links in BOSH", fu
 		})
 	})
 
+	Context("when deployment has a service with a selector and uses dns addresses", func() {
+		BeforeEach(func() {
+			By("Creating a minimal nats config")
+			tearDown, err := env.CreateConfigMap(env.Namespace, env.NatsConfigMap(deploymentName))
+			Expect(err).NotTo(HaveOccurred())
+			tearDowns = append(tearDowns, tearDown)
+
+			By("Starting the nats pod")
+			tearDown, err = env.CreatePod(env.Namespace, env.NatsPod(deploymentName))
+			Expect(err).NotTo(HaveOccurred())
+			tearDowns = append(tearDowns, tearDown)
+
+			boshManifest = env.BOSHManifestSecret(manifestRef, bm.NatsSmokeTestWithExternalLinksDNS)
+			provider = env.NatsSecret(deploymentName)
+			service = env.NatsService(deploymentName)
+			bdpl = env.SecretBOSHDeployment(deploymentName, manifestRef)
+		})
+
+		It("uses the service address for the instances", func() {
+			By("waiting for job rendering done", func() {
+				err := env.WaitForPods(env.Namespace, "quarks.cloudfoundry.org/instance-group-name=nats-smoke-tests")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			nats, err := env.GetPod(env.Namespace, "nats")
+			Expect(err).NotTo(HaveOccurred())
+
+			ig, err := env.GetSecret(env.Namespace, "ig-resolved.nats-smoke-tests-v1")
+			Expect(err).NotTo(HaveOccurred())
+			igm := string(ig.Data["properties.yaml"])
+
+			Expect(igm).To(ContainSubstring("address: nats-headless." + env.Namespace + ".svc."))
+			Expect(igm).NotTo(ContainSubstring("address: " + nats.Status.PodIP))
+			Expect(igm).To(ContainSubstring(`bootstrap: true`))
+		})
+	})
+
 	Context("when deployment has a service with an endpoint", func() {
 		var ep corev1.Endpoints
 		BeforeEach(func() {
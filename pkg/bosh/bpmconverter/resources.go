@@ -3,8 +3,10 @@ package bpmconverter
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
@@ -16,6 +18,7 @@ import (
 	qjv1a1 "code.cloudfoundry.org/quarks-job/pkg/kube/apis/quarksjob/v1alpha1"
 	"code.cloudfoundry.org/quarks-operator/pkg/bosh/bpm"
 	bdm "code.cloudfoundry.org/quarks-operator/pkg/bosh/manifest"
+	"code.cloudfoundry.org/quarks-operator/pkg/kube/apis"
 	bdv1 "code.cloudfoundry.org/quarks-operator/pkg/kube/apis/boshdeployment/v1alpha1"
 	"code.cloudfoundry.org/quarks-operator/pkg/kube/util/boshdns"
 	"code.cloudfoundry.org/quarks-operator/pkg/kube/util/names"
@@ -26,12 +29,27 @@ import (
 
 var (
 	admGroupID = int64(1000)
+	// tagLabels enables the tag labels of the operator's converter
+	tagLabels bool
 )
 
+// SetTagLabels enables adding the manifest's tags as pod labels for the
+// converter used by the operator
+func SetTagLabels(enabled bool) {
+	tagLabels = enabled
+}
+
+// TagLabelsEnabled returns true, if the operator's converter should add the
+// manifest's tags as pod labels
+func TagLabelsEnabled() bool {
+	return tagLabels
+}
+
 // BPMConverter converts BPM information to kubernetes resources
 type BPMConverter struct {
 	volumeFactory           VolumeFactory
 	newContainerFactoryFunc NewContainerFactoryFunc
+	tagLabelsLog            *zap.SugaredLogger
 }
 
 // ContainerFactory builds Kubernetes containers from BOSH jobs.
@@ -63,6 +81,34 @@ func NewConverter(volumeFactory VolumeFactory, newContainerFactoryFunc NewContai
 	}
 }
 
+// EnableTagLabels adds the manifest's tags as labels to the pod templates.
+// Tags, which would overwrite labels set by the operator, are skipped and
+// logged to log.
+func (kc *BPMConverter) EnableTagLabels(log *zap.SugaredLogger) {
+	kc.tagLabelsLog = log
+}
+
+// withTagLabels returns a copy of labels, which includes the manifest's tags,
+// if tag labels are enabled
+func (kc *BPMConverter) withTagLabels(manifest bdm.Manifest, deploymentName string, labels map[string]string) map[string]string {
+	if kc.tagLabelsLog == nil {
+		return labels
+	}
+
+	result := make(map[string]string, len(labels)+len(manifest.Tags))
+	for key, value := range labels {
+		result[key] = value
+	}
+	for key, value := range manifest.TagsAsLabels() {
+		if _, ok := labels[key]; ok || strings.HasPrefix(key, apis.GroupName+"/") {
+			kc.tagLabelsLog.Warnf("Skipping tag '%s' of bosh deployment '%s', it collides with an operator label", key, deploymentName)
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
+
 // Resources contains BPM related k8s resources, which were converted from BOSH objects
 type Resources struct {
 	InstanceGroups         []qstsv1a1.QuarksStatefulSet
@@ -92,6 +138,9 @@ func (kc *BPMConverter) Resources(manifest bdm.Manifest, namespace string, deplo
 	instanceGroup.Env.AgentEnvBoshConfig.Agent.Settings.Set(deploymentName, instanceGroup.Name, qStsVersion)
 
 	defaultDisks := kc.volumeFactory.GenerateDefaultDisks(instanceGroup, igResolvedSecretVersion, namespace)
+	if manifest.TmpfsJobConfigEnabled() {
+		useTmpfsJobConfig(defaultDisks)
+	}
 	bpmDisks, err := kc.volumeFactory.GenerateBPMDisks(instanceGroup, bpmConfigs, namespace)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Generate of BPM disks failed for manifest name %s, instance group %s.", deploymentName, instanceGroup.Name)
@@ -116,7 +165,7 @@ func (kc *BPMConverter) Resources(manifest bdm.Manifest, namespace string, deplo
 	)
 
 	if instanceGroup.IsErrand() {
-		j, err := kc.quarksJob(manifest, namespace, cfac, serviceIP, instanceGroup, defaultDisks, bpmDisks)
+		j, err := kc.quarksJob(manifest, namespace, deploymentName, cfac, serviceIP, instanceGroup, defaultDisks, bpmDisks)
 		if err != nil {
 			return nil, err
 		}
@@ -125,7 +174,7 @@ func (kc *BPMConverter) Resources(manifest bdm.Manifest, namespace string, deplo
 		return res, nil
 	}
 
-	qsts, err := kc.quarksStatefulset(manifest, namespace, cfac, serviceIP, instanceGroup, defaultDisks, bpmDisks, bpmConfigs.ActivePassiveProbes())
+	qsts, err := kc.quarksStatefulset(manifest, namespace, deploymentName, cfac, serviceIP, instanceGroup, defaultDisks, bpmDisks, bpmConfigs.ActivePassiveProbes())
 	if err != nil {
 		return nil, err
 	}
@@ -144,6 +193,7 @@ func (kc *BPMConverter) Resources(manifest bdm.Manifest, namespace string, deplo
 func (kc *BPMConverter) quarksStatefulset(
 	manifest bdm.Manifest,
 	namespace string,
+	deploymentName string,
 	cfac ContainerFactory,
 	serviceIP string,
 	instanceGroup *bdm.InstanceGroup,
@@ -204,7 +254,7 @@ func (kc *BPMConverter) quarksStatefulset(
 					},
 					Template: corev1.PodTemplateSpec{
 						ObjectMeta: metav1.ObjectMeta{
-							Labels:      statefulSetLabels,
+							Labels:      kc.withTagLabels(manifest, deploymentName, statefulSetLabels),
 							Name:        instanceGroup.NameSanitized(),
 							Annotations: instanceGroup.Env.AgentEnvBoshConfig.Agent.Settings.Annotations,
 						},
@@ -328,6 +378,7 @@ func (kc *BPMConverter) service(namespace string, deploymentName string, instanc
 func (kc *BPMConverter) quarksJob(
 	manifest bdm.Manifest,
 	namespace string,
+	deploymentName string,
 	cfac ContainerFactory,
 	serviceIP string,
 	instanceGroup *bdm.InstanceGroup,
@@ -382,7 +433,7 @@ func (kc *BPMConverter) quarksJob(
 					Template: corev1.PodTemplateSpec{
 						ObjectMeta: metav1.ObjectMeta{
 							Name:        instanceGroup.Name,
-							Labels:      podLabels,
+							Labels:      kc.withTagLabels(manifest, deploymentName, podLabels),
 							Annotations: instanceGroup.Env.AgentEnvBoshConfig.Agent.Settings.Annotations,
 						},
 						Spec: corev1.PodSpec{
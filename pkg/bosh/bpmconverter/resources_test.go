@@ -6,6 +6,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/pkg/errors"
+	"go.uber.org/zap/zaptest/observer"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -22,6 +23,7 @@ import (
 	qstsv1a1 "code.cloudfoundry.org/quarks-statefulset/pkg/kube/apis/quarksstatefulset/v1alpha1"
 	"code.cloudfoundry.org/quarks-statefulset/pkg/kube/controllers/statefulset"
 	"code.cloudfoundry.org/quarks-utils/pkg/pointers"
+	helper "code.cloudfoundry.org/quarks-utils/testing/testhelper"
 )
 
 var _ = Describe("BPM Converter", func() {
@@ -742,6 +744,78 @@ var _ = Describe("BPM Converter", func() {
 			})
 		})
 
+		Context("when tag labels are enabled", func() {
+			var (
+				bpmConfigs []bpm.Configs
+				logs       *observer.ObservedLogs
+				actTags    func(bpmConfigs bpm.Configs, instanceGroup *manifest.InstanceGroup) (*bpmconverter.Resources, error)
+			)
+
+			BeforeEach(func() {
+				c, err := bpm.NewConfig([]byte(boshreleases.DefaultBPMConfig))
+				Expect(err).ShouldNot(HaveOccurred())
+
+				bpmConfigs = []bpm.Configs{
+					{"redis-server": c},
+					{"cflinuxfs3-rootfs-setup": c},
+				}
+
+				m.Tags = map[string]string{
+					"Team Name":                "core dev",
+					"custom-label":             "tag",
+					bdv1.LabelDeploymentName:   "tag",
+					"example.com/cost center!": "42",
+				}
+
+				l, log := helper.NewTestLogger()
+				logs = l
+				actTags = func(bpmConfigs bpm.Configs, instanceGroup *manifest.InstanceGroup) (*bpmconverter.Resources, error) {
+					c := bpmconverter.NewConverter(
+						volumeFactory,
+						func(igName string, errand bool, version string, disableLogSidecar bool, releaseImageProvider manifest.ReleaseImageProvider, bpmConfigs bpm.Configs) bpmconverter.ContainerFactory {
+							return containerFactory
+						})
+					c.EnableTagLabels(log)
+					return c.Resources(*m, "foo", deploymentName, "1.2.3.4", "1", instanceGroup, bpmConfigs, "1")
+				}
+			})
+
+			It("adds sanitized tags to the pod template labels of a QuarksStatefulSet", func() {
+				resources, err := actTags(bpmConfigs[1], m.InstanceGroups[1])
+				Expect(err).ShouldNot(HaveOccurred())
+
+				sts := resources.InstanceGroups[0].Spec.Template
+				Expect(sts.Spec.Template.Labels).To(HaveKeyWithValue("Team-Name", "core-dev"))
+				Expect(sts.Spec.Template.Labels).To(HaveKeyWithValue("example.com/cost-center", "42"))
+				Expect(sts.Spec.Template.Labels).To(HaveKeyWithValue(bdv1.LabelDeploymentName, deploymentName))
+
+				Expect(sts.Spec.Selector.MatchLabels).NotTo(HaveKey("Team-Name"))
+				Expect(sts.Labels).NotTo(HaveKey("Team-Name"))
+			})
+
+			It("adds sanitized tags to the pod template labels of a QuarksJob", func() {
+				resources, err := actTags(bpmConfigs[0], m.InstanceGroups[0])
+				Expect(err).ShouldNot(HaveOccurred())
+
+				qJob := resources.Errands[0]
+				Expect(qJob.Spec.Template.Spec.Template.Labels).To(HaveKeyWithValue("Team-Name", "core-dev"))
+				Expect(qJob.Spec.Template.Spec.Template.Labels).To(HaveKeyWithValue(qstsv1a1.LabelPodOrdinal, "0"))
+				Expect(qJob.GetLabels()).NotTo(HaveKey("Team-Name"))
+			})
+
+			It("skips and logs tags colliding with operator labels", func() {
+				resources, err := actTags(bpmConfigs[0], m.InstanceGroups[0])
+				Expect(err).ShouldNot(HaveOccurred())
+
+				labels := resources.Errands[0].Spec.Template.Spec.Template.Labels
+				Expect(labels).To(HaveKeyWithValue("custom-label", "foo"))
+				Expect(labels).To(HaveKeyWithValue(bdv1.LabelDeploymentName, deploymentName))
+
+				Expect(logs.FilterMessageSnippet("Skipping tag 'custom-label'").Len()).To(Equal(1))
+				Expect(logs.FilterMessageSnippet(fmt.Sprintf("Skipping tag '%s'", bdv1.LabelDeploymentName)).Len()).To(Equal(1))
+			})
+		})
+
 		Context("when tolerations are provided", func() {
 			var bpmConfigs []bpm.Configs
 
@@ -483,6 +483,16 @@ func jobsDirVolumeMount() *corev1.VolumeMount {
 	}
 }
 
+// useTmpfsJobConfig backs the jobs directory, which contains the rendered
+// job configs, by memory instead of the node's disk
+func useTmpfsJobConfig(disks bdm.Disks) {
+	for _, disk := range disks {
+		if disk.Volume != nil && disk.Volume.Name == VolumeJobsDirName && disk.Volume.EmptyDir != nil {
+			disk.Volume.EmptyDir.Medium = corev1.StorageMediumMemory
+		}
+	}
+}
+
 func resolvedPropertiesVolume(name string) *corev1.Volume {
 	return &corev1.Volume{
 		Name: bdv1.DeploymentSecretTypeInstanceGroupResolvedProperties.String(),
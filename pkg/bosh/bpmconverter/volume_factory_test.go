@@ -101,6 +101,25 @@ var _ = Describe("VolumeFactory", func() {
 		})
 	})
 
+	Describe("useTmpfsJobConfig", func() {
+		It("backs the jobs dir by memory", func() {
+			disks := factory.GenerateDefaultDisks(instanceGroup, version, namespace)
+			useTmpfsJobConfig(disks)
+
+			for _, disk := range disks {
+				if disk.Volume == nil || disk.Volume.EmptyDir == nil {
+					continue
+				}
+				switch disk.Volume.Name {
+				case VolumeJobsDirName, VolumeDrainStampsName:
+					Expect(disk.Volume.EmptyDir.Medium).To(Equal(corev1.StorageMediumMemory))
+				default:
+					Expect(disk.Volume.EmptyDir.Medium).To(Equal(corev1.StorageMediumDefault))
+				}
+			}
+		})
+	})
+
 	Describe("GenerateBPMDisks", func() {
 		It("creates ephemeral disk", func() {
 			bpmConfigs = &bpm.Configs{
@@ -73,6 +73,50 @@ func (m *Manifest) instanceGroupMatch(instanceGroup *InstanceGroup, rules *AddOn
 	return false, nil
 }
 
+// networkMatch matches network rules for addon placement
+func (m *Manifest) networkMatch(instanceGroup *InstanceGroup, rules *AddOnPlacementRules) (bool, error) {
+	if instanceGroup == nil || rules == nil {
+		return false, nil
+	}
+
+	for _, network := range instanceGroup.Networks {
+		for _, name := range rules.Networks {
+			if network.Name == name {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// tagsMatch matches tag rules for addon placement. All tags of the rule
+// need to be present in the manifest's tags.
+func (m *Manifest) tagsMatch(instanceGroup *InstanceGroup, rules *AddOnPlacementRules) (bool, error) {
+	if instanceGroup == nil || rules == nil || len(rules.Tags) == 0 {
+		return false, nil
+	}
+
+	for key, value := range rules.Tags {
+		if tag, ok := m.Tags[key]; !ok || tag != value {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// hasMatchers returns true if the rules contain any criteria besides the lifecycle
+func (rules *AddOnPlacementRules) hasMatchers() bool {
+	return len(rules.Stemcell) > 0 ||
+		len(rules.Deployments) > 0 ||
+		len(rules.Jobs) > 0 ||
+		len(rules.InstanceGroup) > 0 ||
+		len(rules.Networks) > 0 ||
+		len(rules.Teams) > 0 ||
+		len(rules.Tags) > 0
+}
+
 // addOnPlacementMatch returns true if any placement rule of the addon matches the instance group
 func (m *Manifest) addOnPlacementMatch(log *zap.SugaredLogger, placementType string, instanceGroup *InstanceGroup, rules *AddOnPlacementRules) (bool, error) {
 	// This check is special, not a matcher. Lifecycle always needs to match
@@ -90,10 +134,17 @@ func (m *Manifest) addOnPlacementMatch(log *zap.SugaredLogger, placementType str
 		return false, nil
 	}
 
+	// Rules which only specify a lifecycle match all instance groups of that lifecycle
+	if rules != nil && rules.Lifecycle != IGTypeDefault && !rules.hasMatchers() {
+		return true, nil
+	}
+
 	matchers := []matcher{
 		m.stemcellMatch,
 		m.jobMatch,
 		m.instanceGroupMatch,
+		m.networkMatch,
+		m.tagsMatch,
 	}
 
 	matchResult := false
@@ -113,3 +164,44 @@ func (m *Manifest) addOnPlacementMatch(log *zap.SugaredLogger, placementType str
 
 	return matchResult, nil
 }
+
+// addOnMatch returns true if the addon should be applied to the instance group
+func (m *Manifest) addOnMatch(log *zap.SugaredLogger, addon *AddOn, ig *InstanceGroup) (bool, error) {
+	include, err := m.addOnPlacementMatch(log, "inclusion", ig, addon.Include)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to process include placement matches")
+	}
+	exclude, err := m.addOnPlacementMatch(log, "exclusion", ig, addon.Exclude)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to process exclude placement matches")
+	}
+
+	if exclude || !include {
+		log.Debugf("Addon '%s' doesn't match instance group '%s'", addon.Name, ig.Name)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// addOnApplied returns true if the jobs of the addon were already added to the instance group.
+// Addon jobs from before addon names were recorded are matched by job and release name.
+func (ig *InstanceGroup) addOnApplied(addon *AddOn) bool {
+	for _, job := range ig.Jobs {
+		if !job.Properties.Quarks.IsAddon {
+			continue
+		}
+		if job.Properties.Quarks.AddOnName == addon.Name {
+			return true
+		}
+		if job.Properties.Quarks.AddOnName != "" {
+			continue
+		}
+		for _, addonJob := range addon.Jobs {
+			if addonJob.Name == job.Name && addonJob.Release == job.Release {
+				return true
+			}
+		}
+	}
+	return false
+}
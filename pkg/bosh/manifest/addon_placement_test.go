@@ -51,6 +51,157 @@ var _ = Describe("Addons", func() {
 		Expect(manifest.InstanceGroups[2].Jobs[1].Name).To(Equal("addon-job3"))
 	})
 
+	It("should report that addons were applied", func() {
+		Expect(manifest.AddonsApplied()).To(BeFalse())
+
+		err := manifest.ApplyAddons(log)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.AddonsApplied()).To(BeTrue())
+	})
+
+	It("should apply addons again, but only once, after a marshal and reload", func() {
+		err := manifest.ApplyAddons(log)
+		Expect(err).NotTo(HaveOccurred())
+
+		bytes, err := manifest.Marshal()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(bytes)).ToNot(ContainSubstring("addons_applied"))
+
+		reloaded, err := LoadYAML(bytes)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reloaded.AddonsApplied()).To(BeFalse())
+
+		err = reloaded.ApplyAddons(log)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reloaded.AddonsApplied()).To(BeTrue())
+		Expect(reloaded.InstanceGroups[0].Jobs).To(HaveLen(3))
+		Expect(reloaded.InstanceGroups[1].Jobs).To(HaveLen(2))
+		Expect(reloaded.InstanceGroups[2].Jobs).To(HaveLen(2))
+	})
+
+	It("should only apply new addons when called again", func() {
+		err := manifest.ApplyAddons(log)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.InstanceGroups[0].Jobs).To(HaveLen(3))
+		Expect(manifest.InstanceGroups[0].Jobs[1].Properties.Quarks.AddOnName).To(Equal("test"))
+
+		manifest.AddOns = append(manifest.AddOns, &AddOn{
+			Name:    "test4",
+			Jobs:    []AddOnJob{{Name: "addon-job4", Release: "redis"}},
+			Include: &AddOnPlacementRules{InstanceGroup: []string{"redis-slave"}},
+		})
+		err = manifest.ApplyAddons(log)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(manifest.InstanceGroups[0].Jobs).To(HaveLen(4))
+		Expect(manifest.InstanceGroups[0].Jobs[3].Name).To(Equal("addon-job4"))
+		Expect(manifest.InstanceGroups[1].Jobs).To(HaveLen(2))
+		Expect(manifest.InstanceGroups[2].Jobs).To(HaveLen(2))
+	})
+
+	It("should skip the given addons", func() {
+		err := manifest.ApplyAddonsExcept(log, []string{"test", "test3"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(manifest.InstanceGroups[0].Jobs).To(HaveLen(2))
+		Expect(manifest.InstanceGroups[0].Jobs[1].Name).To(Equal("addon-job2"))
+		Expect(manifest.InstanceGroups[1].Jobs).To(HaveLen(1))
+		Expect(manifest.InstanceGroups[2].Jobs).To(HaveLen(1))
+
+		Expect(logs.FilterMessage("Skipping addon 'test'").Len()).To(Equal(1))
+		Expect(logs.FilterMessage("Skipping addon 'test3'").Len()).To(Equal(1))
+	})
+
+	It("should always skip the bosh-dns addon", func() {
+		manifest.AddOns = append(manifest.AddOns, &AddOn{
+			Name:    BoshDNSAddOnName,
+			Jobs:    []AddOnJob{{Name: "bosh-dns", Release: "bosh-dns"}},
+			Include: &AddOnPlacementRules{InstanceGroup: []string{"redis-slave"}},
+		})
+
+		err := manifest.ApplyAddonsExcept(log, []string{"test"})
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, job := range manifest.InstanceGroups[0].Jobs {
+			Expect(job.Name).NotTo(Equal("bosh-dns"))
+		}
+	})
+
+	It("should preview addon placement without changing the manifest", func() {
+		preview, err := manifest.PreviewAddons(log)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(preview).To(Equal(map[string][]string{
+			"test":  {"redis-slave", "diego-cell"},
+			"test2": {"redis-slave"},
+			"test3": {"redis-slave-errand"},
+		}))
+
+		Expect(manifest.AddOnsApplied).To(BeFalse())
+		Expect(manifest.InstanceGroups[0].Jobs).To(HaveLen(1))
+	})
+
+	Context("when using network placement rules", func() {
+		JustBeforeEach(func() {
+			manifest.InstanceGroups[1].Networks = append(manifest.InstanceGroups[1].Networks, &Network{Name: "routable"})
+		})
+
+		It("should include instance groups on any of the networks", func() {
+			manifest.AddOns[0].Include = &AddOnPlacementRules{Networks: []string{"routable", "unknown"}}
+
+			preview, err := manifest.PreviewAddons(log)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview["test"]).To(Equal([]string{"diego-cell"}))
+		})
+
+		It("should exclude instance groups on any of the networks", func() {
+			manifest.AddOns[0].Exclude = &AddOnPlacementRules{Networks: []string{"routable"}}
+
+			preview, err := manifest.PreviewAddons(log)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview["test"]).To(Equal([]string{"redis-slave"}))
+		})
+	})
+
+	Context("when using tag placement rules", func() {
+		JustBeforeEach(func() {
+			manifest.Tags = map[string]string{"env": "prod", "team": "security"}
+		})
+
+		It("should include all instance groups if all tags match", func() {
+			manifest.AddOns[1].Include = &AddOnPlacementRules{Tags: map[string]string{"env": "prod", "team": "security"}}
+
+			preview, err := manifest.PreviewAddons(log)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview["test2"]).To(Equal([]string{"redis-slave"}))
+		})
+
+		It("should not include instance groups if a tag is different", func() {
+			manifest.AddOns[1].Include = &AddOnPlacementRules{Tags: map[string]string{"env": "prod", "team": "dev"}}
+
+			preview, err := manifest.PreviewAddons(log)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview["test2"]).To(BeEmpty())
+		})
+	})
+
+	Context("when using lifecycle placement rules", func() {
+		It("should only include errands", func() {
+			manifest.AddOns[0].Include = &AddOnPlacementRules{Lifecycle: IGTypeErrand}
+
+			preview, err := manifest.PreviewAddons(log)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview["test"]).To(Equal([]string{"redis-slave-errand"}))
+		})
+
+		It("should only include services", func() {
+			manifest.AddOns[0].Include = &AddOnPlacementRules{Lifecycle: IGTypeService}
+
+			preview, err := manifest.PreviewAddons(log)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview["test"]).To(Equal([]string{"redis-slave", "diego-cell"}))
+		})
+	})
+
 	Context("when using trace logger", func() {
 		BeforeEach(func() {
 			logger.Trace = true
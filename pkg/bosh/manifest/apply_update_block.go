@@ -1,6 +1,14 @@
 package manifest
 
-import "code.cloudfoundry.org/quarks-operator/pkg/kube/util/names"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"code.cloudfoundry.org/quarks-operator/pkg/kube/util/names"
+)
 
 // ApplyUpdateBlock interprets and propagates information of the 'update'-blocks
 func (m *Manifest) ApplyUpdateBlock() {
@@ -39,3 +47,37 @@ func (m *Manifest) calculateRequiredServices() {
 		}
 	}
 }
+
+// SerialConflicts returns the names of the instance groups, which disable
+// serial updates, although the global update block explicitly enables them.
+// These instance groups are not part of the serial rollout order, which is
+// likely unintended.
+func (m *Manifest) SerialConflicts() []string {
+	conflicts := []string{}
+	if m.Update == nil || m.Update.Serial == nil || !*m.Update.Serial {
+		return conflicts
+	}
+
+	for _, ig := range m.InstanceGroups {
+		if ig.Update != nil && ig.Update.Serial != nil && !*ig.Update.Serial {
+			conflicts = append(conflicts, ig.Name)
+		}
+	}
+	return conflicts
+}
+
+// ValidateSerial warns about the SerialConflicts. In strict mode the
+// conflicts are returned as an error instead.
+func (m *Manifest) ValidateSerial(log *zap.SugaredLogger, strict bool) error {
+	conflicts := m.SerialConflicts()
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("instance groups [%s] disable serial updates, but the global update block enables them", strings.Join(conflicts, ", "))
+	if strict {
+		return errors.New(msg)
+	}
+	log.Warn(msg)
+	return nil
+}
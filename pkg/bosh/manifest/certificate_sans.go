@@ -0,0 +1,58 @@
+package manifest
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	crc "sigs.k8s.io/controller-runtime/pkg/client"
+
+	qsv1a1 "code.cloudfoundry.org/quarks-secret/pkg/kube/apis/quarkssecret/v1alpha1"
+)
+
+// ResolveCertificateSANs returns the alternative names, which will be
+// requested for the certificate variables with service references, indexed by
+// variable name. Besides the declared alternative names, these are the
+// service's names up to '<name>.<namespace>.svc' and its cluster IP. The
+// cluster domain is not appended.
+func (m *Manifest) ResolveCertificateSANs(ctx context.Context, client crc.Client, namespace string) (map[string][]string, error) {
+	sans := map[string][]string{}
+	for _, v := range m.Variables {
+		if v.Type != qsv1a1.Certificate || v.Options == nil || len(v.Options.ServiceRef) == 0 {
+			continue
+		}
+
+		seen := map[string]bool{}
+		names := []string{}
+		add := func(name string) {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+
+		for _, name := range v.Options.AlternativeNames {
+			add(name)
+		}
+
+		for _, ref := range v.Options.ServiceRef {
+			service := &corev1.Service{}
+			err := client.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, service)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get service '%s/%s' referenced by certificate variable '%s'", namespace, ref.Name, v.Name)
+			}
+
+			add(service.Name)
+			add(service.Name + "." + namespace)
+			add(service.Name + "." + namespace + ".svc")
+			if service.Spec.ClusterIP != corev1.ClusterIPNone {
+				add(service.Spec.ClusterIP)
+			}
+		}
+
+		sans[v.Name] = names
+	}
+
+	return sans, nil
+}
@@ -0,0 +1,79 @@
+package manifest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// gzipMagic are the first bytes of gzip compressed data, see RFC 1952.
+// MarshalCompressed always writes them and LoadYAMLAuto relies on them to
+// tell compressed from plain manifests.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isGzip returns true if data starts with the gzip magic bytes. Valid yaml
+// can't start with them, as 0x8b is not valid UTF-8.
+func isGzip(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// LoadYAMLAuto returns a new BOSH deployment manifest from a yaml
+// representation, which may be gzip compressed
+func LoadYAMLAuto(data []byte) (*Manifest, error) {
+	if !isGzip(data) {
+		return LoadYAML(data)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read gzip compressed BOSH deployment manifest")
+	}
+	defer r.Close()
+
+	decompressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress BOSH deployment manifest")
+	}
+
+	return LoadYAML(decompressed)
+}
+
+// MarshalCompressed serializes a BOSH manifest into gzip compressed yaml. The
+// yaml is the same as returned by Marshal. Use LoadYAMLAuto to load it.
+func (m *Manifest) MarshalCompressed() ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if err := m.MarshalTo(w); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal BOSH deployment manifest")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to compress BOSH deployment manifest")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MaxSecretSize is the maximum size of a kubernetes secret's data, which
+// limits the size of stored manifests
+const MaxSecretSize = 1024 * 1024
+
+// EstimateStoredSize returns the number of bytes the manifest needs, when it
+// is stored in a secret. This is the length of the yaml returned by Marshal,
+// after anchor compression. The yaml is not buffered.
+func (m *Manifest) EstimateStoredSize() (int, error) {
+	var w countingWriter
+	if err := m.MarshalTo(&w); err != nil {
+		return 0, errors.Wrap(err, "failed to marshal BOSH deployment manifest")
+	}
+	return int(w), nil
+}
+
+// countingWriter discards the data written to it, but counts the bytes
+type countingWriter int
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
@@ -20,6 +20,7 @@ type Quarks struct {
 	PostStart           bpm.PostStart           `json:"post_start"`
 	Debug               bool                    `json:"debug" yaml:"debug"`
 	IsAddon             bool                    `json:"is_addon" yaml:"is_addon"`
+	AddOnName           string                  `json:"addon_name,omitempty" yaml:"addon_name,omitempty"`
 	Envs                []corev1.EnvVar         `json:"envs" yaml:"envs"`
 	ActivePassiveProbes map[string]corev1.Probe `json:"activePassiveProbes,omitempty"`
 }
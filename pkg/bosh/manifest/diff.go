@@ -0,0 +1,123 @@
+package manifest
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Diff returns a human readable list of changes from this manifest to the
+// other manifest. It is not a complete structural diff, but covers instance
+// group counts, releases, stemcells and variables, e.g. for logging.
+func (m *Manifest) Diff(other *Manifest) ([]string, error) {
+	if other == nil {
+		return nil, errors.New("can't diff against a nil manifest")
+	}
+
+	changes := []string{}
+	changes = append(changes, diffInstanceGroups(m.InstanceGroups, other.InstanceGroups)...)
+	changes = append(changes, diffReleases(m.Releases, other.Releases)...)
+	changes = append(changes, diffStemcells(m.Stemcells, other.Stemcells)...)
+	changes = append(changes, diffVariables(m.Variables, other.Variables)...)
+
+	return changes, nil
+}
+
+func diffInstanceGroups(from InstanceGroups, to InstanceGroups) []string {
+	changes := []string{}
+	for _, ig := range from {
+		newIG, ok := to.InstanceGroupByName(ig.Name)
+		if !ok {
+			changes = append(changes, fmt.Sprintf("instance_group %s: removed", ig.Name))
+			continue
+		}
+		if ig.Instances != newIG.Instances {
+			changes = append(changes, fmt.Sprintf("instance_group %s: instances %d -> %d", ig.Name, ig.Instances, newIG.Instances))
+		}
+	}
+	for _, ig := range to {
+		if _, ok := from.InstanceGroupByName(ig.Name); !ok {
+			changes = append(changes, fmt.Sprintf("instance_group %s: added", ig.Name))
+		}
+	}
+	return changes
+}
+
+func diffReleases(from []*Release, to []*Release) []string {
+	newReleases := map[string]*Release{}
+	for _, r := range to {
+		newReleases[r.Name] = r
+	}
+
+	changes := []string{}
+	oldReleases := map[string]bool{}
+	for _, r := range from {
+		oldReleases[r.Name] = true
+		newRelease, ok := newReleases[r.Name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("release %s: removed", r.Name))
+			continue
+		}
+		if r.Version != newRelease.Version {
+			changes = append(changes, fmt.Sprintf("release %s: version %s -> %s", r.Name, r.Version, newRelease.Version))
+		}
+	}
+	for _, r := range to {
+		if !oldReleases[r.Name] {
+			changes = append(changes, fmt.Sprintf("release %s: added", r.Name))
+		}
+	}
+	return changes
+}
+
+func diffStemcells(from []*Stemcell, to []*Stemcell) []string {
+	newStemcells := map[string]*Stemcell{}
+	for _, s := range to {
+		newStemcells[s.Alias] = s
+	}
+
+	changes := []string{}
+	oldStemcells := map[string]bool{}
+	for _, s := range from {
+		oldStemcells[s.Alias] = true
+		newStemcell, ok := newStemcells[s.Alias]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("stemcell %s: removed", s.Alias))
+			continue
+		}
+		if s.OS != newStemcell.OS {
+			changes = append(changes, fmt.Sprintf("stemcell %s: os %s -> %s", s.Alias, s.OS, newStemcell.OS))
+		}
+		if s.Version != newStemcell.Version {
+			changes = append(changes, fmt.Sprintf("stemcell %s: version %s -> %s", s.Alias, s.Version, newStemcell.Version))
+		}
+	}
+	for _, s := range to {
+		if !oldStemcells[s.Alias] {
+			changes = append(changes, fmt.Sprintf("stemcell %s: added", s.Alias))
+		}
+	}
+	return changes
+}
+
+func diffVariables(from []Variable, to []Variable) []string {
+	newVariables := map[string]bool{}
+	for _, v := range to {
+		newVariables[v.Name] = true
+	}
+
+	changes := []string{}
+	oldVariables := map[string]bool{}
+	for _, v := range from {
+		oldVariables[v.Name] = true
+		if !newVariables[v.Name] {
+			changes = append(changes, fmt.Sprintf("variable %s: removed", v.Name))
+		}
+	}
+	for _, v := range to {
+		if !oldVariables[v.Name] {
+			changes = append(changes, fmt.Sprintf("variable %s: added", v.Name))
+		}
+	}
+	return changes
+}
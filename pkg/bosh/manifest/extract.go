@@ -0,0 +1,98 @@
+package manifest
+
+import (
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"sigs.k8s.io/yaml"
+)
+
+// ExtractErrand returns a copy of the manifest, which only contains the
+// errand instance group with the given name. Releases, stemcells and addons
+// which are not used by the errand are removed, the instance group lists of
+// the addon placement rules only contain the errand.
+func (m *Manifest) ExtractErrand(instanceGroupName string) (*Manifest, error) {
+	ig, ok := m.InstanceGroup(instanceGroupName)
+	if !ok {
+		return nil, errors.Errorf("instance group '%s' not found", instanceGroupName)
+	}
+	if !ig.IsErrand() {
+		return nil, errors.Errorf("instance group '%s' is not an errand, its lifecycle is '%s'", instanceGroupName, ig.LifeCycle)
+	}
+
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal manifest")
+	}
+	extracted, err := LoadYAML(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to copy manifest")
+	}
+	extracted.imageRegistry = m.imageRegistry
+	extracted.singleStemcellFallback = m.singleStemcellFallback
+
+	ig, _ = extracted.InstanceGroup(instanceGroupName)
+	extracted.InstanceGroups = InstanceGroups{ig}
+
+	releases := map[string]bool{}
+	for _, job := range ig.Jobs {
+		releases[job.Release] = true
+	}
+
+	addons := []*AddOn{}
+	log := zap.NewNop().Sugar()
+	for _, addon := range extracted.AddOns {
+		match, err := extracted.addOnMatch(log, addon, ig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to match addon '%s'", addon.Name)
+		}
+		if !match {
+			continue
+		}
+
+		for _, rules := range []*AddOnPlacementRules{addon.Include, addon.Exclude} {
+			if rules != nil && len(rules.InstanceGroup) > 0 {
+				rules.InstanceGroup = pruneNames(rules.InstanceGroup, instanceGroupName)
+			}
+		}
+		// The exclusion didn't match the errand. Without matchers it would
+		// exclude all instance groups of its lifecycle.
+		if addon.Exclude != nil && !addon.Exclude.hasMatchers() {
+			addon.Exclude = nil
+		}
+		for _, job := range addon.Jobs {
+			releases[job.Release] = true
+		}
+		addons = append(addons, addon)
+	}
+	extracted.AddOns = addons
+
+	keptReleases := []*Release{}
+	for _, release := range extracted.Releases {
+		if releases[release.Name] {
+			keptReleases = append(keptReleases, release)
+		}
+	}
+	extracted.Releases = keptReleases
+
+	// Without an alias the stemcell can only be resolved if there is exactly one
+	if ig.Stemcell != "" {
+		keptStemcells := []*Stemcell{}
+		if stemcell := extracted.instanceGroupStemcell(ig); stemcell != nil {
+			keptStemcells = append(keptStemcells, stemcell)
+		}
+		extracted.Stemcells = keptStemcells
+	}
+
+	return extracted, nil
+}
+
+// pruneNames returns the names, which are equal to name
+func pruneNames(names []string, name string) []string {
+	pruned := []string{}
+	for _, n := range names {
+		if n == name {
+			pruned = append(pruned, n)
+		}
+	}
+	return pruned
+}
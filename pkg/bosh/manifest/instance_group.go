@@ -219,7 +219,9 @@ type Network struct {
 
 // Update from BOSH deployment manifest.
 type Update struct {
-	Canaries        int     `json:"canaries"`
+	Canaries int `json:"canaries"`
+	// MaxInFlight is an integer or a percentage, e.g. '2' or '20%'. LoadYAML
+	// converts unquoted integers to strings.
 	MaxInFlight     string  `json:"max_in_flight"`
 	CanaryWatchTime string  `json:"canary_watch_time"`
 	UpdateWatchTime string  `json:"update_watch_time"`
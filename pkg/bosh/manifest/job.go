@@ -83,6 +83,12 @@ func (p *JobProperties) UnmarshalJSON(b []byte) error {
 	return p.FromMap(j)
 }
 
+// QuarksProperties returns the job's 'quarks' properties, which configure
+// the kubernetes resources of the job
+func (j *Job) QuarksProperties() *Quarks {
+	return &j.Properties.Quarks
+}
+
 func (j *Job) specDir(baseDir string) string {
 	return filepath.Join(baseDir, "jobs-src", j.Release, j.Name)
 }
@@ -0,0 +1,163 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// WarningCode identifies the kind of a lint warning
+type WarningCode string
+
+// Codes of the lint warnings
+const (
+	WarningZeroInstances        WarningCode = "zero-instances"
+	WarningMissingProperties    WarningCode = "missing-properties"
+	WarningDuplicateTag         WarningCode = "duplicate-tag"
+	WarningUnusedRelease        WarningCode = "unused-release"
+	WarningAddOnMatchesNoGroups WarningCode = "addon-matches-no-instance-group"
+	WarningUnreachableLink      WarningCode = "unreachable-link"
+)
+
+// Warning describes a non-fatal issue found by Lint
+type Warning struct {
+	Code    WarningCode
+	Message string
+}
+
+// String returns the warning prefixed by its code
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Code, w.Message)
+}
+
+// Lint checks the manifest for common mistakes, which are valid BOSH, but
+// likely not intended. Unlike Validate it doesn't report errors.
+func (m *Manifest) Lint() []Warning {
+	warnings := []Warning{}
+
+	for _, ig := range m.InstanceGroups {
+		if ig.Instances == 0 {
+			warnings = append(warnings, Warning{
+				Code:    WarningZeroInstances,
+				Message: fmt.Sprintf("instance group '%s' has zero instances", ig.Name),
+			})
+		}
+	}
+
+	warnings = append(warnings, m.lintTags()...)
+	warnings = append(warnings, m.lintReleases()...)
+	warnings = append(warnings, m.lintAddOns()...)
+	warnings = append(warnings, m.ListUnreachableLinks()...)
+
+	return warnings
+}
+
+// LintJobSpecs reports jobs without properties, whose job spec declares
+// properties without a default. Job specs are indexed by release and job
+// name, like for ListProviderTypeMismatches.
+func (m *Manifest) LintJobSpecs(jobSpecs map[string]map[string]JobSpec) []Warning {
+	warnings := []Warning{}
+	for _, ig := range m.InstanceGroups {
+		for _, job := range ig.Jobs {
+			if len(job.Properties.Properties) > 0 {
+				continue
+			}
+			spec, ok := jobSpecs[job.Release][job.Name]
+			if !ok {
+				continue
+			}
+
+			required := []string{}
+			for name, property := range spec.Properties {
+				if property.Default == nil {
+					required = append(required, name)
+				}
+			}
+			if len(required) == 0 {
+				continue
+			}
+			sort.Strings(required)
+
+			warnings = append(warnings, Warning{
+				Code: WarningMissingProperties,
+				Message: fmt.Sprintf("job '%s' in instance group '%s' has no properties, but release '%s' requires: %s",
+					job.Name, ig.Name, job.Release, strings.Join(required, ", ")),
+			})
+		}
+	}
+
+	return warnings
+}
+
+// lintTags reports tags, which only differ in case. Exact duplicates are
+// already merged when loading the YAML.
+func (m *Manifest) lintTags() []Warning {
+	keys := make([]string, 0, len(m.Tags))
+	for key := range m.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	seen := map[string]string{}
+	warnings := []Warning{}
+	for _, key := range keys {
+		lower := strings.ToLower(key)
+		if first, ok := seen[lower]; ok {
+			warnings = append(warnings, Warning{
+				Code:    WarningDuplicateTag,
+				Message: fmt.Sprintf("tag '%s' duplicates tag '%s'", key, first),
+			})
+			continue
+		}
+		seen[lower] = key
+	}
+
+	return warnings
+}
+
+// lintReleases reports releases, which are not used by any instance group or
+// addon job
+func (m *Manifest) lintReleases() []Warning {
+	used := m.usedReleases()
+	warnings := []Warning{}
+	for _, release := range m.Releases {
+		if !used[release.Name] {
+			warnings = append(warnings, Warning{
+				Code:    WarningUnusedRelease,
+				Message: fmt.Sprintf("release '%s' is not used by any job", release.Name),
+			})
+		}
+	}
+
+	return warnings
+}
+
+// lintAddOns reports addons, whose placement rules match no instance group.
+// The bosh-dns addon is skipped, as it's not applied to instance groups.
+func (m *Manifest) lintAddOns() []Warning {
+	log := zap.NewNop().Sugar()
+	warnings := []Warning{}
+	for _, addon := range m.AddOns {
+		if addon.Name == BoshDNSAddOnName {
+			continue
+		}
+
+		matched := false
+		for _, ig := range m.InstanceGroups {
+			if match, err := m.addOnMatch(log, addon, ig); err == nil && match {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			warnings = append(warnings, Warning{
+				Code:    WarningAddOnMatchesNoGroups,
+				Message: fmt.Sprintf("addon '%s' matches no instance group", addon.Name),
+			})
+		}
+	}
+
+	return warnings
+}
@@ -6,11 +6,15 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -25,6 +29,8 @@ import (
 const (
 	// DesiredManifestKeyName is the name of the key in desired manifest secret
 	DesiredManifestKeyName = "manifest.yaml"
+	// ChecksumPrefix is the prefix of the manifest checksum, naming the hash algorithm
+	ChecksumPrefix = "sha256:"
 )
 
 // ReleaseImageProvider interface to provide the docker release image for a BOSH job
@@ -42,6 +48,19 @@ type Feature struct {
 	UseTmpfsJobConfig    *bool `json:"use_tmpfs_job_config,omitempty"`
 }
 
+// DNSAddressesEnabled returns true if the manifest enables the
+// use_dns_addresses feature. Links to native providers then use DNS names
+// instead of IPs for the instance addresses.
+func (m *Manifest) DNSAddressesEnabled() bool {
+	return m.Features != nil && m.Features.UseDNSAddresses != nil && *m.Features.UseDNSAddresses
+}
+
+// TmpfsJobConfigEnabled returns true if the manifest enables the
+// use_tmpfs_job_config feature, which keeps the rendered job configs in memory
+func (m *Manifest) TmpfsJobConfigEnabled() bool {
+	return m.Features != nil && m.Features.UseTmpfsJobConfig != nil && *m.Features.UseTmpfsJobConfig
+}
+
 // AuthType from BOSH deployment manifest
 type AuthType string
 
@@ -103,6 +122,7 @@ type Release struct {
 	Version  string           `json:"version"`
 	URL      string           `json:"url,omitempty"`
 	SHA1     string           `json:"sha1,omitempty"`
+	Digest   string           `json:"digest,omitempty"`
 	Stemcell *ReleaseStemcell `json:"stemcell,omitempty"`
 }
 
@@ -134,9 +154,18 @@ type AddOnPlacementRules struct {
 	InstanceGroup []string             `json:"instance_groups,omitempty"`
 	Networks      []string             `json:"networks,omitempty"`
 	Teams         []string             `json:"teams,omitempty"`
+	Tags          map[string]string    `json:"tags,omitempty"`
 	Lifecycle     InstanceGroupType    `json:"lifecycle,omitempty"`
 }
 
+// ManifestNetwork is a network declared in the networks section of the BOSH
+// deployment manifest. Instance groups reference it by name.
+type ManifestNetwork struct {
+	Name    string                   `json:"name"`
+	Type    string                   `json:"type,omitempty"`
+	Subnets []map[string]interface{} `json:"subnets,omitempty"`
+}
+
 // AddOn from BOSH deployment manifest
 type AddOn struct {
 	Name    string               `json:"name"`
@@ -157,68 +186,434 @@ type Manifest struct {
 	Properties     map[string]interface{} `json:"properties,omitempty"`
 	Variables      []Variable             `json:"variables,omitempty"`
 	Update         *Update                `json:"update,omitempty"`
-	AddOnsApplied  bool                   `json:"addons_applied,omitempty"`
+	Networks       []*ManifestNetwork     `json:"networks,omitempty"`
+	// AddOnsApplied is set by ApplyAddons. It is not persisted by Marshal,
+	// so a reloaded manifest gets its addons applied again when it's
+	// resolved. This is idempotent, as addon jobs record their addon in
+	// their quarks properties. Use AddonsApplied to query it.
+	AddOnsApplied bool `json:"-"`
+
+	// imageRegistry replaces the registry host of all release images
+	imageRegistry string
+	// singleStemcellFallback uses the only declared stemcell for instance
+	// groups without a stemcell alias
+	singleStemcellFallback bool
 }
 
 // duplicateYamlValue is a struct used for size compression
 // in Marshal function  to store the yaml values of
 // significant size and which occur more than once.
 type duplicateYamlValue struct {
-	Hash          string
-	YamlKeyMarker string
+	Hash string
+	Key  string
+}
+
+// anchorMarkerPrefix prefixes the temporary keys, which markDuplicateValues
+// uses to mark anchored values. The marker doesn't contain the original key,
+// so no key name can break the rewrite. A real key would have to contain the
+// SHA1 of an anchored value to collide with a marker.
+const anchorMarkerPrefix = "quarks-anchor-"
+
+// anchorMarker returns the temporary key for the anchor with the given hash
+func anchorMarker(hash string) string {
+	return anchorMarkerPrefix + hash
+}
+
+// encodeYamlKey returns the yaml representation of a key, quoted if needed
+func encodeYamlKey(key string) string {
+	out, err := goyaml.Marshal(key)
+	if err != nil || bytes.Count(out, []byte("\n")) > 1 {
+		return strconv.Quote(key)
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+// DefaultAnchorMinLength is the minimum length of a yaml string value, before
+// Marshal replaces its duplicates with an anchor
+const DefaultAnchorMinLength = 64
+
+// MarshalOptions tune the serialization of a BOSH manifest
+type MarshalOptions struct {
+	// AnchorMinLength is the minimum length of string values, which are
+	// replaced by yaml anchors if they occur more than once. A value of zero
+	// or less disables the anchor compression.
+	AnchorMinLength int
+	// NoAnchorPaths lists dotted paths of values, which are never replaced
+	// by yaml anchors, e.g. 'instance_groups.jobs.properties.tls.key'.
+	// Lists don't add a path segment and '*' matches any key. The values
+	// below a path are excluded, too.
+	NoAnchorPaths []string
+}
+
+// anchorExcluded returns true if the value at path must not be anchored
+func (opts MarshalOptions) anchorExcluded(path []string) bool {
+	for _, p := range opts.NoAnchorPaths {
+		segments := strings.Split(p, ".")
+		if len(segments) > len(path) {
+			continue
+		}
+
+		match := true
+		for i, segment := range segments {
+			if segment != "*" && segment != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultMarshalOptions returns the options used by Marshal
+func DefaultMarshalOptions() MarshalOptions {
+	return MarshalOptions{AnchorMinLength: DefaultAnchorMinLength}
 }
 
 // LoadYAML returns a new BOSH deployment manifest from a yaml representation
 func LoadYAML(data []byte) (*Manifest, error) {
 	m := &Manifest{}
-	err := yaml.Unmarshal(data, m, func(opt *json.Decoder) *json.Decoder {
-		opt.UseNumber()
-		return opt
-	})
+	err := yaml.Unmarshal(data, m, useNumber)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to unmarshal BOSH deployment manifest %s", string(data))
+		return nil, loadError(err, data)
 	}
 
 	return m, nil
 }
 
+// maxErrorContentLength is the maximum length of the manifest content, which
+// is echoed in load errors
+const maxErrorContentLength = 512
+
+var (
+	yamlLineErrorRegexp  = regexp.MustCompile(`yaml: line (\d+): (.*)`)
+	jsonFieldErrorRegexp = regexp.MustCompile(`json: (cannot unmarshal .* into Go struct field \S*?(\w+) of type .*)`)
+)
+
+// loadError returns a concise error for a failed unmarshal. YAML syntax
+// errors point to the line and only echo that line. Type errors point to the
+// first line using the field. Otherwise the truncated manifest is echoed.
+func loadError(err error, data []byte) error {
+	if match := yamlLineErrorRegexp.FindStringSubmatch(err.Error()); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		return errors.Errorf("failed to parse BOSH deployment manifest at line %d: %s%s", line, match[2], lineContent(data, line))
+	}
+
+	if match := jsonFieldErrorRegexp.FindStringSubmatch(err.Error()); match != nil {
+		return errors.Errorf("failed to unmarshal BOSH deployment manifest%s: %s", keyLocation(data, match[2]), match[1])
+	}
+
+	content := string(data)
+	if len(content) > maxErrorContentLength {
+		content = fmt.Sprintf("%s... (truncated, %d bytes total)", content[:maxErrorContentLength], len(data))
+	}
+	return errors.Wrapf(err, "failed to unmarshal BOSH deployment manifest %s", content)
+}
+
+// lineContent returns the trimmed content of the line for error messages
+func lineContent(data []byte, line int) string {
+	lines := strings.Split(string(data), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+
+	content := strings.TrimSpace(lines[line-1])
+	if content == "" {
+		return ""
+	}
+	if len(content) > 80 {
+		content = content[:80] + "..."
+	}
+	return fmt.Sprintf(" near '%s'", content)
+}
+
+// strictManifest is used to strictly unmarshal a manifest. It accepts the
+// BOSH deployment name, which is not part of our manifest model, and the
+// addons_applied flag older versions persisted.
+type strictManifest struct {
+	Manifest
+	Name                string `json:"name,omitempty"`
+	LegacyAddOnsApplied bool   `json:"addons_applied,omitempty"`
+}
+
+var unknownFieldRegexp = regexp.MustCompile(`unknown field "([^"]+)"`)
+
+// LoadYAMLStrict returns a new BOSH deployment manifest from a yaml
+// representation, but fails on unknown or duplicate fields
+func LoadYAMLStrict(data []byte) (*Manifest, error) {
+	sm := &strictManifest{}
+	err := yaml.UnmarshalStrict(data, sm, useNumber)
+	if err != nil {
+		if match := unknownFieldRegexp.FindStringSubmatch(err.Error()); match != nil {
+			return nil, errors.Errorf("unknown field '%s' in BOSH deployment manifest%s", match[1], keyLocation(data, match[1]))
+		}
+		return nil, errors.Wrap(err, "failed to strictly unmarshal BOSH deployment manifest")
+	}
+
+	return &sm.Manifest, nil
+}
+
+// keyLocation returns a hint about the line the yaml key is first used on
+func keyLocation(data []byte, key string) string {
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimLeft(line, " -")
+		if strings.HasPrefix(line, key+":") {
+			return fmt.Sprintf(" near line %d", i+1)
+		}
+	}
+	return ""
+}
+
+func useNumber(opt *json.Decoder) *json.Decoder {
+	opt.UseNumber()
+	return opt
+}
+
+// LoadYAMLMulti returns a BOSH deployment manifest for each document in a
+// multi-document yaml representation. Empty documents are skipped. The
+// documents are loaded from their original text, so values are converted
+// like LoadYAML does, e.g. an unquoted version '1.10' stays a string.
+func LoadYAMLMulti(data []byte) ([]*Manifest, error) {
+	manifests := []*Manifest{}
+
+	for i, docBytes := range splitYAMLDocuments(data) {
+		var doc interface{}
+		if err := goyaml.Unmarshal(docBytes, &doc); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse yaml document %d", i)
+		}
+		if doc == nil {
+			continue
+		}
+
+		m, err := LoadYAML(docBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load yaml document %d", i)
+		}
+		manifests = append(manifests, m)
+	}
+
+	return manifests, nil
+}
+
+// splitYAMLDocuments splits a yaml stream at its '---' document markers,
+// without decoding the documents. A marker at the start of a line always
+// starts a new document, as the content of a document can't start with it.
+// Content before the first marker only counts as a document, if it's more
+// than comments and directives.
+func splitYAMLDocuments(data []byte) [][]byte {
+	docs := [][]byte{}
+	current := []byte{}
+	markers := 0
+
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("---")) && (len(line) == 3 || line[3] == ' ' || line[3] == '\t' || line[3] == '\n' || line[3] == '\r') {
+			if markers > 0 || !isYAMLPreamble(current) {
+				docs = append(docs, current)
+			}
+			markers++
+			// a marker can be followed by content, e.g. '--- {}'
+			current = append([]byte{}, line[3:]...)
+			continue
+		}
+		current = append(current, line...)
+	}
+	return append(docs, current)
+}
+
+// isYAMLPreamble returns true if the text only contains comments, yaml
+// directives or blank lines
+func isYAMLPreamble(data []byte) bool {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && line[0] != '#' && line[0] != '%' {
+			return false
+		}
+	}
+	return true
+}
+
 // Marshal serializes a BOSH manifest into yaml
 func (m *Manifest) Marshal() ([]byte, error) {
+	return m.MarshalWithOptions(DefaultMarshalOptions())
+}
+
+// MarshalCanonical serializes a BOSH manifest into yaml, which is meant for
+// reviewing diffs. Unlike Marshal, duplicate values are not replaced by
+// anchors and the keys are sorted at every level, so equal manifests always
+// have the same representation.
+func (m *Manifest) MarshalCanonical() ([]byte, error) {
+	// The JSON round trip of sigs.k8s.io/yaml turns all objects into
+	// maps, which yaml.v2 serializes with sorted keys
+	return yaml.Marshal(m)
+}
+
+// MarshalWithOptions serializes a BOSH manifest into yaml, using the given options
+func (m *Manifest) MarshalWithOptions(opts MarshalOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.MarshalToWithOptions(&buf, opts); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MarshalTo serializes a BOSH manifest into yaml and streams the result to w.
+// The output is identical to Marshal, but the final document is not buffered
+// as a whole, so callers can pipe it directly into e.g. a gzip writer.
+func (m *Manifest) MarshalTo(w io.Writer) error {
+	return m.MarshalToWithOptions(w, DefaultMarshalOptions())
+}
 
+// MarshalToWithOptions streams the yaml serialization of a BOSH manifest to w, using the given options
+func (m *Manifest) MarshalToWithOptions(w io.Writer, opts MarshalOptions) error {
 	marshalledManifest, err := yaml.Marshal(m)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// UnMarshalling the manifest to interface{}interface{} so that it is easy to loop.
 	manifestInterfaceMap := goyaml.MapSlice{}
 	err = goyaml.Unmarshal(marshalledManifest, &manifestInterfaceMap)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	duplicateValues := map[string]duplicateYamlValue{}
-	duplicateValues = markDuplicateValues(reflect.ValueOf(manifestInterfaceMap), duplicateValues)
+	if opts.AnchorMinLength > 0 {
+		duplicateValues = markDuplicateValues(reflect.ValueOf(manifestInterfaceMap), duplicateValues, opts, nil)
+	}
 
-	marshalledManifest, err = goyaml.Marshal(&manifestInterfaceMap)
+	aw := newAnchorWriter(w, duplicateValues)
+	encoder := goyaml.NewEncoder(aw)
+	err = encoder.Encode(&manifestInterfaceMap)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return err
+	}
+
+	return aw.Flush()
+}
+
+// blockScalarRegexp matches lines, which start a literal or folded block
+// scalar, e.g. 'certificate: |-' or '- >2'
+var blockScalarRegexp = regexp.MustCompile(`(^|:|-) [|>][-+0-9]*\n?$`)
+
+// anchorWriter rewrites the anchor markers left by markDuplicateValues into
+// real yaml anchors and aliases, line by line, before passing the data on.
+// Markers are only rewritten at the key or value position of a line. The
+// content of block scalars, e.g. multi-line PEM certificates, is passed on
+// unchanged.
+type anchorWriter struct {
+	w       io.Writer
+	buf     []byte
+	anchors [][2][]byte
+	aliases [][2][]byte
+	// blockIndent is the indentation of the key of the current block
+	// scalar, or -1 outside of block scalars
+	blockIndent int
+}
+
+func newAnchorWriter(w io.Writer, duplicateValues map[string]duplicateYamlValue) *anchorWriter {
+	aw := &anchorWriter{w: w, blockIndent: -1}
+	hashes := make([]string, 0, len(duplicateValues))
+	for hash := range duplicateValues {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		v := duplicateValues[hash]
+		aw.anchors = append(aw.anchors,
+			[2][]byte{[]byte(anchorMarker(v.Hash) + ": "), []byte(fmt.Sprintf("%s: &%s ", encodeYamlKey(v.Key), v.Hash))},
+		)
+		// Remove quotes over alias values as reflect in go adds quotes to strings.
+		aw.aliases = append(aw.aliases,
+			[2][]byte{[]byte(fmt.Sprintf("'*%s'", v.Hash)), []byte("*" + v.Hash)},
+		)
 	}
+	return aw
+}
 
-	// Remove quotes over anchor values as reflect in go adds quotes to strings.
-	for _, v := range duplicateValues {
-		marshalledManifest = bytes.ReplaceAll(marshalledManifest,
-			[]byte(fmt.Sprintf("'*%s'", v.Hash)), []byte("*"+v.Hash))
-		marshalledManifest = bytes.ReplaceAll(marshalledManifest,
-			[]byte(fmt.Sprintf("%s=%s: ", v.YamlKeyMarker, v.Hash)), []byte(fmt.Sprintf("%s: &%s ", v.YamlKeyMarker, v.Hash)))
+// Write buffers p and writes out all complete lines
+func (aw *anchorWriter) Write(p []byte) (int, error) {
+	aw.buf = append(aw.buf, p...)
+
+	i := bytes.LastIndexByte(aw.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	if err := aw.write(aw.buf[:i+1]); err != nil {
+		return 0, err
 	}
+	aw.buf = append(aw.buf[:0], aw.buf[i+1:]...)
 
-	return marshalledManifest, nil
+	return len(p), nil
+}
+
+// Flush writes out any remaining, incomplete line
+func (aw *anchorWriter) Flush() error {
+	if len(aw.buf) == 0 {
+		return nil
+	}
+	err := aw.write(aw.buf)
+	aw.buf = aw.buf[:0]
+	return err
+}
+
+func (aw *anchorWriter) write(data []byte) error {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	for i, line := range lines {
+		lines[i] = aw.rewrite(line)
+	}
+	_, err := aw.w.Write(bytes.Join(lines, nil))
+	return err
+}
+
+// rewrite replaces the markers in a single line
+func (aw *anchorWriter) rewrite(line []byte) []byte {
+	content := bytes.TrimLeft(line, " ")
+	for bytes.HasPrefix(content, []byte("- ")) {
+		content = bytes.TrimLeft(content[2:], " ")
+	}
+	indent := len(line) - len(content)
+
+	if aw.blockIndent >= 0 {
+		if len(bytes.TrimSpace(line)) == 0 || indent > aw.blockIndent {
+			return line
+		}
+		aw.blockIndent = -1
+	}
+	if blockScalarRegexp.Match(line) {
+		aw.blockIndent = indent
+	}
+
+	for _, r := range aw.anchors {
+		if bytes.HasPrefix(content, r[0]) {
+			return concatBytes(line[:indent], r[1], content[len(r[0]):])
+		}
+	}
+
+	value := bytes.TrimRight(content, "\n")
+	for _, r := range aw.aliases {
+		if bytes.HasSuffix(value, r[0]) {
+			return concatBytes(line[:len(line)-len(content)+len(value)-len(r[0])], r[1], content[len(value):])
+		}
+	}
+
+	return line
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
 }
 
 // markDuplicateValues will store the duplicate values in the
 // duplicateValues struct and change the manifest to include anchors.
-// Ex :-  key1=UUID1: |-
+// Ex :-  quarks-anchor-UUID1: |-
 //		  		data
 //		  key2: *UUID1
 // Later in the marshal function, the above gets changed to
@@ -226,7 +621,8 @@ func (m *Manifest) Marshal() ([]byte, error) {
 //		  		data
 //		  key2: *UUID1
 //
-func markDuplicateValues(value reflect.Value, duplicateValues map[string]duplicateYamlValue) map[string]duplicateYamlValue {
+// Values below opts.NoAnchorPaths are skipped, path is the path of value.
+func markDuplicateValues(value reflect.Value, duplicateValues map[string]duplicateYamlValue, opts MarshalOptions, path []string) map[string]duplicateYamlValue {
 	// Get the element if the value is a pointer
 	if value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
 		value = value.Elem()
@@ -236,18 +632,23 @@ func markDuplicateValues(value reflect.Value, duplicateValues map[string]duplica
 
 	case reflect.Array, reflect.Slice:
 		for i := 0; i < value.Len(); i++ {
-			duplicateValues = markDuplicateValues(value.Index(i), duplicateValues)
+			duplicateValues = markDuplicateValues(value.Index(i), duplicateValues, opts, path)
 		}
 	case reflect.Struct:
 		valueKeyField := value.Field(0)
 		valueField := value.Field(1)
 
+		valuePath := appendPath(path, valueKeyField.Interface())
+		if opts.anchorExcluded(valuePath) {
+			break
+		}
+
 		valueFieldO := valueField
 		if valueField.Kind() == reflect.Ptr || valueField.Kind() == reflect.Interface {
 			valueField = valueField.Elem()
 		}
 		if valueField.Kind() == reflect.String {
-			if valueField.String() != "" && valueField.IsValid() && len(valueField.String()) > 64 {
+			if valueField.String() != "" && valueField.IsValid() && len(valueField.String()) > opts.AnchorMinLength {
 				h := crypto.SHA1.New()
 				_, _ = h.Write([]byte(valueField.String()))
 				sum := h.Sum(nil)
@@ -257,24 +658,34 @@ func markDuplicateValues(value reflect.Value, duplicateValues map[string]duplica
 				if foundValue {
 					valueFieldO.Set(reflect.ValueOf("*" + sha1))
 				} else {
-					newMapKey := fmt.Sprintf("%s=%s", valueKeyField.Interface().(string), sha1)
 					valueFieldO.Set(valueField)
 
 					duplicateValue := duplicateYamlValue{
-						Hash:          sha1,
-						YamlKeyMarker: valueKeyField.Interface().(string),
+						Hash: sha1,
+						Key:  valueKeyField.Interface().(string),
 					}
-					valueKeyField.Set(reflect.ValueOf(newMapKey))
+					valueKeyField.Set(reflect.ValueOf(anchorMarker(sha1)))
 
 					duplicateValues[sha1] = duplicateValue
 				}
 			}
 		} else {
-			duplicateValues = markDuplicateValues(valueField, duplicateValues)
+			duplicateValues = markDuplicateValues(valueField, duplicateValues, opts, valuePath)
 		}
 
 	case reflect.Map:
-		for _, k := range value.MapKeys() {
+		// Sort the keys, so the first occurrence of a value, which gets the
+		// anchor, doesn't depend on the map iteration order
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, k := range keys {
+			valuePath := appendPath(path, k.Interface())
+			if opts.anchorExcluded(valuePath) {
+				continue
+			}
+
 			valueField := value.MapIndex(k)
 			if valueField.Kind() == reflect.Ptr || valueField.Kind() == reflect.Interface {
 				valueField = valueField.Elem()
@@ -282,7 +693,7 @@ func markDuplicateValues(value reflect.Value, duplicateValues map[string]duplica
 
 			// Consider the strings which are big enough only.
 			if valueField.Kind() == reflect.String {
-				if valueField.String() != "" && valueField.IsValid() {
+				if valueField.String() != "" && valueField.IsValid() && len(valueField.String()) > opts.AnchorMinLength {
 					h := crypto.SHA1.New()
 					_, _ = h.Write([]byte(valueField.String()))
 					sum := h.Sum(nil)
@@ -292,54 +703,91 @@ func markDuplicateValues(value reflect.Value, duplicateValues map[string]duplica
 					if foundValue {
 						value.SetMapIndex(k, reflect.ValueOf(string("*"+sha1)))
 					} else {
-						newMapKey := fmt.Sprintf("%s=%s", k.Interface().(string), sha1)
-
 						value.SetMapIndex(k, reflect.Value{})
-						value.SetMapIndex(reflect.ValueOf(newMapKey), valueField)
+						value.SetMapIndex(reflect.ValueOf(anchorMarker(sha1)), valueField)
 						duplicateValue := duplicateYamlValue{
-							Hash:          sha1,
-							YamlKeyMarker: k.Interface().(string),
+							Hash: sha1,
+							Key:  k.Interface().(string),
 						}
 						duplicateValues[sha1] = duplicateValue
 					}
 				}
 			} else {
-				duplicateValues = markDuplicateValues(value.MapIndex(k), duplicateValues)
+				duplicateValues = markDuplicateValues(value.MapIndex(k), duplicateValues, opts, valuePath)
 			}
 		}
 	}
 	return duplicateValues
 }
 
+// appendPath returns a copy of path with key appended
+func appendPath(path []string, key interface{}) []string {
+	return append(path[:len(path):len(path)], fmt.Sprint(key))
+}
+
 // SHA1 calculates the SHA1 of the manifest
 func (m *Manifest) SHA1() (string, error) {
-	manifestBytes, err := m.Marshal()
+	manifestBytes, err := m.checksumBytes()
 	if err != nil {
-		return "", errors.Wrapf(err, "YAML marshalling manifest failed.")
+		return "", err
 	}
 
 	return fmt.Sprintf("%x", sha1.Sum(manifestBytes)), nil
 }
 
+// Checksum calculates the SHA256 of the manifest. The result is prefixed
+// with the algorithm, e.g. 'sha256:...', and can be compared for equality
+// to detect changes.
+func (m *Manifest) Checksum() (string, error) {
+	manifestBytes, err := m.checksumBytes()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%x", ChecksumPrefix, sha256.Sum256(manifestBytes)), nil
+}
+
+// checksumBytes returns the serialized manifest, which is used to calculate checksums
+func (m *Manifest) checksumBytes() ([]byte, error) {
+	manifestBytes, err := m.Marshal()
+	if err != nil {
+		return nil, errors.Wrapf(err, "YAML marshalling manifest failed.")
+	}
+	return manifestBytes, nil
+}
+
+// InstanceGroupNames returns the names of all instance groups in order
+func (m *Manifest) InstanceGroupNames() []string {
+	names := make([]string, len(m.InstanceGroups))
+	for i, ig := range m.InstanceGroups {
+		names[i] = ig.Name
+	}
+	return names
+}
+
+// NetworkNames returns the names of all declared networks in order
+func (m *Manifest) NetworkNames() []string {
+	names := make([]string, len(m.Networks))
+	for i, network := range m.Networks {
+		names[i] = network.Name
+	}
+	return names
+}
+
+// InstanceGroup returns the instance group with the given name. The second
+// return parameter indicates if the instance group was found.
+func (m *Manifest) InstanceGroup(name string) (*InstanceGroup, bool) {
+	return m.InstanceGroups.InstanceGroupByName(name)
+}
+
 // GetReleaseImage returns the release image location for a given instance group/job
 func (m *Manifest) GetReleaseImage(instanceGroupName, jobName string) (string, error) {
-	var instanceGroup *InstanceGroup
-	for i := range m.InstanceGroups {
-		if m.InstanceGroups[i].Name == instanceGroupName {
-			instanceGroup = m.InstanceGroups[i]
-			break
-		}
-	}
-	if instanceGroup == nil {
+	instanceGroup, ok := m.InstanceGroup(instanceGroupName)
+	if !ok {
 		return "", errors.Errorf("instance group '%s' not found.", instanceGroupName)
 	}
 
-	var stemcell *Stemcell
-	for i := range m.Stemcells {
-		if m.Stemcells[i].Alias == instanceGroup.Stemcell {
-			stemcell = m.Stemcells[i]
-		}
-	}
+	stemcell := m.instanceGroupStemcell(instanceGroup)
 
 	var job *Job
 	for i := range instanceGroup.Jobs {
@@ -356,6 +804,18 @@ func (m *Manifest) GetReleaseImage(instanceGroupName, jobName string) (string, e
 		if m.Releases[i].Name == job.Release {
 			release := m.Releases[i]
 			name := strings.TrimRight(release.URL, "/")
+			if m.imageRegistry != "" {
+				name = overrideRegistry(name, m.imageRegistry)
+			}
+
+			// Pin the image by digest, the stemcell is already part of the image
+			if release.Digest != "" {
+				digest := release.Digest
+				if !strings.Contains(digest, ":") {
+					digest = "sha256:" + digest
+				}
+				return fmt.Sprintf("%s/%s@%s", name, release.Name, digest), nil
+			}
 
 			var stemcellVersion string
 
@@ -373,27 +833,103 @@ func (m *Manifest) GetReleaseImage(instanceGroupName, jobName string) (string, e
 	return "", errors.Errorf("release '%s' not found", job.Release)
 }
 
-// GetJobOS returns the stemcell layer OS used for a Job
-// This is used for matching addon placement rules
-func (m *Manifest) GetJobOS(instanceGroupName, jobName string) (string, error) {
-	var instanceGroup *InstanceGroup
-	for i := range m.InstanceGroups {
-		if m.InstanceGroups[i].Name == instanceGroupName {
-			instanceGroup = m.InstanceGroups[i]
-			break
+// instanceGroupStemcell returns the stemcell of the instance group. The
+// instance group's stemcell is looked up by alias first. If no alias matches,
+// it may name the stemcell's OS instead. Returns nil if neither matches.
+func (m *Manifest) instanceGroupStemcell(instanceGroup *InstanceGroup) *Stemcell {
+	for _, stemcell := range m.Stemcells {
+		if stemcell.Alias == instanceGroup.Stemcell {
+			return stemcell
 		}
 	}
-	if instanceGroup == nil {
-		return "", fmt.Errorf("instance group '%s' not found", instanceGroupName)
+
+	if instanceGroup.Stemcell == "" {
+		if m.singleStemcellFallback && len(m.Stemcells) == 1 {
+			return m.Stemcells[0]
+		}
+		return nil
+	}
+	for _, stemcell := range m.Stemcells {
+		if stemcell.OS == instanceGroup.Stemcell {
+			return stemcell
+		}
+	}
+	return nil
+}
+
+// IsCompiledRelease returns true if the release is declared with a stemcell.
+// Images of compiled releases use the release's stemcell instead of the
+// instance group's.
+func (m *Manifest) IsCompiledRelease(releaseName string) bool {
+	release := m.release(releaseName)
+	return release != nil && release.Stemcell != nil
+}
+
+// SetImageRegistryOverride makes GetReleaseImage replace the registry host of
+// the release URLs with the given registry, e.g. to use an internal mirror.
+// The path of the release URL is kept.
+func (m *Manifest) SetImageRegistryOverride(registry string) {
+	m.imageRegistry = strings.TrimRight(registry, "/")
+}
+
+// SetSingleStemcellFallback makes GetReleaseImage and GetJobOS use the only
+// declared stemcell for instance groups without a stemcell alias. Manifests
+// with multiple stemcells still need an alias.
+func (m *Manifest) SetSingleStemcellFallback(enabled bool) {
+	m.singleStemcellFallback = enabled
+}
+
+// overrideRegistry replaces the registry host of a release URL
+func overrideRegistry(url string, registry string) string {
+	if i := strings.Index(url, "://"); i >= 0 {
+		url = url[i+3:]
+	}
+
+	parts := strings.SplitN(url, "/", 2)
+	host := parts[0]
+	// Like docker, only treat the first part as a host if it looks like one
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return registry + "/" + url
 	}
+	if len(parts) == 1 {
+		return registry
+	}
+	return registry + "/" + parts[1]
+}
 
-	var stemcell *Stemcell
-	for i := range m.Stemcells {
-		if m.Stemcells[i].Alias == instanceGroup.Stemcell {
-			stemcell = m.Stemcells[i]
+// AllReleaseImages returns the sorted list of all release images used by the
+// jobs of the manifest
+func (m *Manifest) AllReleaseImages() ([]string, error) {
+	images := map[string]struct{}{}
+	for _, ig := range m.InstanceGroups {
+		for _, job := range ig.Jobs {
+			image, err := m.GetReleaseImage(ig.Name, job.Name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to resolve release image for job '%s' in instance group '%s'", job.Name, ig.Name)
+			}
+			images[image] = struct{}{}
 		}
 	}
 
+	result := make([]string, 0, len(images))
+	for image := range images {
+		result = append(result, image)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// GetJobOS returns the stemcell layer OS used for a Job
+// This is used for matching addon placement rules
+func (m *Manifest) GetJobOS(instanceGroupName, jobName string) (string, error) {
+	instanceGroup, ok := m.InstanceGroup(instanceGroupName)
+	if !ok {
+		return "", fmt.Errorf("instance group '%s' not found", instanceGroupName)
+	}
+
+	stemcell := m.instanceGroupStemcell(instanceGroup)
+
 	var job *Job
 	for i := range instanceGroup.Jobs {
 		if instanceGroup.Jobs[i].Name == jobName {
@@ -425,6 +961,22 @@ func (m *Manifest) GetJobOS(instanceGroupName, jobName string) (string, error) {
 	return "", fmt.Errorf("release '%s' not found", job.Release)
 }
 
+// GetJobProperties returns the properties, including the quarks block, of a
+// given instance group/job
+func (m *Manifest) GetJobProperties(instanceGroupName, jobName string) (JobProperties, error) {
+	instanceGroup, ok := m.InstanceGroup(instanceGroupName)
+	if !ok {
+		return JobProperties{}, errors.Errorf("instance group '%s' not found.", instanceGroupName)
+	}
+
+	for i := range instanceGroup.Jobs {
+		if instanceGroup.Jobs[i].Name == jobName {
+			return instanceGroup.Jobs[i].Properties, nil
+		}
+	}
+	return JobProperties{}, errors.Errorf("job '%s' not found in instance group '%s'", jobName, instanceGroupName)
+}
+
 // SlashedVariable returns true if the variable name contains a slash.
 // This could be a https://bosh.io/docs/cli-int/#absolute explicit variable,
 // but more likely it's the '/' syntax that was introduced to specify the key
@@ -434,8 +986,50 @@ func SlashedVariable(name string) bool {
 	return strings.Contains(name, "/")
 }
 
-// ImplicitVariables returns a list of all implicit variables in a manifest
-func (m *Manifest) ImplicitVariables() ([]string, error) {
+// VariableKind tells how a variable reference is resolved
+type VariableKind string
+
+// Kinds of variable references
+const (
+	// ExplicitVariable is declared in the manifest's variables and
+	// generated by the operator
+	ExplicitVariable VariableKind = "explicit"
+	// ImplicitVariable is read from a user provided secret
+	ImplicitVariable VariableKind = "implicit"
+	// AbsoluteVariable is a config server path like '/director/name/var'
+	AbsoluteVariable VariableKind = "absolute"
+)
+
+// ClassifyVariable returns the kind of the variable reference name, e.g.
+// 'ca.certificate', 'ssl/key' or '!name'. Dotted references are classified
+// by their name part, slashed references are implicit unless they start
+// with a slash.
+func ClassifyVariable(m *Manifest, name string) VariableKind {
+	name = strings.TrimPrefix(name, "!")
+
+	explicit := map[string]bool{}
+	for _, v := range m.Variables {
+		explicit[v.Name] = true
+	}
+
+	if explicit[name] {
+		return ExplicitVariable
+	}
+	if strings.HasPrefix(name, "/") {
+		return AbsoluteVariable
+	}
+	if SlashedVariable(name) {
+		return ImplicitVariable
+	}
+	if explicit[strings.SplitN(name, ".", 2)[0]] {
+		return ExplicitVariable
+	}
+	return ImplicitVariable
+}
+
+// variableReferences returns the names of all variables referenced in the
+// manifest
+func (m *Manifest) variableReferences() (map[string]bool, error) {
 	varMap := make(map[string]bool)
 
 	manifestBytes, err := m.Marshal()
@@ -443,25 +1037,52 @@ func (m *Manifest) ImplicitVariables() ([]string, error) {
 		return nil, err
 	}
 
-	rawManifest := string(manifestBytes)
-
 	// Collect all variables
-	varRegexp := regexp.MustCompile(`\(\((!?[-/\.\w\pL]+)\)\)`)
 	fieldRegexp := regexp.MustCompile(`[^\.]+`)
-	for _, match := range varRegexp.FindAllStringSubmatch(rawManifest, -1) {
-		main := match[1]
+	for _, ref := range FindVariableReferences(manifestBytes) {
+		// The '!' prefix doesn't belong to the name
+		main := strings.TrimPrefix(ref, "!")
 
 		// variables with a slash are passed through
 		if !SlashedVariable(main) {
 			// This stores only the name part of a dotted explicit variable.
 			// Remove subfields from explicit vars, e.g. ca.private_key -> ca
-			main = fieldRegexp.FindString(match[1])
+			main = fieldRegexp.FindString(main)
 		}
 
 		// store the name of the potentially implicit variable
 		varMap[main] = true
 	}
 
+	return varMap, nil
+}
+
+// variableRegexp matches variable placeholders like the bosh-cli template
+var variableRegexp = regexp.MustCompile(`\(\((!?[-/\.\w\pL]+)\)\)`)
+
+// FindVariableReferences returns the references of all variable
+// placeholders in data, in the order of their occurrence. A reference is
+// the text between the parentheses, e.g. '!ca.certificate' for
+// '((!ca.certificate))'. Escaped placeholders like '\((foo))' are ignored.
+func FindVariableReferences(data []byte) []string {
+	refs := []string{}
+	for _, match := range variableRegexp.FindAllSubmatchIndex(data, -1) {
+		// Escaped placeholders are literal text, e.g. '\((foo))' or '(((foo)))'
+		if start := match[0]; start > 0 && (data[start-1] == '\\' || data[start-1] == '(') {
+			continue
+		}
+		refs = append(refs, string(data[match[2]:match[3]]))
+	}
+	return refs
+}
+
+// ImplicitVariables returns a list of all implicit variables in a manifest
+func (m *Manifest) ImplicitVariables() ([]string, error) {
+	varMap, err := m.variableReferences()
+	if err != nil {
+		return nil, err
+	}
+
 	// Remove the explicit ones
 	for _, v := range m.Variables {
 		varMap[v.Name] = false
@@ -478,27 +1099,71 @@ func (m *Manifest) ImplicitVariables() ([]string, error) {
 	return names, nil
 }
 
+// UnusedVariables returns the names of explicit variables, which are not
+// referenced in the manifest. Variables used as a CA by other variables are
+// considered in use.
+func (m *Manifest) UnusedVariables() ([]string, error) {
+	varMap, err := m.variableReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range m.Variables {
+		if v.Options != nil && v.Options.CA != "" {
+			varMap[v.Options.CA] = true
+		}
+	}
+
+	names := []string{}
+	for _, v := range m.Variables {
+		if !varMap[v.Name] {
+			names = append(names, v.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// AddonsApplied returns true if ApplyAddons was called on the manifest. The
+// flag is not restored by LoadYAML.
+func (m *Manifest) AddonsApplied() bool {
+	return m.AddOnsApplied
+}
+
 // ApplyAddons goes through all defined addons and adds jobs to matched instance groups
 func (m *Manifest) ApplyAddons(log *zap.SugaredLogger) error {
-	if m.AddOnsApplied {
-		return nil
+	return m.ApplyAddonsExcept(log, nil)
+}
+
+// ApplyAddonsExcept works like ApplyAddons, but skips the addons with the given names.
+// The bosh-dns addon is always skipped, as it's not applied as jobs.
+// Addons which were already applied to an instance group are not applied again.
+func (m *Manifest) ApplyAddonsExcept(log *zap.SugaredLogger, skip []string) error {
+	skipped := map[string]bool{}
+	for _, name := range skip {
+		skipped[name] = true
 	}
+
 	for _, addon := range m.AddOns {
 		if addon.Name == BoshDNSAddOnName {
+			log.Debugf("Skipping addon '%s', it's emulated by the operator", addon.Name)
+			continue
+		}
+		if skipped[addon.Name] {
+			log.Infof("Skipping addon '%s'", addon.Name)
 			continue
 		}
 		for _, ig := range m.InstanceGroups {
-			include, err := m.addOnPlacementMatch(log, "inclusion", ig, addon.Include)
-			if err != nil {
-				return errors.Wrap(err, "failed to process include placement matches")
+			if ig.addOnApplied(addon) {
+				log.Debugf("Addon '%s' is already applied to instance group '%s'", addon.Name, ig.Name)
+				continue
 			}
-			exclude, err := m.addOnPlacementMatch(log, "exclusion", ig, addon.Exclude)
+
+			match, err := m.addOnMatch(log, addon, ig)
 			if err != nil {
-				return errors.Wrap(err, "failed to process exclude placement matches")
+				return err
 			}
-
-			if exclude || !include {
-				log.Debugf("Addon '%s' doesn't match instance group '%s'", addon.Name, ig.Name)
+			if !match {
 				continue
 			}
 
@@ -512,6 +1177,7 @@ func (m *Manifest) ApplyAddons(log *zap.SugaredLogger) error {
 				}
 
 				addedJob.Properties.Quarks.IsAddon = true
+				addedJob.Properties.Quarks.AddOnName = addon.Name
 
 				log.Debugf("Applying addon job '%s/%s' to instance group '%s'", addon.Name, addonJob.Name, ig.Name)
 				ig.Jobs = append(ig.Jobs, addedJob)
@@ -519,18 +1185,54 @@ func (m *Manifest) ApplyAddons(log *zap.SugaredLogger) error {
 		}
 	}
 
-	// Remember that addons are already applied, so we don't end up applying them again
+	// Remember that addons were applied
 	m.AddOnsApplied = true
 
 	return nil
 }
 
-// PropagateGlobalUpdateBlockToIGs copies the update block to all instance groups
+// PreviewAddons returns the names of the instance groups each addon would be
+// applied to by ApplyAddons, without changing the manifest
+func (m *Manifest) PreviewAddons(log *zap.SugaredLogger) (map[string][]string, error) {
+	preview := map[string][]string{}
+	for _, addon := range m.AddOns {
+		if addon.Name == BoshDNSAddOnName {
+			continue
+		}
+
+		igNames := []string{}
+		for _, ig := range m.InstanceGroups {
+			match, err := m.addOnMatch(log, addon, ig)
+			if err != nil {
+				return nil, err
+			}
+			if match {
+				igNames = append(igNames, ig.Name)
+			}
+		}
+		preview[addon.Name] = igNames
+	}
+
+	return preview, nil
+}
+
+// PropagateGlobalUpdateBlockToIGs copies the update block to all instance groups.
+// Fields set in the instance group's update block are kept.
 func (m *Manifest) PropagateGlobalUpdateBlockToIGs() {
+	if m.Update == nil {
+		return
+	}
+
 	for _, ig := range m.InstanceGroups {
 		if ig.Update == nil {
 			ig.Update = m.Update
 		} else {
+			if ig.Update.Canaries == 0 {
+				ig.Update.Canaries = m.Update.Canaries
+			}
+			if ig.Update.MaxInFlight == "" {
+				ig.Update.MaxInFlight = m.Update.MaxInFlight
+			}
 			if ig.Update.CanaryWatchTime == "" {
 				ig.Update.CanaryWatchTime = m.Update.CanaryWatchTime
 			}
@@ -540,6 +1242,9 @@ func (m *Manifest) PropagateGlobalUpdateBlockToIGs() {
 			if ig.Update.Serial == nil {
 				ig.Update.Serial = m.Update.Serial
 			}
+			if ig.Update.VMStrategy == nil {
+				ig.Update.VMStrategy = m.Update.VMStrategy
+			}
 		}
 	}
 }
@@ -566,6 +1271,185 @@ func (m *Manifest) ListMissingProviders() map[string]bool {
 	return consumeFromNames
 }
 
+// MissingProvidersDetailed returns the missing providers like
+// ListMissingProviders, each with the list of consumers as "instanceGroup/job".
+func (m *Manifest) MissingProvidersDetailed() map[string][]string {
+	consumers := map[string][]string{}
+	for name := range m.ListMissingProviders() {
+		consumers[name] = []string{}
+	}
+
+	for _, ig := range m.InstanceGroups {
+		for _, job := range ig.Jobs {
+			for name := range listProviderNames(map[string]bool{}, job.Consumes, "from") {
+				if _, ok := consumers[name]; ok {
+					consumers[name] = append(consumers[name], ig.Name+"/"+job.Name)
+				}
+			}
+		}
+	}
+
+	return consumers
+}
+
+// ListProviderTypeMismatches compares the link types of consumers with the
+// types of the providers of the same name. Types are looked up in the job
+// specs, indexed by release and job name. Unlike ListMissingProviders, it
+// reports links which are provided, but only with a different type.
+func (m *Manifest) ListProviderTypeMismatches(jobSpecs map[string]map[string]JobSpec) []string {
+	providerTypes := map[string]map[string]bool{}
+	for _, ig := range m.InstanceGroups {
+		for _, job := range ig.Jobs {
+			spec, ok := jobSpecs[job.Release][job.Name]
+			if !ok {
+				continue
+			}
+			for _, link := range spec.Provides {
+				name, ok := overriddenLinkName(job.Provides, link.Name, "as")
+				if !ok {
+					continue
+				}
+				if providerTypes[name] == nil {
+					providerTypes[name] = map[string]bool{}
+				}
+				providerTypes[name][link.Type] = true
+			}
+		}
+	}
+
+	mismatches := []string{}
+	for _, ig := range m.InstanceGroups {
+		for _, job := range ig.Jobs {
+			spec, ok := jobSpecs[job.Release][job.Name]
+			if !ok {
+				continue
+			}
+			for _, consumer := range spec.Consumes {
+				name, ok := overriddenLinkName(job.Consumes, consumer.Name, "from")
+				if !ok {
+					continue
+				}
+				types, provided := providerTypes[name]
+				if !provided || types[consumer.Type] {
+					continue
+				}
+
+				providedTypes := make([]string, 0, len(types))
+				for t := range types {
+					providedTypes = append(providedTypes, t)
+				}
+				sort.Strings(providedTypes)
+				mismatches = append(mismatches, fmt.Sprintf("%s/%s consumes '%s' of type '%s', but it is provided with type '%s'",
+					ig.Name, job.Name, name, consumer.Type, strings.Join(providedTypes, "', '")))
+			}
+		}
+	}
+
+	return mismatches
+}
+
+// ListUnreachableLinks reports consumers, whose explicitly named provider is
+// in an instance group, which doesn't share a network with the consumer's
+// instance group. If the consumer selects a network, the provider has to be
+// on that network. Instance groups without networks are skipped.
+func (m *Manifest) ListUnreachableLinks() []Warning {
+	providers := map[string][]*InstanceGroup{}
+	for _, ig := range m.InstanceGroups {
+		for _, job := range ig.Jobs {
+			for name := range listProviderNames(map[string]bool{}, job.Provides, "as") {
+				providers[name] = append(providers[name], ig)
+			}
+		}
+	}
+
+	warnings := []Warning{}
+	for _, ig := range m.InstanceGroups {
+		if len(ig.Networks) == 0 {
+			continue
+		}
+		for _, job := range ig.Jobs {
+			linkNames := make([]string, 0, len(job.Consumes))
+			for linkName := range job.Consumes {
+				linkNames = append(linkNames, linkName)
+			}
+			sort.Strings(linkNames)
+
+			for _, linkName := range linkNames {
+				consume, ok := job.Consumes[linkName].(map[string]interface{})
+				if !ok || consume["from"] == nil {
+					continue
+				}
+				name := fmt.Sprintf("%v", consume["from"])
+				network, _ := consume["network"].(string)
+
+				for _, provider := range providers[name] {
+					if provider == ig || len(provider.Networks) == 0 {
+						continue
+					}
+
+					if network != "" {
+						if !hasNetwork(provider, network) {
+							warnings = append(warnings, Warning{
+								Code: WarningUnreachableLink,
+								Message: fmt.Sprintf("instance group '%s' consumes '%s' on network '%s', but the provider instance group '%s' is not on that network",
+									ig.Name, name, network, provider.Name),
+							})
+						}
+						continue
+					}
+
+					if !sharesNetwork(ig, provider) {
+						warnings = append(warnings, Warning{
+							Code: WarningUnreachableLink,
+							Message: fmt.Sprintf("instance group '%s' consumes '%s', but shares no network with the provider instance group '%s'",
+								ig.Name, name, provider.Name),
+						})
+					}
+				}
+			}
+		}
+	}
+
+	return warnings
+}
+
+// hasNetwork returns true if the instance group is on the named network
+func hasNetwork(ig *InstanceGroup, name string) bool {
+	for _, network := range ig.Networks {
+		if network.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sharesNetwork returns true if the instance groups have a network in common
+func sharesNetwork(a *InstanceGroup, b *InstanceGroup) bool {
+	for _, network := range a.Networks {
+		if hasNetwork(b, network.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// overriddenLinkName returns the name of the link after applying the override from the
+// job's provides or consumes section. It returns false if the link is
+// blocked by 'nil'.
+func overriddenLinkName(links map[string]interface{}, name string, overrideKey string) (string, bool) {
+	switch value := links[name].(type) {
+	case map[string]interface{}:
+		if override, ok := value[overrideKey]; ok {
+			return fmt.Sprintf("%v", override), true
+		}
+	case string:
+		if value == "nil" {
+			return "", false
+		}
+	}
+	return name, true
+}
+
 // listProviderNames returns a map containing provider names from job provides and consumes
 func listProviderNames(providerNames map[string]bool, providerProperties map[string]interface{}, providerKey string) map[string]bool {
 	for _, property := range providerProperties {
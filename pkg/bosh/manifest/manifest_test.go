@@ -1,11 +1,21 @@
 package manifest_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
 	"reflect"
 	"regexp"
+	"strings"
 
+	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -211,6 +221,14 @@ var _ = Describe("Manifest", func() {
 					))
 				})
 			})
+
+			Describe("Tags", func() {
+				It("contains desired values", func() {
+					Expect(getStructTagForName("Tags", addOnPlacementRule)).To(Equal(
+						`json:"tags,omitempty"`,
+					))
+				})
+			})
 		})
 
 		Describe("AddOnPlacementJob", func() {
@@ -324,6 +342,14 @@ var _ = Describe("Manifest", func() {
 				})
 			})
 
+			Describe("Digest", func() {
+				It("contains desired values", func() {
+					Expect(getStructTagForName("Digest", release)).To(Equal(
+						`json:"digest,omitempty"`,
+					))
+				})
+			})
+
 			Describe("Stemcell", func() {
 				It("contains desired values", func() {
 					Expect(getStructTagForName("Stemcell", release)).To(Equal(
@@ -1166,6 +1192,187 @@ var _ = Describe("Manifest", func() {
 			})
 		})
 
+		Context("when LoadYAML fails", func() {
+			It("reports the line of syntax errors", func() {
+				_, err := LoadYAML([]byte(`---
+instance_groups:
+- name: redis-slave
+  instances: 1
+   jobs: []
+`))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(HavePrefix("failed to parse BOSH deployment manifest at line 5: mapping values are not allowed"))
+				Expect(err.Error()).To(HaveSuffix(" near 'jobs: []'"))
+			})
+
+			It("reports the line of the field for type errors", func() {
+				_, err := LoadYAML([]byte(`---
+instance_groups:
+- name: redis-slave
+  instances: many
+`))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(HavePrefix("failed to unmarshal BOSH deployment manifest near line 4: cannot unmarshal string"))
+			})
+
+			It("truncates the manifest content of other errors", func() {
+				data := bytes.Repeat([]byte("- item\n"), 200)
+				_, err := LoadYAML(data)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("(truncated, 1400 bytes total)"))
+				Expect(len(err.Error())).To(BeNumerically("<", 1000))
+			})
+		})
+
+		Describe("LoadYAMLStrict", func() {
+			It("loads a manifest without unknown fields", func() {
+				manifest, err := LoadYAMLStrict([]byte(`---
+name: foo
+instance_groups:
+- name: redis
+  instances: 1
+`))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.InstanceGroups).To(HaveLen(1))
+			})
+
+			It("reports unknown fields with their location", func() {
+				_, err := LoadYAMLStrict([]byte(`---
+name: foo
+instancegroups:
+- name: redis
+`))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("unknown field 'instancegroups'"))
+				Expect(err.Error()).To(ContainSubstring("near line 3"))
+			})
+
+			It("reports unknown fields in update blocks", func() {
+				_, err := LoadYAMLStrict([]byte(`---
+name: foo
+instance_groups:
+- name: redis
+  instances: 1
+  update:
+    max_in_flights: 2
+`))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("unknown field 'max_in_flights'"))
+			})
+		})
+
+		Describe("LoadYAMLAuto", func() {
+			It("loads a plain yaml manifest", func() {
+				manifest, err := LoadYAMLAuto([]byte(boshmanifest.Default))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.InstanceGroups).To(HaveLen(2))
+			})
+
+			It("loads a gzip compressed manifest", func() {
+				var buf bytes.Buffer
+				w := gzip.NewWriter(&buf)
+				_, err := w.Write([]byte(boshmanifest.Default))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(w.Close()).To(Succeed())
+
+				manifest, err := LoadYAMLAuto(buf.Bytes())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.InstanceGroups).To(HaveLen(2))
+			})
+
+			It("fails on corrupt gzip data", func() {
+				_, err := LoadYAMLAuto([]byte{0x1f, 0x8b, 0x00})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("gzip"))
+			})
+		})
+
+		Describe("MarshalCompressed", func() {
+			BeforeEach(func() {
+				manifest, err = env.DefaultBOSHManifest()
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("compresses the marshalled manifest", func() {
+				expected, err := manifest.Marshal()
+				Expect(err).NotTo(HaveOccurred())
+
+				compressed, err := manifest.MarshalCompressed()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(compressed[:2]).To(Equal([]byte{0x1f, 0x8b}))
+
+				r, err := gzip.NewReader(bytes.NewReader(compressed))
+				Expect(err).NotTo(HaveOccurred())
+				decompressed, err := ioutil.ReadAll(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(decompressed)).To(Equal(string(expected)))
+			})
+
+			It("can be loaded by LoadYAMLAuto", func() {
+				compressed, err := manifest.MarshalCompressed()
+				Expect(err).NotTo(HaveOccurred())
+
+				loaded, err := LoadYAMLAuto(compressed)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(loaded.InstanceGroupNames()).To(Equal(manifest.InstanceGroupNames()))
+			})
+		})
+
+		Describe("EstimateStoredSize", func() {
+			It("returns the length of the marshalled manifest", func() {
+				manifest, err = LoadYAML([]byte(boshmanifest.ManifestWithLargeValues))
+				Expect(err).NotTo(HaveOccurred())
+
+				expected, err := manifest.Marshal()
+				Expect(err).NotTo(HaveOccurred())
+
+				size, err := manifest.EstimateStoredSize()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(size).To(Equal(len(expected)))
+				Expect(size).To(BeNumerically("<", len(boshmanifest.ManifestWithLargeValues)))
+			})
+		})
+
+		Describe("LoadYAMLMulti", func() {
+			It("loads every document", func() {
+				manifests, err := LoadYAMLMulti([]byte(boshmanifest.Default + "\n---\n" + boshmanifest.Default))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifests).To(HaveLen(2))
+				Expect(manifests[1].InstanceGroups).To(HaveLen(len(manifests[0].InstanceGroups)))
+			})
+
+			It("returns an error if a document is invalid", func() {
+				_, err := LoadYAMLMulti([]byte(boshmanifest.Default + "\n---\ninstance_groups: {\n"))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("document 1"))
+			})
+
+			It("converts values like LoadYAML", func() {
+				text := `---
+releases:
+- name: redis
+  version: 1.10
+stemcells:
+- alias: default
+  os: opensuse-42.3
+  version: 28.10
+instance_groups:
+- name: redis
+  instances: 1
+`
+				single, err := LoadYAML([]byte(text))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(single.Releases[0].Version).To(Equal("1.10"))
+				Expect(single.Stemcells[0].Version).To(Equal("28.10"))
+
+				manifests, err := LoadYAMLMulti([]byte("# comment\n" + text + "--- {}\n---\n" + text))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifests).To(HaveLen(3))
+				Expect(manifests[0]).To(Equal(single))
+				Expect(manifests[2]).To(Equal(single))
+			})
+		})
+
 		Describe("Marshal", func() {
 			orgText := boshmanifest.BPMReleaseWithAffinity
 			tolerationText := boshmanifest.BPMReleaseWithTolerations
@@ -1189,6 +1396,46 @@ var _ = Describe("Manifest", func() {
 					Expect(result1).To(Equal(result2))
 				})
 
+				It("should produce identical bytes and checksums for equal manifests", func() {
+					expected, err := largeManifest.Marshal()
+					Expect(err).NotTo(HaveOccurred())
+					expectedSHA1, err := largeManifest.SHA1()
+					Expect(err).NotTo(HaveOccurred())
+
+					for i := 0; i < 20; i++ {
+						m, err := LoadYAML([]byte(largeText))
+						Expect(err).NotTo(HaveOccurred())
+
+						result, err := m.Marshal()
+						Expect(err).NotTo(HaveOccurred())
+						Expect(result).To(Equal(expected))
+
+						sha1, err := m.SHA1()
+						Expect(err).NotTo(HaveOccurred())
+						Expect(sha1).To(Equal(expectedSHA1))
+					}
+				})
+
+				It("should stream the same result as Marshal", func() {
+					expected, err := largeManifest.Marshal()
+					Expect(err).NotTo(HaveOccurred())
+
+					var buf bytes.Buffer
+					err = largeManifest.MarshalTo(&buf)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(buf.Bytes()).To(Equal(expected))
+				})
+
+				It("should not use anchors if compression is disabled", func() {
+					compressed, err := largeManifest.Marshal()
+					Expect(err).NotTo(HaveOccurred())
+
+					uncompressed, err := largeManifest.MarshalWithOptions(MarshalOptions{AnchorMinLength: 0})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(len(uncompressed)).To(BeNumerically(">", len(compressed)))
+					Expect(string(uncompressed)).NotTo(ContainSubstring(": &"))
+				})
+
 				It("should marshal correctly and resolve anchors", func() {
 					marshalledLargeManifest, err := largeManifest.Marshal()
 					Expect(err).NotTo(HaveOccurred())
@@ -1224,6 +1471,176 @@ var _ = Describe("Manifest", func() {
 				})
 			})
 
+			Context("with multi-line PEM values", func() {
+				const pem = "-----BEGIN CERTIFICATE-----\n" +
+					"MIIFOzCCAyOgAwIBAgIUUkyFTjBNZJyp4xFMwq9vImhV/OUwDQYJKoZIhvcNAQEN\n" +
+					"BQAwGDEWMBQGA1UEAxMNbG9nZ3JlZ2F0b3JDQTAeFw0xOTA3MjUwNjA2MDBaFw0y\n" +
+					"-----END CERTIFICATE-----\n"
+
+				var (
+					m      *Manifest
+					hash   string
+					tricky string
+				)
+
+				BeforeEach(func() {
+					sum := sha1.Sum([]byte(pem))
+					hash = hex.EncodeToString(sum[:])
+					// looks like the markers, which are rewritten to anchors and aliases
+					tricky = "first line of a long multi-line value\n" +
+						"quarks-anchor-" + hash + ": not a key\n" +
+						"key: '*" + hash + "'\n"
+
+					m = &Manifest{InstanceGroups: InstanceGroups{{
+						Name: "ig",
+						Properties: InstanceGroupProperties{Properties: map[string]interface{}{
+							"ca":    pem,
+							"other": pem,
+							"tls":   map[string]interface{}{"ca": pem},
+							"notes": tricky,
+						}},
+					}}}
+				})
+
+				It("uses anchors and re-parses the values identically", func() {
+					text, err := m.Marshal()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(text)).To(ContainSubstring("&" + hash))
+					Expect(string(text)).To(ContainSubstring(": *" + hash + "\n"))
+
+					loaded, err := LoadYAML(text)
+					Expect(err).NotTo(HaveOccurred())
+					props := loaded.InstanceGroups[0].Properties.Properties
+					Expect(props["ca"]).To(Equal(pem))
+					Expect(props["other"]).To(Equal(pem))
+					Expect(props["tls"].(map[string]interface{})["ca"]).To(Equal(pem))
+					Expect(props["notes"]).To(Equal(tricky))
+				})
+			})
+
+			Context("in canonical form", func() {
+				It("doesn't use anchors and sorts the keys at every level", func() {
+					value := strings.Repeat("duplicated value ", 10)
+					m := &Manifest{
+						Name: "canonical",
+						InstanceGroups: InstanceGroups{{
+							Name:      "ig",
+							Instances: 1,
+							Properties: InstanceGroupProperties{Properties: map[string]interface{}{
+								"zeta":  value,
+								"alpha": map[string]interface{}{"z": value, "a": 1},
+							}},
+						}},
+					}
+
+					text, err := m.MarshalCanonical()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(text)).NotTo(ContainSubstring("&"))
+					Expect(strings.Count(string(text), value)).To(Equal(2))
+
+					Expect(string(text)).To(MatchRegexp(`(?s)instance_groups:.*name: canonical`))
+					Expect(string(text)).To(MatchRegexp(`(?s)alpha:\s+a: 1\s+z: .*zeta:`))
+
+					loaded, err := LoadYAML(text)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(loaded.InstanceGroups[0].Properties.Properties["zeta"]).To(Equal(value))
+				})
+
+				It("is stable for equal manifests", func() {
+					m1, err := LoadYAML([]byte(boshmanifest.Default))
+					Expect(err).NotTo(HaveOccurred())
+					m2, err := LoadYAML([]byte(boshmanifest.Default))
+					Expect(err).NotTo(HaveOccurred())
+
+					text1, err := m1.MarshalCanonical()
+					Expect(err).NotTo(HaveOccurred())
+					text2, err := m2.MarshalCanonical()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(text1).To(Equal(text2))
+				})
+			})
+
+			Context("with excluded anchor paths", func() {
+				var (
+					m      *Manifest
+					key    string
+					config string
+				)
+
+				BeforeEach(func() {
+					key = strings.Repeat("private key ", 10)
+					config = strings.Repeat("shared config ", 10)
+					m = &Manifest{InstanceGroups: InstanceGroups{
+						{Name: "ig1", Jobs: []Job{{Name: "job", Properties: JobProperties{Properties: map[string]interface{}{
+							"tls":    map[string]interface{}{"private_key": key},
+							"config": config,
+						}}}}},
+						{Name: "ig2", Jobs: []Job{{Name: "job", Properties: JobProperties{Properties: map[string]interface{}{
+							"tls":    map[string]interface{}{"private_key": key},
+							"config": config,
+						}}}}},
+					}}
+				})
+
+				It("anchors all duplicates by default", func() {
+					text, err := m.Marshal()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(strings.Count(string(text), "private key private key")).To(Equal(1))
+					Expect(strings.Count(string(text), "shared config shared config")).To(Equal(1))
+				})
+
+				It("doesn't anchor values below the excluded paths", func() {
+					opts := DefaultMarshalOptions()
+					opts.NoAnchorPaths = []string{"instance_groups.jobs.properties.*.private_key"}
+					text, err := m.MarshalWithOptions(opts)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(strings.Count(string(text), "private key private key")).To(Equal(2))
+					Expect(strings.Count(string(text), "shared config shared config")).To(Equal(1))
+
+					loaded, err := LoadYAML(text)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(loaded.InstanceGroups[1].Jobs[0].Properties.Properties["tls"]).To(Equal(map[string]interface{}{"private_key": key}))
+					Expect(loaded.InstanceGroups[1].Jobs[0].Properties.Properties["config"]).To(Equal(config))
+				})
+
+				It("excludes whole blocks by their path", func() {
+					opts := DefaultMarshalOptions()
+					opts.NoAnchorPaths = []string{"instance_groups"}
+					text, err := m.MarshalWithOptions(opts)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(text)).NotTo(ContainSubstring(": &"))
+				})
+			})
+
+			Context("with keys containing the yaml or marker delimiters", func() {
+				It("keeps the original keys of anchored values", func() {
+					value := strings.Repeat("duplicated value ", 10)
+					m := &Manifest{InstanceGroups: InstanceGroups{{
+						Name: "ig",
+						Properties: InstanceGroupProperties{Properties: map[string]interface{}{
+							"a=b":         value,
+							"c=d=e":       value,
+							"key: quoted": value,
+							"yes":         value,
+						}},
+					}}}
+
+					text, err := m.Marshal()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(text)).To(ContainSubstring("a=b: &"))
+					Expect(string(text)).NotTo(ContainSubstring("quarks-anchor-"))
+
+					loaded, err := LoadYAML(text)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(loaded.InstanceGroups[0].Properties.Properties).To(Equal(map[string]interface{}{
+						"a=b":         value,
+						"c=d=e":       value,
+						"key: quoted": value,
+						"yes":         value,
+					}))
+				})
+			})
+
 			Context("with a regular manifest", func() {
 				BeforeEach(func() {
 					var err error
@@ -1332,108 +1749,1269 @@ var _ = Describe("Manifest", func() {
 			})
 		})
 
-		Describe("GetReleaseImage", func() {
-			BeforeEach(func() {
-				manifest, err = env.DefaultBOSHManifest()
+		Describe("Checksum", func() {
+			It("returns a prefixed sha256 which is stable", func() {
+				m, err := LoadYAML([]byte(boshmanifest.Default))
 				Expect(err).NotTo(HaveOccurred())
-			})
 
-			It("reports an error if the instance group was not found", func() {
-				_, err := manifest.GetReleaseImage("unknown-instancegroup", "redis-server")
-				Expect(err).To(HaveOccurred())
-				Expect(err.Error()).To(ContainSubstring("not found"))
-			})
+				sum, err := m.Checksum()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sum).To(HavePrefix(ChecksumPrefix))
+				Expect(sum).To(HaveLen(len(ChecksumPrefix) + 64))
 
-			It("reports an error if the stemcell was not found", func() {
-				manifest.Stemcells = []*Stemcell{}
-				_, err := manifest.GetReleaseImage("redis-slave", "redis-server")
-				Expect(err).To(HaveOccurred())
-				Expect(err.Error()).To(ContainSubstring("stemcell could not be resolved"))
+				sum2, err := m.Checksum()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sum2).To(Equal(sum))
 			})
+		})
 
-			It("reports an error if the job was not found", func() {
-				_, err := manifest.GetReleaseImage("redis-slave", "unknown-job")
-				Expect(err).To(HaveOccurred())
-				Expect(err.Error()).To(ContainSubstring("not found"))
+		Describe("Validate", func() {
+			It("returns no errors for a valid manifest", func() {
+				m, err := LoadYAML([]byte(boshmanifest.Default))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(m.Validate()).To(BeEmpty())
+			})
+
+			It("returns all dangling references at once", func() {
+				m, err := LoadYAML([]byte(`---
+releases:
+- name: redis
+  version: 1
+- name: redis
+  version: 2
+stemcells:
+- alias: default
+  os: opensuse
+  version: 1
+instance_groups:
+- name: redis-slave
+  stemcell: missing
+  jobs:
+  - name: redis-server
+    release: redis
+  - name: cf-mysql
+    release: mysql
+- name: redis-slave
+  stemcell: default
+`))
+				Expect(err).NotTo(HaveOccurred())
+
+				errs := m.Validate()
+				Expect(errs).To(HaveLen(4))
+				Expect(errs[0].Error()).To(ContainSubstring("release 'redis' is declared more than once"))
+				Expect(errs[1].Error()).To(ContainSubstring("job 'redis-server' in instance group 'redis-slave'"))
+				Expect(errs[1].Error()).To(ContainSubstring("no stemcell matches 'missing' by alias or os"))
+				Expect(errs[2].Error()).To(ContainSubstring("undeclared release 'mysql'"))
+				Expect(errs[3].Error()).To(ContainSubstring("instance group 'redis-slave' is declared more than once"))
 			})
+		})
 
-			It("reports an error if the release was not found", func() {
-				manifest.Releases = []*Release{}
-				_, err := manifest.GetReleaseImage("redis-slave", "redis-server")
-				Expect(err).To(HaveOccurred())
-				Expect(err.Error()).To(ContainSubstring("not found"))
+		Describe("Lint", func() {
+			It("returns no warnings for a clean manifest", func() {
+				m, err := LoadYAML([]byte(boshmanifest.Default))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(m.Lint()).To(BeEmpty())
+			})
+
+			It("flags common mistakes", func() {
+				m, err := LoadYAML([]byte(`---
+tags:
+  env: prod
+  Env: dev
+releases:
+- name: redis
+  version: 1
+- name: unused
+  version: 1
+- name: os-conf
+  version: 1
+instance_groups:
+- name: redis-slave
+  instances: 0
+  jobs:
+  - name: redis-server
+    release: redis
+addons:
+- name: tuning
+  jobs:
+  - name: sysctl
+    release: os-conf
+  include:
+    instance_groups: [missing]
+`))
+				Expect(err).NotTo(HaveOccurred())
+
+				warnings := m.Lint()
+				Expect(warnings).To(HaveLen(4))
+				Expect(warnings[0].Code).To(Equal(WarningZeroInstances))
+				Expect(warnings[0].Message).To(Equal("instance group 'redis-slave' has zero instances"))
+				Expect(warnings[1].Code).To(Equal(WarningDuplicateTag))
+				Expect(warnings[1].Message).To(Equal("tag 'env' duplicates tag 'Env'"))
+				Expect(warnings[2].Code).To(Equal(WarningUnusedRelease))
+				Expect(warnings[2].Message).To(ContainSubstring("'unused'"))
+				Expect(warnings[3].String()).To(Equal("addon-matches-no-instance-group: addon 'tuning' matches no instance group"))
 			})
 
-			It("calculates the release image name", func() {
-				releaseImage, err := manifest.GetReleaseImage("redis-slave", "redis-server")
-				Expect(err).ToNot(HaveOccurred())
-				Expect(releaseImage).To(Equal("hub.docker.com/cfcontainerization/redis:opensuse-42.3-28.g837c5b3-30.263-7.0.0_234.gcd7d1132-36.15.0"))
+			It("flags links between instance groups without a common network", func() {
+				m, err := LoadYAML([]byte(`---
+instance_groups:
+- name: db
+  instances: 1
+  networks:
+  - name: backend
+  jobs:
+  - name: postgres
+    release: postgres
+    provides:
+      postgres: {as: database}
+- name: api
+  instances: 1
+  networks:
+  - name: frontend
+  jobs:
+  - name: cloud_controller
+    release: capi
+    consumes:
+      database: {from: database}
+- name: worker
+  instances: 1
+  networks:
+  - name: frontend
+  - name: backend
+  jobs:
+  - name: worker
+    release: capi
+    consumes:
+      database: {from: database}
+      other_db: {from: database, network: frontend}
+- name: unassigned
+  instances: 1
+  jobs:
+  - name: tool
+    release: capi
+    consumes:
+      database: {from: database}
+`))
+				Expect(err).NotTo(HaveOccurred())
+
+				warnings := m.ListUnreachableLinks()
+				Expect(warnings).To(HaveLen(2))
+				Expect(warnings[0].Code).To(Equal(WarningUnreachableLink))
+				Expect(warnings[0].Message).To(Equal("instance group 'api' consumes 'database', but shares no network with the provider instance group 'db'"))
+				Expect(warnings[1].Message).To(Equal("instance group 'worker' consumes 'database' on network 'frontend', but the provider instance group 'db' is not on that network"))
+
+				Expect(m.Lint()).To(ContainElement(warnings[0]))
 			})
 
-			It("uses the release stemcell information if it is set", func() {
-				releaseImage, err := manifest.GetReleaseImage("diego-cell", "cflinuxfs3-rootfs-setup")
-				Expect(err).ToNot(HaveOccurred())
-				Expect(releaseImage).To(Equal("hub.docker.com/cfcontainerization/cflinuxfs3:opensuse-15.0-28.g837c5b3-30.263-7.0.0_233.gde0accd0-0.62.0"))
+			It("flags jobs without properties required by the job spec", func() {
+				m, err := LoadYAML([]byte(`---
+instance_groups:
+- name: redis-slave
+  instances: 1
+  jobs:
+  - name: redis-server
+    release: redis
+  - name: configured
+    release: redis
+    properties:
+      port: 6379
+`))
+				Expect(err).NotTo(HaveOccurred())
+
+				spec := JobSpec{Properties: map[string]struct {
+					Description string
+					Default     interface{}
+					Example     interface{}
+				}{
+					"port":     {},
+					"password": {},
+					"timeout":  {Default: 30},
+				}}
+				warnings := m.LintJobSpecs(map[string]map[string]JobSpec{
+					"redis": {"redis-server": spec, "configured": spec},
+				})
+				Expect(warnings).To(HaveLen(1))
+				Expect(warnings[0].Code).To(Equal(WarningMissingProperties))
+				Expect(warnings[0].Message).To(HaveSuffix("release 'redis' requires: password, port"))
 			})
 		})
 
-		Describe("InstanceGroupByName", func() {
+		Describe("PruneUnusedReleases", func() {
+			var m *Manifest
+
 			BeforeEach(func() {
-				manifest, err = env.DefaultBOSHManifest()
+				var err error
+				m, err = LoadYAML([]byte(`---
+releases:
+- name: redis
+  version: 1
+- name: unused
+  version: 1
+- name: os-conf
+  version: 1
+- name: bosh-dns
+  version: 1
+instance_groups:
+- name: redis-slave
+  instances: 1
+  jobs:
+  - name: redis-server
+    release: redis
+addons:
+- name: tuning
+  jobs:
+  - name: sysctl
+    release: os-conf
+  include:
+    instance_groups: [missing]
+- name: bosh-dns
+  jobs:
+  - name: bosh-dns
+    release: bosh-dns
+`))
 				Expect(err).NotTo(HaveOccurred())
 			})
 
-			It("returns an error if the instance group does not exist", func() {
-				_, found := manifest.InstanceGroups.InstanceGroupByName("foo")
-				Expect(found).To(Equal(false))
+			It("keeps the releases of addons, which are not applied yet", func() {
+				Expect(m.PruneUnusedReleases()).To(Equal([]string{"unused"}))
+				Expect(m.Releases).To(HaveLen(3))
+				Expect(m.Releases[0].Name).To(Equal("redis"))
+				Expect(m.Releases[1].Name).To(Equal("os-conf"))
+				Expect(m.Releases[2].Name).To(Equal("bosh-dns"))
 			})
 
-			It("returns the instance group if it exists", func() {
-				ig, found := manifest.InstanceGroups.InstanceGroupByName("redis-slave")
-				Expect(found).To(Equal(true))
-				Expect(ig.Name).To(Equal("redis-slave"))
+			It("removes the releases of addons, which match no instance group", func() {
+				Expect(m.ApplyAddons(zap.NewNop().Sugar())).To(Succeed())
+				Expect(m.PruneUnusedReleases()).To(Equal([]string{"unused", "os-conf"}))
+				Expect(m.Releases).To(HaveLen(2))
+				Expect(m.Releases[0].Name).To(Equal("redis"))
+				Expect(m.Releases[1].Name).To(Equal("bosh-dns"))
+			})
+
+			It("returns an empty list if all releases are used", func() {
+				m.Releases = m.Releases[:1]
+				Expect(m.PruneUnusedReleases()).To(BeEmpty())
+				Expect(m.Releases).To(HaveLen(1))
 			})
 		})
 
-		Describe("ApplyUpdateBlock", func() {
+		Describe("JobsUsingRelease", func() {
+			var m *Manifest
+
 			BeforeEach(func() {
-				manifest, err = env.BOSHManifestWithUpdateSerial()
-				Expect(err).NotTo(HaveOccurred())
+				var err error
+				m, err = LoadYAML([]byte(`---
+releases:
+- name: redis
+  version: 1
+- name: os-conf
+  version: 1
+instance_groups:
+- name: redis-slave
+  instances: 1
+  jobs:
+  - name: redis-server
+    release: redis
+  - name: user_add
+    release: os-conf
+- name: redis-master
+  instances: 1
+  jobs:
+  - name: redis-server
+    release: redis
+addons:
+- name: tuning
+  jobs:
+  - name: sysctl
+    release: os-conf
+  include:
+    instance_groups: [redis-master, redis-slave]
+`))
 				Expect(err).NotTo(HaveOccurred())
 			})
 
-			It("calculates first instance group without dependency", func() {
-				manifest.ApplyUpdateBlock()
-				Expect(manifest.InstanceGroups).To(HaveLen(4))
-				Expect(manifest.InstanceGroups[0].Properties.Quarks.RequiredService).To(BeNil())
+			It("returns the sorted jobs of all instance groups using the release", func() {
+				Expect(m.JobsUsingRelease("redis")).To(Equal([]string{"redis-master/redis-server", "redis-slave/redis-server"}))
 			})
 
-			It("respects serial=true on instance group as barrier", func() {
-				manifest.ApplyUpdateBlock()
-				Expect(manifest.InstanceGroups).To(HaveLen(4))
-				expectedRequireService := "bpm1"
-				Expect(manifest.InstanceGroups[1].Properties.Quarks.RequiredService).To(Equal(&expectedRequireService))
-				Expect(manifest.InstanceGroups[2].Properties.Quarks.RequiredService).To(Equal(&expectedRequireService))
+			It("includes addon jobs before and after applying addons", func() {
+				expected := []string{"redis-master/sysctl", "redis-slave/sysctl", "redis-slave/user_add"}
+				Expect(m.JobsUsingRelease("os-conf")).To(Equal(expected))
+
+				Expect(m.ApplyAddons(zap.NewNop().Sugar())).To(Succeed())
+				Expect(m.JobsUsingRelease("os-conf")).To(Equal(expected))
 			})
 
-			It("respects serial=true to wait for the predecessor", func() {
-				manifest.ApplyUpdateBlock()
-				Expect(manifest.InstanceGroups).To(HaveLen(4))
-				expectedRequireService := "bpm3"
-				Expect(manifest.InstanceGroups[3].Properties.Quarks.RequiredService).To(Equal(&expectedRequireService))
+			It("returns an empty list for unused releases", func() {
+				Expect(m.JobsUsingRelease("unknown")).To(BeEmpty())
 			})
+		})
 
-			It("respects update serial in manifest", func() {
-				manifestWithUpdate, err := env.BOSHManifestWithUpdateSerialInManifest()
+		Describe("ValidateQuarksProperties", func() {
+			It("accepts the quarks properties of the default manifest", func() {
+				m, err := LoadYAML([]byte(boshmanifest.Default))
 				Expect(err).NotTo(HaveOccurred())
-				manifestWithUpdate.ApplyUpdateBlock()
-				Expect(manifestWithUpdate.InstanceGroups).To(HaveLen(2))
-				Expect(manifestWithUpdate.InstanceGroups[0].Properties.Quarks.RequiredService).To(BeNil())
-				Expect(manifestWithUpdate.InstanceGroups[1].Properties.Quarks.RequiredService).To(BeNil())
+				Expect(m.ValidateQuarksProperties()).To(BeEmpty())
 			})
 
-			It("doesn't wait for instance groups without ports", func() {
-				manifestWithUpdate, err := env.BOSHManifestWithUpdateSerialAndWithoutPorts()
+			It("reports invalid ports, probes and scripts", func() {
+				m, err := LoadYAML([]byte(`---
+instance_groups:
+- name: redis
+  instances: 1
+  jobs:
+  - name: redis-server
+    release: redis
+    properties:
+      quarks:
+        ports:
+        - name: redis
+          protocol: TCP
+          internal: 6379
+        - name: redis
+          protocol: HTTP
+          internal: 70000
+        run:
+          healthcheck:
+            redis:
+              readiness:
+                initialDelaySeconds: 5
+        pre_render_scripts:
+          jobs:
+          - ""
+`))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(m.InstanceGroups[0].Jobs[0].QuarksProperties().Ports).To(HaveLen(2))
+
+				errs := m.ValidateQuarksProperties()
+				Expect(errs).To(HaveLen(5))
+				Expect(errs[0]).To(MatchError("job 'redis/redis-server' has more than one port named 'redis'"))
+				Expect(errs[1]).To(MatchError("job 'redis/redis-server' has port 'redis' with number 70000, which is out of range"))
+				Expect(errs[2]).To(MatchError("job 'redis/redis-server' has port 'redis' with unknown protocol 'HTTP'"))
+				Expect(errs[3]).To(MatchError("job 'redis/redis-server' has a readiness probe for process 'redis' without a handler"))
+				Expect(errs[4]).To(MatchError("job 'redis/redis-server' has an empty jobs pre-render script"))
+			})
+		})
+
+		Describe("ValidateCertificateVariables", func() {
+			It("accepts well-formed certificate variables", func() {
+				m, err := LoadYAML([]byte(`---
+variables:
+- name: router_ca
+  type: certificate
+  options:
+    is_ca: true
+    common_name: router-ca
+- name: router_ssl
+  type: certificate
+  options:
+    ca: router_ca
+    common_name: router
+    alternative_names: [router.example.com]
+    extended_key_usage: [server_auth, client_auth]
+- name: client_ssl
+  type: certificate
+  options:
+    ca: router_ca
+    common_name: client
+    extended_key_usage: [client_auth]
+- name: adminpass
+  type: password
+`))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(m.ValidateCertificateVariables()).To(BeEmpty())
+			})
+
+			It("returns all misconfigurations", func() {
+				m, err := LoadYAML([]byte(`---
+variables:
+- name: router_ca
+  type: certificate
+  options:
+    is_ca: true
+    extended_key_usage: [server_auth]
+- name: unsigned
+  type: certificate
+  options:
+    common_name: unsigned
+- name: wrong_ca
+  type: certificate
+  options:
+    ca: unsigned
+- name: client_only
+  type: certificate
+  options:
+    ca: router_ca
+    alternative_names: [router.example.com]
+    extended_key_usage: [client_auth, client_auth, code_signing]
+- name: no_options
+  type: certificate
+`))
+				Expect(err).NotTo(HaveOccurred())
+
+				errs := m.ValidateCertificateVariables()
+				Expect(errs).To(HaveLen(7))
+				Expect(errs[0].Error()).To(Equal("certificate variable 'router_ca' is a CA, but has extended key usages for leaf certificates"))
+				Expect(errs[1].Error()).To(Equal("certificate variable 'unsigned' is no CA and has no CA to sign it"))
+				Expect(errs[2].Error()).To(Equal("certificate variable 'wrong_ca' references CA 'unsigned', which is not a CA certificate variable"))
+				Expect(errs[3].Error()).To(Equal("certificate variable 'client_only' lists extended key usage 'client_auth' more than once"))
+				Expect(errs[4].Error()).To(Equal("certificate variable 'client_only' has unknown extended key usage 'code_signing'"))
+				Expect(errs[5].Error()).To(Equal("certificate variable 'client_only' has alternative names, but lacks extended key usage 'server_auth'"))
+				Expect(errs[6].Error()).To(Equal("certificate variable 'no_options' has no options"))
+			})
+		})
+
+		Describe("ResolveCertificateSANs", func() {
+			var m *Manifest
+
+			BeforeEach(func() {
+				var err error
+				m, err = LoadYAML([]byte(`---
+variables:
+- name: router_ca
+  type: certificate
+  options:
+    is_ca: true
+- name: router_ssl
+  type: certificate
+  options:
+    ca: router_ca
+    alternative_names: [router.example.com, router]
+    serviceRef:
+    - name: router
+    - name: headless
+- name: adminpass
+  type: password
+`))
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns the declared and the service alternative names", func() {
+				client := fake.NewClientBuilder().WithObjects(
+					&v1.Service{
+						ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "default"},
+						Spec:       v1.ServiceSpec{ClusterIP: "10.0.0.1"},
+					},
+					&v1.Service{
+						ObjectMeta: metav1.ObjectMeta{Name: "headless", Namespace: "default"},
+						Spec:       v1.ServiceSpec{ClusterIP: v1.ClusterIPNone},
+					},
+				).Build()
+
+				sans, err := m.ResolveCertificateSANs(context.Background(), client, "default")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sans).To(Equal(map[string][]string{
+					"router_ssl": {
+						"router.example.com", "router", "router.default", "router.default.svc", "10.0.0.1",
+						"headless", "headless.default", "headless.default.svc",
+					},
+				}))
+			})
+
+			It("fails if a referenced service doesn't exist", func() {
+				client := fake.NewClientBuilder().Build()
+
+				_, err := m.ResolveCertificateSANs(context.Background(), client, "default")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("failed to get service 'default/router' referenced by certificate variable 'router_ssl'"))
+			})
+		})
+
+		Describe("TagsAsLabels", func() {
+			It("returns the tags as valid kubernetes labels", func() {
+				m := &Manifest{
+					Tags: map[string]string{
+						"owner":                   "team-a",
+						"Cost Center":             "R&D / 42",
+						"Example.COM/project_id!": "-abc-",
+						"***":                     "skipped",
+						"long":                    strings.Repeat("x", 70),
+					},
+				}
+
+				Expect(m.TagsAsLabels()).To(Equal(map[string]string{
+					"owner":                  "team-a",
+					"Cost-Center":            "R-D-42",
+					"example.com/project_id": "abc",
+					"long":                   strings.Repeat("x", 63),
+				}))
+			})
+
+			It("returns an empty map without tags", func() {
+				m := &Manifest{}
+				Expect(m.TagsAsLabels()).To(BeEmpty())
+			})
+		})
+
+		Describe("ApplyReleaseIndex", func() {
+			var (
+				m     *Manifest
+				index map[string]Release
+			)
+
+			BeforeEach(func() {
+				m = &Manifest{Releases: []*Release{
+					{Name: "redis"},
+					{Name: "nats", Version: "latest"},
+					{Name: "pinned", Version: "1", URL: "docker.io/own"},
+					{Name: "unknown", Version: "3"},
+				}}
+				index = map[string]Release{
+					"redis":  {Name: "redis", Version: "36", URL: "docker.io/cfcontainerization", Stemcell: &ReleaseStemcell{OS: "opensuse-42.3", Version: "36.g03b4653-30.80-7.0.0_332.g0d8469bb"}},
+					"nats":   {Name: "nats", Version: "26", URL: "docker.io/cfcontainerization"},
+					"pinned": {Name: "pinned", Version: "1", URL: "docker.io/cfcontainerization"},
+				}
+			})
+
+			It("fills in the under-specified releases from the index", func() {
+				err := m.ApplyReleaseIndex(index, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(m.Releases[0].Version).To(Equal("36"))
+				Expect(m.Releases[0].URL).To(Equal("docker.io/cfcontainerization"))
+				Expect(m.Releases[0].Stemcell.OS).To(Equal("opensuse-42.3"))
+				Expect(m.Releases[1].Version).To(Equal("26"))
+				Expect(m.Releases[2].URL).To(Equal("docker.io/own"))
+				Expect(*m.Releases[3]).To(Equal(Release{Name: "unknown", Version: "3"}))
+			})
+
+			It("fails on conflicting versions without modifying the manifest", func() {
+				m.Releases[2].Version = "2"
+
+				err := m.ApplyReleaseIndex(index, false)
+				Expect(err).To(MatchError("release 'pinned' has version '2', but the release index has version '1'"))
+				Expect(m.Releases[0].Version).To(BeEmpty())
+			})
+
+			It("uses the index for conflicting releases when overriding", func() {
+				m.Releases[2].Version = "2"
+
+				err := m.ApplyReleaseIndex(index, true)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(m.Releases[2].Version).To(Equal("1"))
+				Expect(m.Releases[2].URL).To(Equal("docker.io/cfcontainerization"))
+			})
+		})
+
+		Describe("ValidateUpdateBlock", func() {
+			var m *Manifest
+
+			BeforeEach(func() {
+				m = &Manifest{
+					Update: &Update{CanaryWatchTime: "1000-30000", UpdateWatchTime: "5000"},
+					InstanceGroups: InstanceGroups{
+						{Name: "ig1", Update: &Update{CanaryWatchTime: " 1000 - 1000 "}},
+						{Name: "ig2"},
+					},
+				}
+			})
+
+			It("accepts ranges and absolute values", func() {
+				Expect(m.ValidateUpdateBlock()).To(Succeed())
+			})
+
+			It("rejects malformed global watch times", func() {
+				m.Update.UpdateWatchTime = "1000-"
+
+				err := m.ValidateUpdateBlock()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("invalid update block: update_watch_time '1000-'"))
+			})
+
+			It("rejects ranges with min greater than max", func() {
+				m.InstanceGroups[1].Update = &Update{CanaryWatchTime: "30000-1000"}
+
+				err := m.ValidateUpdateBlock()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("instance group 'ig2': canary_watch_time '30000-1000'"))
+				Expect(err.Error()).To(ContainSubstring("minimum 30000 is greater than maximum 1000"))
+			})
+
+			It("rejects invalid max_in_flight values", func() {
+				m.InstanceGroups[0].Update.MaxInFlight = "0%"
+
+				err := m.ValidateUpdateBlock()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("instance group 'ig1': max_in_flight '0%': percentage 0% is not within 1-100"))
+			})
+		})
+
+		Describe("MaxInFlight", func() {
+			It("parses integers and percentages", func() {
+				m, err := LoadYAML([]byte(`---
+update:
+  max_in_flight: 2
+instance_groups:
+- name: ig1
+  update:
+    max_in_flight: 20%
+`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m.Update.MaxInFlight).To(Equal("2"))
+				Expect(m.InstanceGroups[0].Update.MaxInFlight).To(Equal("20%"))
+			})
+
+			It("rejects non-integer numbers", func() {
+				m, err := LoadYAML([]byte(`---
+update:
+  max_in_flight: 1.5
+`))
+				Expect(err).ToNot(HaveOccurred())
+				err = m.ValidateUpdateBlock()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("max_in_flight '1.5': not an integer or a percentage"))
+			})
+
+			It("parses integers with strict loading", func() {
+				m, err := LoadYAMLStrict([]byte(`---
+update:
+  max_in_flight: 2
+`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m.Update.MaxInFlight).To(Equal("2"))
+			})
+
+			It("computes the effective count", func() {
+				count, err := (&Update{MaxInFlight: "3"}).MaxInFlightValue(10)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(count).To(Equal(3))
+
+				count, err = (&Update{MaxInFlight: "25%"}).MaxInFlightValue(10)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(count).To(Equal(2))
+
+				count, err = (&Update{MaxInFlight: "10%"}).MaxInFlightValue(3)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(count).To(Equal(1))
+			})
+
+			It("rejects invalid values", func() {
+				for _, value := range []string{"", "0", "-1", "101%", "0%", "abc", "x%"} {
+					_, err := (&Update{MaxInFlight: value}).MaxInFlightValue(10)
+					Expect(err).To(HaveOccurred(), value)
+				}
+			})
+		})
+
+		Describe("GetReleaseImage", func() {
+			BeforeEach(func() {
+				manifest, err = env.DefaultBOSHManifest()
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("reports an error if the instance group was not found", func() {
+				_, err := manifest.GetReleaseImage("unknown-instancegroup", "redis-server")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("not found"))
+			})
+
+			It("reports an error if the stemcell was not found", func() {
+				manifest.Stemcells = []*Stemcell{}
+				_, err := manifest.GetReleaseImage("redis-slave", "redis-server")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("stemcell could not be resolved"))
+			})
+
+			It("reports an error if the job was not found", func() {
+				_, err := manifest.GetReleaseImage("redis-slave", "unknown-job")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("not found"))
+			})
+
+			It("reports an error if the release was not found", func() {
+				manifest.Releases = []*Release{}
+				_, err := manifest.GetReleaseImage("redis-slave", "redis-server")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("not found"))
+			})
+
+			It("calculates the release image name", func() {
+				releaseImage, err := manifest.GetReleaseImage("redis-slave", "redis-server")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(releaseImage).To(Equal("hub.docker.com/cfcontainerization/redis:opensuse-42.3-28.g837c5b3-30.263-7.0.0_234.gcd7d1132-36.15.0"))
+			})
+
+			It("replaces the registry host if an override is set", func() {
+				manifest.SetImageRegistryOverride("mirror.example.com:5000/")
+				releaseImage, err := manifest.GetReleaseImage("redis-slave", "redis-server")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(releaseImage).To(Equal("mirror.example.com:5000/cfcontainerization/redis:opensuse-42.3-28.g837c5b3-30.263-7.0.0_234.gcd7d1132-36.15.0"))
+			})
+
+			It("uses the digest if the release has one", func() {
+				manifest.Releases[1].Digest = "0123abcd"
+				releaseImage, err := manifest.GetReleaseImage("redis-slave", "redis-server")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(releaseImage).To(Equal("hub.docker.com/cfcontainerization/redis@sha256:0123abcd"))
+			})
+
+			Context("when the instance group has no stemcell alias", func() {
+				BeforeEach(func() {
+					manifest.InstanceGroups[0].Stemcell = ""
+				})
+
+				It("reports an error by default", func() {
+					_, err := manifest.GetReleaseImage("redis-slave", "redis-server")
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("stemcell could not be resolved"))
+				})
+
+				It("uses the only stemcell if the fallback is enabled", func() {
+					manifest.SetSingleStemcellFallback(true)
+					releaseImage, err := manifest.GetReleaseImage("redis-slave", "redis-server")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(releaseImage).To(Equal("hub.docker.com/cfcontainerization/redis:opensuse-42.3-28.g837c5b3-30.263-7.0.0_234.gcd7d1132-36.15.0"))
+				})
+
+				It("reports an error if there are multiple stemcells", func() {
+					manifest.SetSingleStemcellFallback(true)
+					manifest.Stemcells = append(manifest.Stemcells, &Stemcell{Alias: "other", OS: "opensuse-15.0", Version: "1"})
+					_, err := manifest.GetReleaseImage("redis-slave", "redis-server")
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("stemcell could not be resolved"))
+				})
+			})
+
+			Context("when the instance group's stemcell is not an alias", func() {
+				BeforeEach(func() {
+					manifest.Stemcells = append(manifest.Stemcells,
+						&Stemcell{Alias: "sle", OS: "sle-15", Version: "2"},
+						&Stemcell{Alias: "sle-15", OS: "opensuse-15.0", Version: "3"},
+					)
+				})
+
+				It("prefers a stemcell with a matching alias over one with a matching OS", func() {
+					manifest.InstanceGroups[0].Stemcell = "sle-15"
+					releaseImage, err := manifest.GetReleaseImage("redis-slave", "redis-server")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(releaseImage).To(Equal("hub.docker.com/cfcontainerization/redis:opensuse-15.0-3-36.15.0"))
+
+					os, err := manifest.GetJobOS("redis-slave", "redis-server")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(os).To(Equal("opensuse-15.0"))
+				})
+
+				It("uses the stemcell with a matching OS", func() {
+					manifest.InstanceGroups[0].Stemcell = "opensuse-42.3"
+					releaseImage, err := manifest.GetReleaseImage("redis-slave", "redis-server")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(releaseImage).To(Equal("hub.docker.com/cfcontainerization/redis:opensuse-42.3-28.g837c5b3-30.263-7.0.0_234.gcd7d1132-36.15.0"))
+
+					os, err := manifest.GetJobOS("redis-slave", "redis-server")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(os).To(Equal("opensuse-42.3"))
+				})
+
+				It("reports an error if neither alias nor OS match", func() {
+					manifest.InstanceGroups[0].Stemcell = "ubuntu-xenial"
+					_, err := manifest.GetReleaseImage("redis-slave", "redis-server")
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("stemcell could not be resolved"))
+
+					_, err = manifest.GetJobOS("redis-slave", "redis-server")
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("stemcell OS could not be resolved"))
+				})
+			})
+
+			It("uses the release stemcell information if it is set", func() {
+				releaseImage, err := manifest.GetReleaseImage("diego-cell", "cflinuxfs3-rootfs-setup")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(releaseImage).To(Equal("hub.docker.com/cfcontainerization/cflinuxfs3:opensuse-15.0-28.g837c5b3-30.263-7.0.0_233.gde0accd0-0.62.0"))
+			})
+		})
+
+		Describe("NetworkNames", func() {
+			It("lists the declared networks in order", func() {
+				m, err := LoadYAML([]byte(`---
+networks:
+- name: default
+  type: manual
+  subnets:
+  - range: 10.0.0.0/24
+- name: private
+instance_groups:
+- name: ig1
+  networks:
+  - name: private
+`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m.NetworkNames()).To(Equal([]string{"default", "private"}))
+				Expect(m.Networks[0].Type).To(Equal("manual"))
+				Expect(m.Networks[0].Subnets[0]["range"]).To(Equal("10.0.0.0/24"))
+			})
+
+			It("returns an empty list if no networks are declared", func() {
+				Expect((&Manifest{}).NetworkNames()).To(BeEmpty())
+			})
+		})
+
+		Describe("InstanceGroup", func() {
+			BeforeEach(func() {
+				manifest, err = env.DefaultBOSHManifest()
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("lists the instance group names in order", func() {
+				Expect(manifest.InstanceGroupNames()).To(Equal([]string{"redis-slave", "diego-cell"}))
+			})
+
+			It("finds instance groups by name", func() {
+				ig, found := manifest.InstanceGroup("diego-cell")
+				Expect(found).To(BeTrue())
+				Expect(ig).To(Equal(manifest.InstanceGroups[1]))
+
+				_, found = manifest.InstanceGroup("unknown")
+				Expect(found).To(BeFalse())
+			})
+		})
+
+		Describe("IsCompiledRelease", func() {
+			It("detects releases with a stemcell", func() {
+				m := &Manifest{
+					Releases: []*Release{
+						{Name: "source", Version: "1"},
+						{Name: "compiled", Version: "2", Stemcell: &ReleaseStemcell{OS: "SLE_15_SP1", Version: "23.1"}},
+					},
+				}
+
+				Expect(m.IsCompiledRelease("compiled")).To(BeTrue())
+				Expect(m.IsCompiledRelease("source")).To(BeFalse())
+				Expect(m.IsCompiledRelease("unknown")).To(BeFalse())
+			})
+		})
+
+		Describe("DNSAddressesEnabled", func() {
+			It("is only enabled by the feature flag", func() {
+				m := &Manifest{}
+				Expect(m.DNSAddressesEnabled()).To(BeFalse())
+
+				m.Features = &Feature{UseDNSAddresses: pointers.Bool(false)}
+				Expect(m.DNSAddressesEnabled()).To(BeFalse())
+
+				m.Features.UseDNSAddresses = pointers.Bool(true)
+				Expect(m.DNSAddressesEnabled()).To(BeTrue())
+			})
+		})
+
+		Describe("TmpfsJobConfigEnabled", func() {
+			It("is only enabled by the feature flag", func() {
+				m := &Manifest{}
+				Expect(m.TmpfsJobConfigEnabled()).To(BeFalse())
+
+				m.Features = &Feature{UseTmpfsJobConfig: pointers.Bool(true)}
+				Expect(m.TmpfsJobConfigEnabled()).To(BeTrue())
+			})
+		})
+
+		Describe("ExtractErrand", func() {
+			BeforeEach(func() {
+				manifest, err = LoadYAML([]byte(`---
+releases:
+- name: app
+  version: "1"
+- name: tests
+  version: "2"
+- name: tools
+  version: "3"
+stemcells:
+- alias: default
+  os: opensuse-42.3
+  version: "1"
+- alias: other
+  os: opensuse-15.0
+  version: "2"
+addons:
+- name: errand-tools
+  include:
+    lifecycle: errand
+    instance_groups: [smoke-tests, other-tests]
+  jobs:
+  - name: tool
+    release: tools
+- name: service-tools
+  jobs:
+  - name: service-tool
+    release: tools
+instance_groups:
+- name: app
+  instances: 2
+  stemcell: default
+  jobs:
+  - name: app
+    release: app
+- name: smoke-tests
+  instances: 1
+  lifecycle: errand
+  stemcell: other
+  jobs:
+  - name: smoke
+    release: tests
+`))
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns a manifest with only the errand and what it needs", func() {
+				errand, err := manifest.ExtractErrand("smoke-tests")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(errand.InstanceGroupNames()).To(Equal([]string{"smoke-tests"}))
+				Expect(errand.Releases).To(HaveLen(2))
+				Expect(errand.Releases[0].Name).To(Equal("tests"))
+				Expect(errand.Releases[1].Name).To(Equal("tools"))
+				Expect(errand.Stemcells).To(HaveLen(1))
+				Expect(errand.Stemcells[0].Alias).To(Equal("other"))
+				Expect(errand.AddOns).To(HaveLen(1))
+				Expect(errand.AddOns[0].Include.InstanceGroup).To(Equal([]string{"smoke-tests"}))
+
+				Expect(manifest.InstanceGroups).To(HaveLen(2))
+				Expect(manifest.AddOns[0].Include.InstanceGroup).To(HaveLen(2))
+			})
+
+			It("fails for instance groups which are not errands", func() {
+				_, err := manifest.ExtractErrand("app")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("instance group 'app' is not an errand"))
+			})
+
+			It("fails for unknown instance groups", func() {
+				_, err := manifest.ExtractErrand("unknown")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Describe("PrefixInstanceGroups", func() {
+			BeforeEach(func() {
+				manifest, err = LoadYAML([]byte(`---
+addons:
+- name: bosh-dns-aliases
+  jobs:
+  - name: bosh-dns-aliases
+    release: bosh-dns-aliases
+    properties:
+      aliases:
+      - domain: 'nats.service.cf.internal'
+        targets:
+        - query: '*'
+          instance_group: nats
+        - query: '*'
+          instance_group: external
+- name: test
+  exclude:
+    instance_groups:
+    - nats
+  jobs:
+  - name: addon-job
+    release: redis
+instance_groups:
+- name: nats
+  instances: 1
+- name: router
+  instances: 1
+`))
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("renames instance groups and their references", func() {
+				Expect(manifest.PrefixInstanceGroups("blue-")).To(Succeed())
+
+				Expect(manifest.InstanceGroupNames()).To(Equal([]string{"blue-nats", "blue-router"}))
+				Expect(manifest.AddOns[1].Exclude.InstanceGroup).To(Equal([]string{"blue-nats"}))
+
+				aliases := manifest.AddOns[0].Jobs[0].Properties.Properties["aliases"].([]interface{})
+				targets := aliases[0].(map[string]interface{})["targets"].([]interface{})
+				Expect(targets[0].(map[string]interface{})["instance_group"]).To(Equal("blue-nats"))
+				Expect(targets[1].(map[string]interface{})["instance_group"]).To(Equal("external"))
+			})
+
+			It("fails on placement rules with unknown instance groups", func() {
+				manifest.AddOns[1].Exclude.InstanceGroup = []string{"unknown"}
+
+				err := manifest.PrefixInstanceGroups("blue-")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("addon 'test' references unknown instance group 'unknown'"))
+				Expect(manifest.InstanceGroupNames()).To(Equal([]string{"nats", "router"}))
+			})
+
+			It("doesn't modify the manifest on invalid alias targets", func() {
+				manifest.AddOns = append(manifest.AddOns, &AddOn{
+					Name: BoshDNSAddOnName,
+					Jobs: []AddOnJob{{
+						Name:       "bosh-dns",
+						Properties: JobProperties{Properties: map[string]interface{}{"aliases": "invalid"}},
+					}},
+				})
+
+				err := manifest.PrefixInstanceGroups("blue-")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("failed to rename alias targets of addon 'bosh-dns'"))
+
+				Expect(manifest.InstanceGroupNames()).To(Equal([]string{"nats", "router"}))
+				Expect(manifest.AddOns[1].Exclude.InstanceGroup).To(Equal([]string{"nats"}))
+				aliases := manifest.AddOns[0].Jobs[0].Properties.Properties["aliases"].([]interface{})
+				targets := aliases[0].(map[string]interface{})["targets"].([]interface{})
+				Expect(targets[0].(map[string]interface{})["instance_group"]).To(Equal("nats"))
+			})
+		})
+
+		Describe("GetJobProperties", func() {
+			BeforeEach(func() {
+				manifest, err = env.DefaultBOSHManifest()
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns the properties of the job", func() {
+				manifest.InstanceGroups[0].Jobs[0].Properties.Properties = map[string]interface{}{"port": 6379}
+				manifest.InstanceGroups[0].Jobs[0].Properties.Quarks.Envs = []v1.EnvVar{{Name: "FOO", Value: "bar"}}
+
+				props, err := manifest.GetJobProperties("redis-slave", "redis-server")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(props.Properties).To(Equal(map[string]interface{}{"port": 6379}))
+				Expect(props.Quarks.Envs).To(HaveLen(1))
+			})
+
+			It("reports an error if the instance group was not found", func() {
+				_, err := manifest.GetJobProperties("unknown-instancegroup", "redis-server")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("instance group 'unknown-instancegroup' not found"))
+			})
+
+			It("reports an error if the job was not found", func() {
+				_, err := manifest.GetJobProperties("redis-slave", "unknown-job")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("job 'unknown-job' not found in instance group 'redis-slave'"))
+			})
+		})
+
+		Describe("AllReleaseImages", func() {
+			BeforeEach(func() {
+				manifest, err = env.DefaultBOSHManifest()
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("lists all release images", func() {
+				images, err := manifest.AllReleaseImages()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(images).To(Equal([]string{
+					"hub.docker.com/cfcontainerization/cflinuxfs3:opensuse-15.0-28.g837c5b3-30.263-7.0.0_233.gde0accd0-0.62.0",
+					"hub.docker.com/cfcontainerization/redis:opensuse-42.3-28.g837c5b3-30.263-7.0.0_234.gcd7d1132-36.15.0",
+				}))
+			})
+
+			It("reports the instance group if a stemcell can't be resolved", func() {
+				manifest.Stemcells = []*Stemcell{}
+				_, err := manifest.AllReleaseImages()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("instance group 'redis-slave'"))
+			})
+		})
+
+		Describe("Diff", func() {
+			It("lists the changes between two manifests", func() {
+				current, err := env.DefaultBOSHManifest()
+				Expect(err).NotTo(HaveOccurred())
+				updated, err := env.DefaultBOSHManifest()
+				Expect(err).NotTo(HaveOccurred())
+
+				updated.InstanceGroups[0].Instances = 3
+				updated.Releases[1].Version = "36.16.0"
+				updated.Variables = append(updated.Variables, Variable{Name: "foo", Type: "password"})
+
+				changes, err := current.Diff(updated)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changes).To(Equal([]string{
+					"instance_group redis-slave: instances 2 -> 3",
+					"release redis: version 36.15.0 -> 36.16.0",
+					"variable foo: added",
+				}))
+			})
+		})
+
+		Describe("Merge", func() {
+			BeforeEach(func() {
+				manifest, err = env.DefaultBOSHManifest()
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("merges instance groups, releases and properties", func() {
+				overlay, err := LoadYAML([]byte(`---
+releases:
+- name: nats
+  version: "40"
+instance_groups:
+- name: redis-slave
+  instances: 3
+  jobs:
+  - name: redis-server
+    properties:
+      port: 1234
+- name: nats
+  instances: 1
+properties:
+  foo:
+    bar: baz
+`))
+				Expect(err).NotTo(HaveOccurred())
+
+				err = manifest.Merge(overlay)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.Releases).To(HaveLen(3))
+				Expect(manifest.InstanceGroups).To(HaveLen(3))
+
+				ig := manifest.InstanceGroups[0]
+				Expect(ig.Instances).To(Equal(3))
+				Expect(ig.VMType).To(Equal("medium"))
+				Expect(ig.Jobs).To(HaveLen(1))
+				Expect(ig.Jobs[0].Release).To(Equal("redis"))
+				Expect(ig.Jobs[0].Properties.Properties).To(HaveKey("port"))
+				Expect(manifest.Properties["foo"]).To(Equal(map[string]interface{}{"bar": "baz"}))
+			})
+
+			It("fails on conflicting release versions", func() {
+				overlay := &Manifest{Releases: []*Release{{Name: "redis", Version: "1"}}}
+				err := manifest.Merge(overlay)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("conflicting versions"))
+			})
+
+			It("leaves the manifest unchanged on conflicts", func() {
+				expected, err := manifest.MarshalCanonical()
+				Expect(err).NotTo(HaveOccurred())
+
+				overlay := &Manifest{
+					Releases:       []*Release{{Name: "nats", Version: "40"}, {Name: "redis", Version: "1"}},
+					InstanceGroups: InstanceGroups{{Name: "redis-slave", Instances: 7}},
+				}
+				Expect(manifest.Merge(overlay)).NotTo(Succeed())
+
+				actual, err := manifest.MarshalCanonical()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(actual)).To(Equal(string(expected)))
+			})
+
+			It("merges the env and quarks blocks of instance groups and jobs", func() {
+				manifest.InstanceGroups[0].Env.AgentEnvBoshConfig.Agent.Settings.Labels = map[string]string{"base": "label"}
+				manifest.InstanceGroups[0].Jobs[0].Properties.Quarks.Release = "redis"
+
+				overlay, err := LoadYAML([]byte(`---
+instance_groups:
+- name: redis-slave
+  env:
+    bosh:
+      agent:
+        settings:
+          serviceAccountName: overlay-account
+  properties:
+    quarks:
+      required_service: redis-master
+  jobs:
+  - name: redis-server
+    properties:
+      quarks:
+        ports:
+        - name: redis
+          protocol: TCP
+          internal: 6379
+`))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(manifest.Merge(overlay)).To(Succeed())
+				ig := manifest.InstanceGroups[0]
+				Expect(ig.Env.AgentEnvBoshConfig.Agent.Settings.ServiceAccountName).To(Equal("overlay-account"))
+				Expect(ig.Env.AgentEnvBoshConfig.Agent.Settings.Labels).To(Equal(map[string]string{"base": "label"}))
+				Expect(*ig.Properties.Quarks.RequiredService).To(Equal("redis-master"))
+				Expect(ig.Jobs[0].Properties.Quarks.Ports).To(Equal([]Port{{Name: "redis", Protocol: "TCP", Internal: 6379}}))
+				Expect(ig.Jobs[0].Properties.Quarks.Release).To(Equal("redis"))
+			})
+
+			It("doesn't share data with the overlay", func() {
+				overlay, err := LoadYAML([]byte(`---
+releases:
+- name: nats
+  version: "40"
+instance_groups:
+- name: nats
+  instances: 1
+  azs: [z1]
+  jobs:
+  - name: nats
+    release: nats
+    properties:
+      user: admin
+`))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.Merge(overlay)).To(Succeed())
+
+				overlay.Releases[0].Version = "41"
+				overlay.InstanceGroups[0].AZs[0] = "z2"
+				overlay.InstanceGroups[0].Jobs[0].Properties.Properties["user"] = "root"
+
+				ig, found := manifest.InstanceGroup("nats")
+				Expect(found).To(BeTrue())
+				Expect(manifest.Releases[len(manifest.Releases)-1].Version).To(Equal("40"))
+				Expect(ig.AZs).To(Equal([]string{"z1"}))
+				Expect(ig.Jobs[0].Properties.Properties["user"]).To(Equal("admin"))
+			})
+		})
+
+		Describe("InstanceGroupByName", func() {
+			BeforeEach(func() {
+				manifest, err = env.DefaultBOSHManifest()
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error if the instance group does not exist", func() {
+				_, found := manifest.InstanceGroups.InstanceGroupByName("foo")
+				Expect(found).To(Equal(false))
+			})
+
+			It("returns the instance group if it exists", func() {
+				ig, found := manifest.InstanceGroups.InstanceGroupByName("redis-slave")
+				Expect(found).To(Equal(true))
+				Expect(ig.Name).To(Equal("redis-slave"))
+			})
+		})
+
+		Describe("ApplyUpdateBlock", func() {
+			BeforeEach(func() {
+				manifest, err = env.BOSHManifestWithUpdateSerial()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("calculates first instance group without dependency", func() {
+				manifest.ApplyUpdateBlock()
+				Expect(manifest.InstanceGroups).To(HaveLen(4))
+				Expect(manifest.InstanceGroups[0].Properties.Quarks.RequiredService).To(BeNil())
+			})
+
+			It("respects serial=true on instance group as barrier", func() {
+				manifest.ApplyUpdateBlock()
+				Expect(manifest.InstanceGroups).To(HaveLen(4))
+				expectedRequireService := "bpm1"
+				Expect(manifest.InstanceGroups[1].Properties.Quarks.RequiredService).To(Equal(&expectedRequireService))
+				Expect(manifest.InstanceGroups[2].Properties.Quarks.RequiredService).To(Equal(&expectedRequireService))
+			})
+
+			It("respects serial=true to wait for the predecessor", func() {
+				manifest.ApplyUpdateBlock()
+				Expect(manifest.InstanceGroups).To(HaveLen(4))
+				expectedRequireService := "bpm3"
+				Expect(manifest.InstanceGroups[3].Properties.Quarks.RequiredService).To(Equal(&expectedRequireService))
+			})
+
+			It("respects update serial in manifest", func() {
+				manifestWithUpdate, err := env.BOSHManifestWithUpdateSerialInManifest()
+				Expect(err).NotTo(HaveOccurred())
+				manifestWithUpdate.ApplyUpdateBlock()
+				Expect(manifestWithUpdate.InstanceGroups).To(HaveLen(2))
+				Expect(manifestWithUpdate.InstanceGroups[0].Properties.Quarks.RequiredService).To(BeNil())
+				Expect(manifestWithUpdate.InstanceGroups[1].Properties.Quarks.RequiredService).To(BeNil())
+			})
+
+			It("doesn't wait for instance groups without ports", func() {
+				manifestWithUpdate, err := env.BOSHManifestWithUpdateSerialAndWithoutPorts()
 				Expect(err).NotTo(HaveOccurred())
 				manifestWithUpdate.ApplyUpdateBlock()
 				Expect(manifestWithUpdate.InstanceGroups).To(HaveLen(3))
@@ -1468,6 +3046,85 @@ var _ = Describe("Manifest", func() {
 			})
 		})
 
+		Describe("PropagateGlobalUpdateBlockToIGs", func() {
+			It("fills only the fields the instance groups don't set", func() {
+				serial := false
+				strategy := "create-swap-delete"
+				manifest := &Manifest{
+					Update: &Update{
+						Canaries:        1,
+						MaxInFlight:     "2",
+						CanaryWatchTime: "1000-30000",
+						UpdateWatchTime: "1000-30000",
+						Serial:          &serial,
+						VMStrategy:      &strategy,
+					},
+					InstanceGroups: InstanceGroups{
+						{Name: "without-update"},
+						{Name: "with-max-in-flight", Update: &Update{MaxInFlight: "50%"}},
+						{Name: "with-watch-times", Update: &Update{Canaries: 3, CanaryWatchTime: "500-1000"}},
+					},
+				}
+
+				manifest.PropagateGlobalUpdateBlockToIGs()
+
+				Expect(*manifest.InstanceGroups[0].Update).To(Equal(*manifest.Update))
+
+				update := manifest.InstanceGroups[1].Update
+				Expect(update.MaxInFlight).To(Equal("50%"))
+				Expect(update.Canaries).To(Equal(1))
+				Expect(update.CanaryWatchTime).To(Equal("1000-30000"))
+				Expect(update.Serial).To(Equal(&serial))
+				Expect(update.VMStrategy).To(Equal(&strategy))
+
+				update = manifest.InstanceGroups[2].Update
+				Expect(update.MaxInFlight).To(Equal("2"))
+				Expect(update.Canaries).To(Equal(3))
+				Expect(update.CanaryWatchTime).To(Equal("500-1000"))
+				Expect(update.UpdateWatchTime).To(Equal("1000-30000"))
+			})
+
+			It("keeps instance group update blocks without a global update block", func() {
+				manifest := &Manifest{
+					InstanceGroups: InstanceGroups{
+						{Name: "with-max-in-flight", Update: &Update{MaxInFlight: "1"}},
+					},
+				}
+
+				manifest.PropagateGlobalUpdateBlockToIGs()
+				Expect(manifest.InstanceGroups[0].Update.MaxInFlight).To(Equal("1"))
+			})
+		})
+
+		Describe("ValidateSerial", func() {
+			BeforeEach(func() {
+				manifest = &Manifest{
+					Update: &Update{Serial: pointer.BoolPtr(true)},
+					InstanceGroups: InstanceGroups{
+						{Name: "default"},
+						{Name: "parallel", Update: &Update{Serial: pointer.BoolPtr(false)}},
+						{Name: "serial", Update: &Update{Serial: pointer.BoolPtr(true)}},
+					},
+				}
+			})
+
+			It("lists instance groups which contradict the global serial setting", func() {
+				Expect(manifest.SerialConflicts()).To(Equal([]string{"parallel"}))
+
+				manifest.Update.Serial = nil
+				Expect(manifest.SerialConflicts()).To(BeEmpty())
+			})
+
+			It("only returns an error in strict mode", func() {
+				log := zap.NewNop().Sugar()
+				Expect(manifest.ValidateSerial(log, false)).To(Succeed())
+
+				err := manifest.ValidateSerial(log, true)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("instance groups [parallel] disable serial updates"))
+			})
+		})
+
 		Describe("ListMissingProviders", func() {
 			It("finds missing providers if an ig has multiple jobs", func() {
 				manifest, err := LoadYAML([]byte(`---
@@ -1486,6 +3143,110 @@ instance_groups:
 				Expect(manifest.ListMissingProviders()).To(HaveLen(1))
 			})
 		})
+
+		Describe("ListProviderTypeMismatches", func() {
+			It("lists consumers expecting a different link type", func() {
+				manifest, err := LoadYAML([]byte(`---
+instance_groups:
+- name: api
+  jobs:
+  - name: cloud_controller_ng
+    release: capi
+    provides:
+      cloud_controller:
+        as: cc
+  - name: worker
+    release: capi
+    consumes:
+      cloud_controller:
+        from: cc
+  - name: router
+    release: routing
+    consumes:
+      cc: {}`))
+				Expect(err).NotTo(HaveOccurred())
+
+				specs := map[string]map[string]JobSpec{
+					"capi": {
+						"cloud_controller_ng": {Provides: []JobSpecLink{{Name: "cloud_controller", Type: "cloud_controller"}}},
+						"worker":              {Consumes: []JobSpecProvider{{Name: "cloud_controller", Type: "cloud_controller"}}},
+					},
+					"routing": {
+						"router": {Consumes: []JobSpecProvider{{Name: "cc", Type: "api"}}},
+					},
+				}
+				Expect(manifest.ListProviderTypeMismatches(specs)).To(Equal([]string{
+					"api/router consumes 'cc' of type 'api', but it is provided with type 'cloud_controller'",
+				}))
+			})
+		})
+
+		Describe("MissingProvidersDetailed", func() {
+			It("lists the consumers of missing providers", func() {
+				manifest, err := LoadYAML([]byte(`---
+instance_groups:
+- name: diego-cell
+  jobs:
+  - name: loggr-udp-forwarder
+    release: loggregator-agent
+    consumes:
+      cloud_controller:
+        from: cloud_controller
+  - name: rep
+    release: diego
+    consumes:
+      cloud_controller:
+        from: cloud_controller
+- name: api
+  jobs:
+  - name: cloud_controller_ng
+    release: capi
+    consumes:
+      database:
+        from: db
+    provides:
+      uaa:
+        as: uaa
+  - name: uaa
+    release: uaa
+    consumes:
+      uaa:
+        from: uaa`))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.MissingProvidersDetailed()).To(Equal(map[string][]string{
+					"cloud_controller": {"diego-cell/loggr-udp-forwarder", "diego-cell/rep"},
+					"db":               {"api/cloud_controller_ng"},
+				}))
+			})
+		})
+		Describe("FindVariableReferences", func() {
+			It("returns the raw references in order", func() {
+				refs := FindVariableReferences([]byte(`---
+properties:
+  ca: ((!ca.certificate))
+  url: https://((system_domain)):((port))/((ssl/key))
+  literal: \((escaped))
+  again: ((system_domain))
+`))
+				Expect(refs).To(Equal([]string{"!ca.certificate", "system_domain", "port", "ssl/key", "system_domain"}))
+			})
+		})
+
+		Describe("ClassifyVariable", func() {
+			It("classifies variable references by the manifest's variables", func() {
+				m := &Manifest{Variables: []Variable{{Name: "adminpass"}, {Name: "router_ca"}, {Name: "/absolute/declared"}}}
+
+				Expect(ClassifyVariable(m, "adminpass")).To(Equal(ExplicitVariable))
+				Expect(ClassifyVariable(m, "router_ca.certificate")).To(Equal(ExplicitVariable))
+				Expect(ClassifyVariable(m, "!adminpass")).To(Equal(ExplicitVariable))
+				Expect(ClassifyVariable(m, "/absolute/declared")).To(Equal(ExplicitVariable))
+				Expect(ClassifyVariable(m, "system_domain")).To(Equal(ImplicitVariable))
+				Expect(ClassifyVariable(m, "ssl.certificate")).To(Equal(ImplicitVariable))
+				Expect(ClassifyVariable(m, "router_ca/certificate")).To(Equal(ImplicitVariable))
+				Expect(ClassifyVariable(m, "/director/deployment/var")).To(Equal(AbsoluteVariable))
+			})
+		})
+
 		Describe("ImplicitVariables", func() {
 			It("lists only implicit variables", func() {
 				manifest, err := LoadYAML([]byte(boshmanifest.GoraVars))
@@ -1496,6 +3257,75 @@ instance_groups:
 				Expect(err).NotTo(HaveOccurred())
 				Expect(vars).To(HaveLen(1))
 			})
+
+			It("handles the '!' prefix and ignores escaped placeholders", func() {
+				manifest, err := LoadYAML([]byte(`---
+instance_groups:
+- name: component1
+  properties:
+    plain: ((plain_var))
+    bang: ((!bang_var.key))
+    escaped: \((escaped_var))
+    doubled: (((doubled_var)))
+`))
+				Expect(err).NotTo(HaveOccurred())
+
+				vars, err := manifest.ImplicitVariables()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(vars).To(ConsistOf("plain_var", "bang_var"))
+			})
+		})
+
+		Describe("VariableDependencies", func() {
+			var manifest *Manifest
+
+			BeforeEach(func() {
+				var err error
+				manifest, err = LoadYAML([]byte(boshmanifest.GoraVars))
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("lists the CA of certificates", func() {
+				deps, err := manifest.VariableDependencies()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deps).To(HaveLen(5))
+				Expect(deps["ssl_cert"]).To(Equal([]string{"ssl_ca"}))
+				Expect(deps["ssl_ca"]).To(BeEmpty())
+			})
+
+			It("ignores the CA of cluster signed certificates", func() {
+				manifest.Variables[2].Options.SignerType = "cluster"
+
+				deps, err := manifest.VariableDependencies()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deps["ssl_cert"]).To(BeEmpty())
+			})
+
+			It("fails on cycles", func() {
+				manifest.Variables[1].Options.CA = "ssl_cert"
+
+				_, err := manifest.VariableDependencies()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("ssl_ca -> ssl_cert -> ssl_ca"))
+			})
+		})
+
+		Describe("UnusedVariables", func() {
+			It("lists explicit variables without references", func() {
+				manifest, err := LoadYAML([]byte(boshmanifest.GoraVars))
+				Expect(err).NotTo(HaveOccurred())
+
+				vars, err := manifest.UnusedVariables()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(vars).To(BeEmpty())
+
+				manifest.Variables = append(manifest.Variables, Variable{Name: "stale_password", Type: "password"})
+				manifest.InstanceGroups[1].Jobs[0].Properties.Properties = nil
+
+				vars, err = manifest.UnusedVariables()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(vars).To(Equal([]string{"stale_password"}))
+			})
 		})
 	})
 })
@@ -0,0 +1,221 @@
+package manifest
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// Merge merges the overlay manifest into this manifest.
+// Instance groups are merged by name, with the overlay winning on scalar
+// fields, and their jobs are merged by name. Releases, stemcells and
+// variables are united by name. Properties are merged recursively. The
+// env and quarks blocks of instance groups and jobs are merged field by
+// field, fields set in the overlay win.
+// Conflicting release versions result in an error and leave the manifest
+// unchanged. The manifest doesn't share any data with the overlay.
+func (m *Manifest) Merge(overlay *Manifest) error {
+	if overlay == nil {
+		return nil
+	}
+
+	for _, release := range overlay.Releases {
+		existing := m.release(release.Name)
+		if existing != nil && existing.Version != release.Version {
+			return errors.Errorf("conflicting versions '%s' and '%s' for release '%s'", existing.Version, release.Version, release.Name)
+		}
+	}
+
+	overlay, err := overlay.deepCopy()
+	if err != nil {
+		return errors.Wrap(err, "failed to copy overlay manifest")
+	}
+
+	for _, release := range overlay.Releases {
+		if m.release(release.Name) == nil {
+			m.Releases = append(m.Releases, release)
+		}
+	}
+
+	for _, stemcell := range overlay.Stemcells {
+		replaced := false
+		for i := range m.Stemcells {
+			if m.Stemcells[i].Alias == stemcell.Alias {
+				m.Stemcells[i] = stemcell
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			m.Stemcells = append(m.Stemcells, stemcell)
+		}
+	}
+
+	for _, variable := range overlay.Variables {
+		replaced := false
+		for i := range m.Variables {
+			if m.Variables[i].Name == variable.Name {
+				m.Variables[i] = variable
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			m.Variables = append(m.Variables, variable)
+		}
+	}
+
+	for _, ig := range overlay.InstanceGroups {
+		existing, ok := m.InstanceGroups.InstanceGroupByName(ig.Name)
+		if !ok {
+			m.InstanceGroups = append(m.InstanceGroups, ig)
+			continue
+		}
+		existing.merge(ig)
+	}
+
+	if overlay.Update != nil {
+		m.Update = overlay.Update
+	}
+	if overlay.Features != nil {
+		m.Features = overlay.Features
+	}
+	for k, v := range overlay.Tags {
+		if m.Tags == nil {
+			m.Tags = map[string]string{}
+		}
+		m.Tags[k] = v
+	}
+	m.Properties = mergeProperties(m.Properties, overlay.Properties)
+
+	return nil
+}
+
+// deepCopy returns a copy of the manifest, which doesn't share any data with
+// it. Only the fields, which are marshalled, are copied.
+func (m *Manifest) deepCopy() (*Manifest, error) {
+	data, err := m.MarshalCanonical()
+	if err != nil {
+		return nil, err
+	}
+	return LoadYAML(data)
+}
+
+// release returns the release with the given name or nil
+func (m *Manifest) release(name string) *Release {
+	for _, r := range m.Releases {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// merge merges the overlay instance group into this instance group
+func (ig *InstanceGroup) merge(overlay *InstanceGroup) {
+	if overlay.Instances != 0 {
+		ig.Instances = overlay.Instances
+	}
+	if overlay.AZs != nil {
+		ig.AZs = overlay.AZs
+	}
+	if overlay.VMType != "" {
+		ig.VMType = overlay.VMType
+	}
+	if overlay.VMExtensions != nil {
+		ig.VMExtensions = overlay.VMExtensions
+	}
+	if overlay.VMResources != nil {
+		ig.VMResources = overlay.VMResources
+	}
+	if overlay.Stemcell != "" {
+		ig.Stemcell = overlay.Stemcell
+	}
+	if overlay.PersistentDisk != nil {
+		ig.PersistentDisk = overlay.PersistentDisk
+	}
+	if overlay.PersistentDiskType != "" {
+		ig.PersistentDiskType = overlay.PersistentDiskType
+	}
+	if overlay.Networks != nil {
+		ig.Networks = overlay.Networks
+	}
+	if overlay.Update != nil {
+		ig.Update = overlay.Update
+	}
+	if overlay.LifeCycle != "" {
+		ig.LifeCycle = overlay.LifeCycle
+	}
+	ig.Properties.Properties = mergeProperties(ig.Properties.Properties, overlay.Properties.Properties)
+	mergeSetFields(reflect.ValueOf(&ig.Properties.Quarks).Elem(), reflect.ValueOf(overlay.Properties.Quarks))
+	mergeSetFields(reflect.ValueOf(&ig.Env).Elem(), reflect.ValueOf(overlay.Env))
+
+	for _, job := range overlay.Jobs {
+		merged := false
+		for i := range ig.Jobs {
+			if ig.Jobs[i].Name == job.Name {
+				ig.Jobs[i].merge(job)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			ig.Jobs = append(ig.Jobs, job)
+		}
+	}
+}
+
+// merge merges the overlay job into this job
+func (j *Job) merge(overlay Job) {
+	if overlay.Release != "" {
+		j.Release = overlay.Release
+	}
+	if overlay.Consumes != nil {
+		j.Consumes = overlay.Consumes
+	}
+	if overlay.Provides != nil {
+		j.Provides = overlay.Provides
+	}
+	j.Properties.Properties = mergeProperties(j.Properties.Properties, overlay.Properties.Properties)
+	mergeSetFields(reflect.ValueOf(&j.Properties.Quarks).Elem(), reflect.ValueOf(overlay.Properties.Quarks))
+}
+
+// mergeSetFields sets the fields of the struct dst to the fields of the
+// struct src, which are not zero. Structs of this package are merged field
+// by field, other values are replaced as a whole.
+func mergeSetFields(dst reflect.Value, src reflect.Value) {
+	pkg := reflect.TypeOf(Manifest{}).PkgPath()
+	for i := 0; i < src.NumField(); i++ {
+		field := dst.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		value := src.Field(i)
+		if value.Kind() == reflect.Struct && value.Type().PkgPath() == pkg {
+			mergeSetFields(field, value)
+			continue
+		}
+		if !value.IsZero() {
+			field.Set(value)
+		}
+	}
+}
+
+// mergeProperties recursively merges the overlay properties into base
+func mergeProperties(base map[string]interface{}, overlay map[string]interface{}) map[string]interface{} {
+	if base == nil {
+		return overlay
+	}
+
+	for k, v := range overlay {
+		baseMap, ok := base[k].(map[string]interface{})
+		overlayMap, ok2 := v.(map[string]interface{})
+		if ok && ok2 {
+			base[k] = mergeProperties(baseMap, overlayMap)
+			continue
+		}
+		base[k] = v
+	}
+
+	return base
+}
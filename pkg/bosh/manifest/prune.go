@@ -0,0 +1,92 @@
+package manifest
+
+import (
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// PruneUnusedReleases removes the releases, which are not used by any job of
+// the instance groups or addons, and returns their names. Until addons are
+// applied, all addon jobs count as used. Call it after ApplyAddons to also
+// drop the releases of addons, which don't match any instance group.
+func (m *Manifest) PruneUnusedReleases() []string {
+	used := m.usedReleases()
+
+	removed := []string{}
+	kept := []*Release{}
+	for _, release := range m.Releases {
+		if used[release.Name] {
+			kept = append(kept, release)
+			continue
+		}
+		removed = append(removed, release.Name)
+	}
+	m.Releases = kept
+
+	return removed
+}
+
+// usedReleases returns the names of the releases used by instance group and
+// addon jobs. Once addons are applied, their jobs are part of the instance
+// groups, only the bosh-dns addon is never applied.
+func (m *Manifest) usedReleases() map[string]bool {
+	used := map[string]bool{}
+	for _, ig := range m.InstanceGroups {
+		for _, job := range ig.Jobs {
+			used[job.Release] = true
+		}
+	}
+	for _, addon := range m.AddOns {
+		if m.AddonsApplied() && addon.Name != BoshDNSAddOnName {
+			continue
+		}
+		for _, job := range addon.Jobs {
+			used[job.Release] = true
+		}
+	}
+	return used
+}
+
+// JobsUsingRelease returns the sorted 'instanceGroup/job' names of the jobs,
+// which use the release. Jobs of addons, which are not applied yet, are
+// listed for the instance groups they would be applied to. The bosh-dns
+// addon is never applied to instance groups, so its jobs are not listed.
+func (m *Manifest) JobsUsingRelease(releaseName string) []string {
+	jobs := map[string]bool{}
+	for _, ig := range m.InstanceGroups {
+		for _, job := range ig.Jobs {
+			if job.Release == releaseName {
+				jobs[ig.Name+"/"+job.Name] = true
+			}
+		}
+	}
+
+	// placement errors are reported by ApplyAddons
+	log := zap.NewNop().Sugar()
+	for _, addon := range m.AddOns {
+		if addon.Name == BoshDNSAddOnName {
+			continue
+		}
+		for _, ig := range m.InstanceGroups {
+			if ig.addOnApplied(addon) {
+				continue
+			}
+			if match, err := m.addOnMatch(log, addon, ig); err != nil || !match {
+				continue
+			}
+			for _, job := range addon.Jobs {
+				if job.Release == releaseName {
+					jobs[ig.Name+"/"+job.Name] = true
+				}
+			}
+		}
+	}
+
+	result := make([]string, 0, len(jobs))
+	for job := range jobs {
+		result = append(result, job)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,49 @@
+package manifest
+
+import (
+	"fmt"
+)
+
+// ApplyReleaseIndex fills in the version, URL and stemcell of releases, which
+// are listed in the index, but not fully specified in the manifest. A version
+// of 'latest' counts as unspecified. Releases with a different version than
+// the index are an error, unless override is set, which makes the index win.
+// The manifest is not modified on error.
+func (m *Manifest) ApplyReleaseIndex(index map[string]Release, override bool) error {
+	if !override {
+		for _, release := range m.Releases {
+			indexed, ok := index[release.Name]
+			if !ok || unspecifiedVersion(release.Version) || unspecifiedVersion(indexed.Version) {
+				continue
+			}
+			if release.Version != indexed.Version {
+				return fmt.Errorf("release '%s' has version '%s', but the release index has version '%s'", release.Name, release.Version, indexed.Version)
+			}
+		}
+	}
+
+	for _, release := range m.Releases {
+		indexed, ok := index[release.Name]
+		if !ok {
+			continue
+		}
+
+		if !unspecifiedVersion(indexed.Version) && (override || unspecifiedVersion(release.Version)) {
+			release.Version = indexed.Version
+		}
+		if indexed.URL != "" && (override || release.URL == "") {
+			release.URL = indexed.URL
+		}
+		if indexed.Stemcell != nil && (override || release.Stemcell == nil) {
+			stemcell := *indexed.Stemcell
+			release.Stemcell = &stemcell
+		}
+	}
+
+	return nil
+}
+
+// unspecifiedVersion returns true if the release version has to be looked up
+func unspecifiedVersion(version string) bool {
+	return version == "" || version == "latest"
+}
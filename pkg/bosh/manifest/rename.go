@@ -0,0 +1,101 @@
+package manifest
+
+import (
+	"fmt"
+)
+
+// PrefixInstanceGroups prefixes the names of all instance groups and updates
+// the references to them in addon placement rules and bosh-dns alias targets.
+// References to unknown instance groups in placement rules are an error, as
+// they would no longer match after renaming. Alias targets may point to other
+// kube services, so unknown instance groups are kept as they are.
+func (m *Manifest) PrefixInstanceGroups(prefix string) error {
+	renamed := make(map[string]string, len(m.InstanceGroups))
+	for _, ig := range m.InstanceGroups {
+		renamed[ig.Name] = prefix + ig.Name
+	}
+
+	// check all references first, so the manifest is not modified on error
+	targets := []map[string]interface{}{}
+	for _, addon := range m.AddOns {
+		for _, rules := range []*AddOnPlacementRules{addon.Include, addon.Exclude} {
+			if rules == nil {
+				continue
+			}
+			for _, name := range rules.InstanceGroup {
+				if _, ok := renamed[name]; !ok {
+					return fmt.Errorf("addon '%s' references unknown instance group '%s'", addon.Name, name)
+				}
+			}
+		}
+
+		if addon.Name != BoshDNSAddOnName && addon.Name != BOSHDNSAliasesAddOnName {
+			continue
+		}
+		for _, job := range addon.Jobs {
+			t, err := aliasTargets(job.Properties.Properties)
+			if err != nil {
+				return fmt.Errorf("failed to rename alias targets of addon '%s': %v", addon.Name, err)
+			}
+			targets = append(targets, t...)
+		}
+	}
+
+	for _, addon := range m.AddOns {
+		for _, rules := range []*AddOnPlacementRules{addon.Include, addon.Exclude} {
+			if rules == nil {
+				continue
+			}
+			for i, name := range rules.InstanceGroup {
+				rules.InstanceGroup[i] = renamed[name]
+			}
+		}
+	}
+
+	for _, t := range targets {
+		name, _ := t["instance_group"].(string)
+		if newName, ok := renamed[name]; ok {
+			t["instance_group"] = newName
+		}
+	}
+
+	for _, ig := range m.InstanceGroups {
+		ig.Name = renamed[ig.Name]
+	}
+
+	return nil
+}
+
+// aliasTargets returns the targets of the bosh-dns aliases in the job
+// properties
+func aliasTargets(props map[string]interface{}) ([]map[string]interface{}, error) {
+	result := []map[string]interface{}{}
+	aliases, ok := props["aliases"]
+	if !ok {
+		return result, nil
+	}
+	list, ok := aliases.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list of aliases, got %T", aliases)
+	}
+
+	for _, alias := range list {
+		a, ok := alias.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an alias map, got %T", alias)
+		}
+		targets, ok := a["targets"].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, target := range targets {
+			t, ok := target.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("expected a target map, got %T", target)
+			}
+			result = append(result, t)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,69 @@
+package manifest
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxLabelNameLength is the maximum length of a label value and of the
+	// name part of a label key
+	maxLabelNameLength = 63
+	// maxLabelPrefixLength is the maximum length of the DNS subdomain
+	// prefix of a label key
+	maxLabelPrefixLength = 253
+)
+
+var (
+	invalidLabelNameChars   = regexp.MustCompile(`[^-_.A-Za-z0-9]+`)
+	invalidLabelPrefixChars = regexp.MustCompile(`[^-.a-z0-9]+`)
+)
+
+// TagsAsLabels returns the manifest's tags as kubernetes labels. Invalid
+// characters are replaced by '-' and too long keys and values are
+// truncated. Tags with keys, which are empty after sanitizing, are skipped.
+func (m *Manifest) TagsAsLabels() map[string]string {
+	labels := map[string]string{}
+	for key, value := range m.Tags {
+		key = sanitizeLabelKey(key)
+		if key == "" {
+			continue
+		}
+		labels[key] = sanitizeLabelName(value)
+	}
+	return labels
+}
+
+// sanitizeLabelKey sanitizes the optional prefix and the name of a label key
+func sanitizeLabelKey(key string) string {
+	name := key
+	prefix := ""
+	if i := strings.Index(key, "/"); i >= 0 {
+		prefix = sanitizeLabelPart(invalidLabelPrefixChars, strings.ToLower(key[:i]), maxLabelPrefixLength)
+		name = key[i+1:]
+	}
+
+	name = sanitizeLabelName(name)
+	if name == "" {
+		return ""
+	}
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// sanitizeLabelName sanitizes a label value or the name part of a label key
+func sanitizeLabelName(name string) string {
+	return sanitizeLabelPart(invalidLabelNameChars, name, maxLabelNameLength)
+}
+
+// sanitizeLabelPart replaces invalid characters, truncates the result and
+// makes sure it starts and ends with an alphanumeric character
+func sanitizeLabelPart(invalid *regexp.Regexp, s string, maxLength int) string {
+	s = invalid.ReplaceAllString(s, "-")
+	if len(s) > maxLength {
+		s = s[:maxLength]
+	}
+	return strings.Trim(s, "-_.")
+}
@@ -0,0 +1,56 @@
+package manifest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxInFlightValue computes the number of instances, which are updated in
+// parallel, for the given number of instances. Like BOSH, percentages are
+// rounded down, but result in at least one instance.
+func (u *Update) MaxInFlightValue(instances int) (int, error) {
+	if u == nil || u.MaxInFlight == "" {
+		return 0, fmt.Errorf("max_in_flight is not set")
+	}
+
+	value, percent, err := parseMaxInFlight(u.MaxInFlight)
+	if err != nil {
+		return 0, fmt.Errorf("max_in_flight '%s': %v", u.MaxInFlight, err)
+	}
+	if !percent {
+		return value, nil
+	}
+
+	count := instances * value / 100
+	if count < 1 {
+		count = 1
+	}
+	return count, nil
+}
+
+// parseMaxInFlight parses an integer or a percentage. Percentages have to be
+// within 1-100, integers have to be positive.
+func parseMaxInFlight(raw string) (int, bool, error) {
+	raw = strings.TrimSpace(raw)
+
+	if strings.HasSuffix(raw, "%") {
+		value, err := strconv.Atoi(strings.TrimSuffix(raw, "%"))
+		if err != nil {
+			return 0, true, fmt.Errorf("invalid percentage")
+		}
+		if value < 1 || value > 100 {
+			return 0, true, fmt.Errorf("percentage %d%% is not within 1-100", value)
+		}
+		return value, true, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, false, fmt.Errorf("not an integer or a percentage")
+	}
+	if value < 1 {
+		return 0, false, fmt.Errorf("%d is not positive", value)
+	}
+	return value, false, nil
+}
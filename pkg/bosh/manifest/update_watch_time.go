@@ -3,6 +3,7 @@ package manifest
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 )
 
 // ExtractWatchTime computes the watch time from a range or an absolute value
@@ -24,3 +25,30 @@ func ExtractWatchTime(rawWatchTime string) (string, error) {
 	}
 	return "", fmt.Errorf("watch time string did not match regexp: %s", rawWatchTime)
 }
+
+// validateWatchTime checks that the time string is an absolute value or a
+// range with min <= max
+func validateWatchTime(rawWatchTime string) error {
+	if rawWatchTime == "" {
+		return nil
+	}
+
+	rangeRegex := regexp.MustCompile(`^\s*(\d+)\s*-\s*(\d+)\s*$`)
+	if matches := rangeRegex.FindStringSubmatch(rawWatchTime); len(matches) > 0 {
+		min, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return fmt.Errorf("invalid watch time minimum '%s'", matches[1])
+		}
+		max, err := strconv.Atoi(matches[2])
+		if err != nil {
+			return fmt.Errorf("invalid watch time maximum '%s'", matches[2])
+		}
+		if min > max {
+			return fmt.Errorf("watch time minimum %d is greater than maximum %d", min, max)
+		}
+		return nil
+	}
+
+	_, err := ExtractWatchTime(rawWatchTime)
+	return err
+}
@@ -0,0 +1,213 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+
+	qsv1a1 "code.cloudfoundry.org/quarks-secret/pkg/kube/apis/quarkssecret/v1alpha1"
+)
+
+// Validate checks the manifest for structural errors, like dangling
+// references to releases and stemcells or duplicate names. It returns all
+// errors found, instead of failing on the first one.
+func (m *Manifest) Validate() []error {
+	errs := []error{}
+
+	releases := map[string]*Release{}
+	for _, release := range m.Releases {
+		if _, ok := releases[release.Name]; ok {
+			errs = append(errs, fmt.Errorf("release '%s' is declared more than once", release.Name))
+			continue
+		}
+		releases[release.Name] = release
+	}
+
+	instanceGroups := map[string]bool{}
+	for _, ig := range m.InstanceGroups {
+		if instanceGroups[ig.Name] {
+			errs = append(errs, fmt.Errorf("instance group '%s' is declared more than once", ig.Name))
+		}
+		instanceGroups[ig.Name] = true
+
+		for _, job := range ig.Jobs {
+			release, ok := releases[job.Release]
+			if !ok {
+				errs = append(errs, fmt.Errorf("job '%s' in instance group '%s' references undeclared release '%s'", job.Name, ig.Name, job.Release))
+				continue
+			}
+
+			if release.Stemcell == nil && m.instanceGroupStemcell(ig) == nil {
+				errs = append(errs, fmt.Errorf("stemcell for job '%s' in instance group '%s' can't be resolved: release '%s' has no stemcell and no stemcell matches '%s' by alias or os", job.Name, ig.Name, release.Name, ig.Stemcell))
+			}
+		}
+	}
+
+	return errs
+}
+
+// ValidateUpdateBlock checks the watch times and max_in_flight of the global
+// update block and of all instance group update blocks. Watch times have to
+// be a millisecond value or a 'min-max' range with min <= max, max_in_flight
+// a positive integer or a percentage within 1-100.
+func (m *Manifest) ValidateUpdateBlock() error {
+	if err := m.Update.validate(); err != nil {
+		return fmt.Errorf("invalid update block: %v", err)
+	}
+
+	for _, ig := range m.InstanceGroups {
+		if err := ig.Update.validate(); err != nil {
+			return fmt.Errorf("invalid update block in instance group '%s': %v", ig.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (u *Update) validate() error {
+	if u == nil {
+		return nil
+	}
+
+	if u.MaxInFlight != "" {
+		if _, _, err := parseMaxInFlight(u.MaxInFlight); err != nil {
+			return fmt.Errorf("max_in_flight '%s': %v", u.MaxInFlight, err)
+		}
+	}
+
+	if err := validateWatchTime(u.CanaryWatchTime); err != nil {
+		return fmt.Errorf("canary_watch_time '%s': %v", u.CanaryWatchTime, err)
+	}
+	if err := validateWatchTime(u.UpdateWatchTime); err != nil {
+		return fmt.Errorf("update_watch_time '%s': %v", u.UpdateWatchTime, err)
+	}
+
+	return nil
+}
+
+// ValidateCertificateVariables checks the options of certificate variables
+// before quarks secret generates them. CAs must not have extended key usages
+// for leaf certificates, leaf certificates need a CA variable and leaf
+// certificates with alternative names need 'server_auth', if their usage is
+// restricted.
+func (m *Manifest) ValidateCertificateVariables() []error {
+	errs := []error{}
+
+	cas := map[string]bool{}
+	for _, v := range m.Variables {
+		if v.Type == qsv1a1.Certificate && v.Options != nil && v.Options.IsCA {
+			cas[v.Name] = true
+		}
+	}
+
+	for _, v := range m.Variables {
+		if v.Type != qsv1a1.Certificate {
+			continue
+		}
+		if v.Options == nil {
+			errs = append(errs, fmt.Errorf("certificate variable '%s' has no options", v.Name))
+			continue
+		}
+
+		usages := map[AuthType]bool{}
+		for _, usage := range v.Options.ExtendedKeyUsage {
+			switch {
+			case usage != ClientAuth && usage != ServerAuth:
+				errs = append(errs, fmt.Errorf("certificate variable '%s' has unknown extended key usage '%s'", v.Name, usage))
+			case usages[usage]:
+				errs = append(errs, fmt.Errorf("certificate variable '%s' lists extended key usage '%s' more than once", v.Name, usage))
+			}
+			usages[usage] = true
+		}
+
+		if v.Options.IsCA {
+			if len(usages) > 0 {
+				errs = append(errs, fmt.Errorf("certificate variable '%s' is a CA, but has extended key usages for leaf certificates", v.Name))
+			}
+			continue
+		}
+
+		switch {
+		case v.Options.CA == "":
+			errs = append(errs, fmt.Errorf("certificate variable '%s' is no CA and has no CA to sign it", v.Name))
+		case !cas[v.Options.CA]:
+			errs = append(errs, fmt.Errorf("certificate variable '%s' references CA '%s', which is not a CA certificate variable", v.Name, v.Options.CA))
+		}
+
+		if len(usages) > 0 && len(v.Options.AlternativeNames) > 0 && !usages[ServerAuth] {
+			errs = append(errs, fmt.Errorf("certificate variable '%s' has alternative names, but lacks extended key usage '%s'", v.Name, ServerAuth))
+		}
+	}
+
+	return errs
+}
+
+// ValidateQuarksProperties checks the 'quarks' properties of all jobs, which
+// would otherwise only fail when creating the pods. Ports need a unique name
+// within the job, a valid protocol and a port number in range. Health checks
+// need probe handlers and pre-render scripts must not be empty.
+func (m *Manifest) ValidateQuarksProperties() []error {
+	errs := []error{}
+	for _, ig := range m.InstanceGroups {
+		for i := range ig.Jobs {
+			job := &ig.Jobs[i]
+			quarks := job.QuarksProperties()
+			name := fmt.Sprintf("%s/%s", ig.Name, job.Name)
+
+			ports := map[string]bool{}
+			for _, port := range quarks.Ports {
+				if port.Name == "" {
+					errs = append(errs, fmt.Errorf("job '%s' has a port without a name", name))
+				} else if ports[port.Name] {
+					errs = append(errs, fmt.Errorf("job '%s' has more than one port named '%s'", name, port.Name))
+				}
+				ports[port.Name] = true
+
+				if port.Internal < 1 || port.Internal > 65535 {
+					errs = append(errs, fmt.Errorf("job '%s' has port '%s' with number %d, which is out of range", name, port.Name, port.Internal))
+				}
+				switch corev1.Protocol(port.Protocol) {
+				case "", corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP:
+				default:
+					errs = append(errs, fmt.Errorf("job '%s' has port '%s' with unknown protocol '%s'", name, port.Name, port.Protocol))
+				}
+			}
+
+			processes := make([]string, 0, len(quarks.Run.HealthCheck))
+			for process := range quarks.Run.HealthCheck {
+				processes = append(processes, process)
+			}
+			sort.Strings(processes)
+			for _, process := range processes {
+				check := quarks.Run.HealthCheck[process]
+				if !probeHasHandler(check.ReadinessProbe) {
+					errs = append(errs, fmt.Errorf("job '%s' has a readiness probe for process '%s' without a handler", name, process))
+				}
+				if !probeHasHandler(check.LivenessProbe) {
+					errs = append(errs, fmt.Errorf("job '%s' has a liveness probe for process '%s' without a handler", name, process))
+				}
+			}
+
+			scripts := map[string][]string{
+				"bpm":         quarks.PreRenderScripts.BPM,
+				"ig_resolver": quarks.PreRenderScripts.IgResolver,
+				"jobs":        quarks.PreRenderScripts.Jobs,
+			}
+			for _, kind := range []string{"bpm", "ig_resolver", "jobs"} {
+				for _, script := range scripts[kind] {
+					if script == "" {
+						errs = append(errs, fmt.Errorf("job '%s' has an empty %s pre-render script", name, kind))
+					}
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// probeHasHandler returns false for probes, which don't specify an action
+func probeHasHandler(probe *corev1.Probe) bool {
+	return probe == nil || probe.Exec != nil || probe.HTTPGet != nil || probe.TCPSocket != nil
+}
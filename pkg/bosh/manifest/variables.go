@@ -0,0 +1,67 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	qsv1a1 "code.cloudfoundry.org/quarks-secret/pkg/kube/apis/quarkssecret/v1alpha1"
+)
+
+// VariableDependencies returns, for each explicit variable, the names of the
+// variables it depends on. Certificates signed by a local CA depend on the
+// variable referenced in 'options.ca', certificates signed by the cluster
+// don't.
+// An error is returned if the CA references form a cycle.
+func (m *Manifest) VariableDependencies() (map[string][]string, error) {
+	deps := make(map[string][]string, len(m.Variables))
+	for _, v := range m.Variables {
+		deps[v.Name] = []string{}
+		if v.Options == nil || v.Options.CA == "" {
+			continue
+		}
+		if v.Options.SignerType == string(qsv1a1.ClusterSigner) {
+			continue
+		}
+		deps[v.Name] = append(deps[v.Name], v.Options.CA)
+	}
+
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// unvisited variables have no state
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := map[string]int{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("cycle in variable CA references: %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return deps, nil
+}
@@ -47,6 +47,12 @@ var (
 									Type:      "string",
 									MinLength: pointers.Int64(1),
 								},
+								"headersSecret": {
+									Type: "string",
+								},
+								"namespace": {
+									Type: "string",
+								},
 								"type": {
 									Type: "string",
 									Enum: []extv1.JSON{
@@ -59,6 +65,12 @@ var (
 										{
 											Raw: []byte(`"url"`),
 										},
+										{
+											Raw: []byte(`"file"`),
+										},
+										{
+											Raw: []byte(`"inline"`),
+										},
 									},
 								},
 							},
@@ -77,6 +89,36 @@ var (
 											Type:      "string",
 											MinLength: pointers.Int64(1),
 										},
+										"headersSecret": {
+											Type: "string",
+										},
+										"namespace": {
+											Type: "string",
+										},
+										"condition": {
+											Type: "object",
+											Properties: map[string]extv1.JSONSchemaProps{
+												"instanceGroup": {
+													Type: "string",
+												},
+												"property": {
+													Type: "object",
+													Properties: map[string]extv1.JSONSchemaProps{
+														"path": {
+															Type:      "string",
+															MinLength: pointers.Int64(1),
+														},
+														"value": {
+															Type: "string",
+														},
+													},
+													Required: []string{
+														"path",
+														"value",
+													},
+												},
+											},
+										},
 										"type": {
 											Type: "string",
 											Enum: []extv1.JSON{
@@ -89,6 +131,12 @@ var (
 												{
 													Raw: []byte(`"url"`),
 												},
+												{
+													Raw: []byte(`"file"`),
+												},
+												{
+													Raw: []byte(`"inline"`),
+												},
 											},
 										},
 									},
@@ -23,6 +23,10 @@ const (
 	SecretReference ReferenceType = "secret"
 	// URLReference represents URL reference
 	URLReference ReferenceType = "url"
+	// FileReference represents a file on the local filesystem
+	FileReference ReferenceType = "file"
+	// InlineReference carries the YAML content in the name of the reference
+	InlineReference ReferenceType = "inline"
 
 	ManifestSpecName        string = "manifest"
 	OpsSpecName             string = "ops"
@@ -99,6 +103,28 @@ type VarReference struct {
 type ResourceReference struct {
 	Name string        `json:"name"`
 	Type ReferenceType `json:"type"`
+	// HeadersSecret names a secret, whose keys and values are sent as HTTP headers for URL references
+	HeadersSecret string `json:"headersSecret,omitempty"`
+	// Namespace of a configmap or secret reference, defaults to the namespace of the BOSHDeployment
+	Namespace string `json:"namespace,omitempty"`
+	// Condition restricts applying an ops file to matching manifests, it's ignored for the manifest reference
+	Condition *OpsCondition `json:"condition,omitempty"`
+}
+
+// OpsCondition is a predicate on the manifest, all set fields have to match
+// for the ops file to be applied
+type OpsCondition struct {
+	// InstanceGroup is the name of an instance group, which has to exist
+	InstanceGroup string `json:"instanceGroup,omitempty"`
+	// Property has to have the given value
+	Property *PropertyCondition `json:"property,omitempty"`
+}
+
+// PropertyCondition compares a manifest value, addressed by a go-patch path
+// like '/instance_groups/name=diego/properties/foo', to a value
+type PropertyCondition struct {
+	Path  string `json:"path"`
+	Value string `json:"value"`
 }
 
 // BOSHDeploymentStatus defines the observed state of BOSHDeployment
@@ -141,6 +167,15 @@ func (bdpl *BOSHDeployment) GetNamespacedName() string {
 	return fmt.Sprintf("%s/%s", bdpl.Namespace, bdpl.Name)
 }
 
+// NamespaceOr returns the namespace of the referenced resource, which
+// defaults to namespace, the namespace of the BOSHDeployment
+func (r ResourceReference) NamespaceOr(namespace string) string {
+	if r.Namespace != "" {
+		return r.Namespace
+	}
+	return namespace
+}
+
 // HasDeploymentName returns true if the deployment name label is present in the set of labels
 func HasDeploymentName(l map[string]string) bool {
 	_, ok := l[LabelDeploymentName]
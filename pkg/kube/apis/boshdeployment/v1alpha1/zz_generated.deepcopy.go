@@ -77,11 +77,13 @@ func (in *BOSHDeploymentList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BOSHDeploymentSpec) DeepCopyInto(out *BOSHDeploymentSpec) {
 	*out = *in
-	out.Manifest = in.Manifest
+	in.Manifest.DeepCopyInto(&out.Manifest)
 	if in.Ops != nil {
 		in, out := &in.Ops, &out.Ops
 		*out = make([]ResourceReference, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	if in.Vars != nil {
 		in, out := &in.Vars, &out.Vars
@@ -125,9 +127,51 @@ func (in *BOSHDeploymentStatus) DeepCopy() *BOSHDeploymentStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OpsCondition) DeepCopyInto(out *OpsCondition) {
+	*out = *in
+	if in.Property != nil {
+		in, out := &in.Property, &out.Property
+		*out = new(PropertyCondition)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpsCondition.
+func (in *OpsCondition) DeepCopy() *OpsCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(OpsCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PropertyCondition) DeepCopyInto(out *PropertyCondition) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PropertyCondition.
+func (in *PropertyCondition) DeepCopy() *PropertyCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(PropertyCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourceReference) DeepCopyInto(out *ResourceReference) {
 	*out = *in
+	if in.Condition != nil {
+		in, out := &in.Condition, &out.Condition
+		*out = new(OpsCondition)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
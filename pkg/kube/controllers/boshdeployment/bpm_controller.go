@@ -32,11 +32,15 @@ import (
 // BOSH errands.
 func AddBPM(ctx context.Context, config *config.Config, mgr manager.Manager) error {
 	ctx = ctxlog.NewContextWithRecorder(ctx, "bpm-reconciler", mgr.GetEventRecorderFor("bpm-recorder"))
+	converter := bpmconverter.NewConverter(bpmconverter.NewVolumeFactory(), bpmconverter.NewContainerFactory)
+	if bpmconverter.TagLabelsEnabled() {
+		converter.EnableTagLabels(ctxlog.ExtractLogger(ctx))
+	}
 	r := NewBPMReconciler(
 		ctx, config, mgr,
 		desiredmanifest.NewDesiredManifest(mgr.GetClient()),
 		controllerutil.SetControllerReference,
-		bpmconverter.NewConverter(bpmconverter.NewVolumeFactory(), bpmconverter.NewContainerFactory),
+		converter,
 	)
 
 	// Create a new controller
@@ -31,6 +31,7 @@ import (
 	"code.cloudfoundry.org/quarks-operator/pkg/kube/controllers"
 	cfd "code.cloudfoundry.org/quarks-operator/pkg/kube/controllers/boshdeployment"
 	"code.cloudfoundry.org/quarks-operator/pkg/kube/controllers/fakes"
+	"code.cloudfoundry.org/quarks-operator/pkg/kube/util/boshdns"
 	qsv1a1 "code.cloudfoundry.org/quarks-secret/pkg/kube/apis/quarkssecret/v1alpha1"
 	cfcfg "code.cloudfoundry.org/quarks-utils/pkg/config"
 	"code.cloudfoundry.org/quarks-utils/pkg/ctxlog"
@@ -550,6 +551,154 @@ var _ = Describe("ReconcileBoshDeployment", func() {
 					_, err := reconciler.Reconcile(context.Background(), request)
 					Expect(err.Error()).To(ContainSubstring("duplicated secrets of provider"))
 				})
+
+				Context("when the link provider has a service", func() {
+					var (
+						service   *corev1.Service
+						endpoints *corev1.Endpoints
+						dnsRecord string
+					)
+
+					pod := func(name, uid, ip string) corev1.Pod {
+						return corev1.Pod{
+							ObjectMeta: metav1.ObjectMeta{
+								Name:      name,
+								Namespace: "default",
+								UID:       types.UID(uid),
+								Labels:    map[string]string{"app": "baz"},
+							},
+							Status: corev1.PodStatus{PodIP: ip},
+						}
+					}
+
+					instances := func() []bdm.JobInstance {
+						_, err := reconciler.Reconcile(context.Background(), request)
+						Expect(err).ToNot(HaveOccurred())
+
+						_, _, m, _, _ := jobFactory.InstanceGroupManifestJobArgsForCall(0)
+						links, ok := m.Properties[bdm.QuarksLinksProperty].(map[string]bdm.QuarksLink)
+						Expect(ok).To(BeTrue())
+						Expect(links["baz"].Address).To(Equal(dnsRecord))
+						return links["baz"].Instances
+					}
+
+					addresses := func() []string {
+						addrs := []string{}
+						for _, i := range instances() {
+							addrs = append(addrs, i.Address)
+						}
+						return addrs
+					}
+
+					useDNSAddresses := func() {
+						enabled := true
+						manifest.Features = &bdm.Feature{UseDNSAddresses: &enabled}
+					}
+
+					BeforeEach(func() {
+						bazSecret.Annotations[bdv1.AnnotationLinkProvidesKey] = `{"name":"baz","type":"baz-type"}`
+						dnsRecord = "baz-svc.default.svc." + boshdns.GetClusterDomain()
+						service = &corev1.Service{
+							ObjectMeta: metav1.ObjectMeta{
+								Name:        "baz-svc",
+								Namespace:   "default",
+								Annotations: map[string]string{bdv1.AnnotationLinkProviderName: "baz"},
+							},
+							Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "baz"}},
+						}
+						endpoints = &corev1.Endpoints{}
+
+						client.ListCalls(func(context context.Context, object crc.ObjectList, _ ...crc.ListOption) error {
+							switch object := object.(type) {
+							case *corev1.SecretList:
+								secretList := corev1.SecretList{Items: []corev1.Secret{*bazSecret}}
+								secretList.DeepCopyInto(object)
+							case *corev1.ServiceList:
+								serviceList := corev1.ServiceList{Items: []corev1.Service{*service}}
+								serviceList.DeepCopyInto(object)
+							case *corev1.PodList:
+								podList := corev1.PodList{Items: []corev1.Pod{
+									pod("baz-0", "uid-0", "10.0.0.1"),
+									pod("baz-1", "uid-1", "10.0.0.2"),
+								}}
+								podList.DeepCopyInto(object)
+							}
+
+							return nil
+						})
+						client.GetCalls(func(context context.Context, nn types.NamespacedName, object crc.Object) error {
+							switch object := object.(type) {
+							case *bdv1.BOSHDeployment:
+								instance.DeepCopyInto(object)
+							case *corev1.Endpoints:
+								endpoints.DeepCopyInto(object)
+							case *qjv1a1.QuarksJob:
+								return apierrors.NewNotFound(schema.GroupResource{}, nn.Name)
+							}
+
+							return nil
+						})
+					})
+
+					Context("when the service has a selector", func() {
+						It("uses the pod IPs without use_dns_addresses", func() {
+							Expect(addresses()).To(ConsistOf("10.0.0.1", "10.0.0.2"))
+						})
+
+						It("uses the service's DNS name with use_dns_addresses", func() {
+							useDNSAddresses()
+							Expect(addresses()).To(Equal([]string{dnsRecord, dnsRecord}))
+						})
+
+						It("keeps the pod IDs with use_dns_addresses", func() {
+							useDNSAddresses()
+							jobInstances := instances()
+							Expect(jobInstances).To(HaveLen(2))
+							Expect([]string{jobInstances[0].ID, jobInstances[1].ID}).To(ConsistOf("uid-0", "uid-1"))
+							Expect(jobInstances[0].Bootstrap).To(BeTrue())
+							Expect(jobInstances[1].Bootstrap).To(BeFalse())
+						})
+					})
+
+					Context("when the service has endpoint addresses", func() {
+						BeforeEach(func() {
+							service.Spec.Selector = nil
+							endpoints.Subsets = []corev1.EndpointSubset{{
+								Addresses: []corev1.EndpointAddress{{IP: "10.0.1.1"}, {IP: "10.0.1.2"}},
+							}}
+						})
+
+						It("uses the endpoint IPs without use_dns_addresses", func() {
+							Expect(addresses()).To(Equal([]string{"10.0.1.1", "10.0.1.2"}))
+						})
+
+						It("uses the service's DNS name with use_dns_addresses", func() {
+							useDNSAddresses()
+							jobInstances := instances()
+							Expect(jobInstances).To(HaveLen(2))
+							Expect(jobInstances[0].Address).To(Equal(dnsRecord))
+							Expect(jobInstances[0].ID).To(Equal("10.0.1.1"))
+							Expect(jobInstances[1].Address).To(Equal(dnsRecord))
+							Expect(jobInstances[1].ID).To(Equal("10.0.1.2"))
+						})
+					})
+
+					Context("when the service is an external name", func() {
+						BeforeEach(func() {
+							service.Spec.Selector = nil
+							service.Spec.Type = corev1.ServiceTypeExternalName
+						})
+
+						It("uses the service's DNS name without use_dns_addresses", func() {
+							Expect(addresses()).To(Equal([]string{dnsRecord}))
+						})
+
+						It("uses the service's DNS name with use_dns_addresses", func() {
+							useDNSAddresses()
+							Expect(addresses()).To(Equal([]string{dnsRecord}))
+						})
+					})
+				})
 			})
 		})
 	})
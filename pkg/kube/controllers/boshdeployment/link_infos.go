@@ -38,7 +38,7 @@ func (l *linkInfoService) List(ctx context.Context, client crc.Client, manifest
 		return converter.LinkInfos{}, nil
 	}
 
-	quarksLinks, linkInfos, err := l.nativeQuarksLinks(ctx, client, missingProviders)
+	quarksLinks, linkInfos, err := l.nativeQuarksLinks(ctx, client, missingProviders, manifest.DNSAddressesEnabled())
 	if err != nil {
 		return linkInfos, err
 	}
@@ -53,8 +53,9 @@ func (l *linkInfoService) List(ctx context.Context, client crc.Client, manifest
 }
 
 // nativeQuarksLinks finds secrets for all missing links. It creates the link
-// properties and uses data from existing services.
-func (l *linkInfoService) nativeQuarksLinks(ctx context.Context, client crc.Client, missingProviders map[string]bool) (map[string]bdm.QuarksLink, converter.LinkInfos, error) {
+// properties and uses data from existing services. If useDNS is set, the
+// instance addresses are the service's DNS name instead of IPs.
+func (l *linkInfoService) nativeQuarksLinks(ctx context.Context, client crc.Client, missingProviders map[string]bool, useDNS bool) (map[string]bdm.QuarksLink, converter.LinkInfos, error) {
 	linkInfos := converter.LinkInfos{}
 	// quarksLinks store for missingProvider names with types read from secrets
 	quarksLinks := map[string]bdm.QuarksLink{}
@@ -131,7 +132,7 @@ func (l *linkInfoService) nativeQuarksLinks(ctx context.Context, client crc.Clie
 	// Update quarksLinks section `manifest.Properties["quarks_links"]` with info from existing serviceRecords
 	for qName := range quarksLinks {
 		if svcRecord, ok := serviceRecords[qName]; ok {
-			j, err := svcRecord.jobInstances(ctx, client, l.namespace, qName)
+			j, err := svcRecord.jobInstances(ctx, client, l.namespace, qName, useDNS)
 			if err != nil {
 				return quarksLinks, linkInfos, errors.Wrapf(err, "failed to get job instances for service record '%s'", qName)
 			}
@@ -230,7 +231,9 @@ type serviceRecord struct {
 // * selector is present, use information from matched pods
 // * if addresses are present use those
 // * use the service's DNS address as a fallback
-func (sr serviceRecord) jobInstances(ctx context.Context, client crc.Client, namespace string, qName string) ([]bdm.JobInstance, error) {
+// If useDNS is set, the instances use the service's DNS address instead of
+// the IPs of the pods or endpoints, like BOSH's use_dns_addresses feature.
+func (sr serviceRecord) jobInstances(ctx context.Context, client crc.Client, namespace string, qName string, useDNS bool) ([]bdm.JobInstance, error) {
 	var jobsInstances []bdm.JobInstance
 
 	if sr.selector != nil {
@@ -242,24 +245,32 @@ func (sr serviceRecord) jobInstances(ctx context.Context, client crc.Client, nam
 		}
 
 		for i, p := range pods {
-			if len(p.Status.PodIP) == 0 {
-				return jobsInstances, fmt.Errorf("empty ip of kube native component: '%s'", p.Name)
+			address := sr.dnsRecord
+			if !useDNS {
+				if len(p.Status.PodIP) == 0 {
+					return jobsInstances, fmt.Errorf("empty ip of kube native component: '%s'", p.Name)
+				}
+				address = p.Status.PodIP
 			}
 			jobsInstances = append(jobsInstances, bdm.JobInstance{
 				Name:      qName,
 				ID:        string(p.GetUID()),
 				Index:     i,
-				Address:   p.Status.PodIP,
+				Address:   address,
 				Bootstrap: i == 0,
 			})
 		}
 	} else if sr.addresses != nil {
 		for i, a := range sr.addresses {
+			address := a
+			if useDNS {
+				address = sr.dnsRecord
+			}
 			jobsInstances = append(jobsInstances, bdm.JobInstance{
 				Name:      qName,
 				ID:        a,
 				Index:     i,
-				Address:   a,
+				Address:   address,
 				Bootstrap: i == 0,
 			})
 		}
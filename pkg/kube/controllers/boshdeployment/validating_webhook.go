@@ -148,10 +148,25 @@ func denied(msg string) admission.Response {
 	}
 }
 
-// opsResourcesExist verify if a resource exist in the namespace,
+// opsResourcesExist verify if a resource exist in its namespace, which
+// defaults to the namespace of the BOSHDeployment,
 // it will check its existence during 5 seconds,
-// otherwise it will timeout.
+// otherwise it will timeout. Only configmap and secret references are
+// checked, other reference types are skipped.
 func (v *Validator) opsResourcesExist(ctx context.Context, specOpsResource []bdv1.ResourceReference, ns string) (bool, string) {
+	refs := []bdv1.ResourceReference{}
+	namespaces := map[string]bool{}
+	for _, ref := range specOpsResource {
+		if ref.Type != bdv1.ConfigMapReference && ref.Type != bdv1.SecretReference {
+			continue
+		}
+		refs = append(refs, ref)
+		namespaces[ref.NamespaceOr(ns)] = true
+	}
+	if len(refs) == 0 {
+		return true, "all references exist"
+	}
+
 	timeOut := time.After(v.pollTimeout)
 	tick := time.NewTicker(v.pollInterval)
 	defer tick.Stop()
@@ -159,8 +174,9 @@ func (v *Validator) opsResourcesExist(ctx context.Context, specOpsResource []bdv
 	missingResources := map[string]bool{}
 
 	for {
-		configMaps := &corev1.ConfigMapList{}
-		secrets := &corev1.SecretList{}
+		// existing resources by namespace and name
+		configMaps := map[string]bool{}
+		secrets := map[string]bool{}
 
 		select {
 		case <-timeOut:
@@ -172,41 +188,44 @@ func (v *Validator) opsResourcesExist(ctx context.Context, specOpsResource []bdv
 			}
 			return false, fmt.Sprintf("Timeout reached. Resources '%s' do not exist", strings.Join(missingResourcesNames, " "))
 		case <-tick.C:
-			// List all configmaps
-			err := v.client.List(ctx, configMaps, client.InNamespace(ns))
-			if err != nil {
-				return false, fmt.Sprintf("error listing configMaps in namespace '%s': %v", ns, err)
-			}
+			for refNamespace := range namespaces {
+				// List all configmaps
+				configMapList := &corev1.ConfigMapList{}
+				err := v.client.List(ctx, configMapList, client.InNamespace(refNamespace))
+				if err != nil {
+					return false, fmt.Sprintf("error listing configMaps in namespace '%s': %v", refNamespace, err)
+				}
+				for _, configMap := range configMapList.Items {
+					configMaps[refNamespace+"/"+configMap.Name] = true
+				}
 
-			// List all secrets
-			err = v.client.List(ctx, secrets, client.InNamespace(ns))
-			if err != nil {
-				return false, fmt.Sprintf("error listing secrets in namespace '%s': %v", ns, err)
+				// List all secrets
+				secretList := &corev1.SecretList{}
+				err = v.client.List(ctx, secretList, client.InNamespace(refNamespace))
+				if err != nil {
+					return false, fmt.Sprintf("error listing secrets in namespace '%s': %v", refNamespace, err)
+				}
+				for _, secret := range secretList.Items {
+					secrets[refNamespace+"/"+secret.Name] = true
+				}
 			}
 		}
 
 		// Check to see if all references exist
 		allExist := true
-		for _, ref := range specOpsResource {
+		for _, ref := range refs {
 			resourceName := fmt.Sprintf("%s/%s", ref.Type, ref.Name)
+			if ref.NamespaceOr(ns) != ns {
+				resourceName = fmt.Sprintf("%s/%s/%s", ref.Type, ref.Namespace, ref.Name)
+			}
+			key := ref.NamespaceOr(ns) + "/" + ref.Name
 
 			found := false
 			switch ref.Type {
 			case bdv1.ConfigMapReference:
-				for _, configMap := range configMaps.Items {
-					if configMap.Name == ref.Name {
-						found = true
-						break
-					}
-				}
-
+				found = configMaps[key]
 			case bdv1.SecretReference:
-				for _, secret := range secrets.Items {
-					if secret.Name == ref.Name {
-						found = true
-						break
-					}
-				}
+				found = secrets[key]
 			}
 
 			missingResources[resourceName] = !found
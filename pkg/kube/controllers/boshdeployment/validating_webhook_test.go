@@ -74,6 +74,14 @@ var _ = Describe("When the validating webhook handles a manifest", func() {
 				Data: map[string]string{
 					bdv1.ManifestSpecName: string(manifestBytes),
 				},
+			}, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "shared-ops",
+					Namespace: "shared",
+				},
+				Data: map[string]string{
+					bdv1.OpsSpecName: "- type: replace\n  path: /name\n  value: shared\n",
+				},
 			}).
 			WithScheme(scheme).
 			Build()
@@ -95,6 +103,58 @@ var _ = Describe("When the validating webhook handles a manifest", func() {
 		}
 	})
 
+	Context("with an ops file in another namespace", func() {
+		BeforeEach(func() {
+			boshDeployment := bdv1.BOSHDeployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "deployment",
+					Namespace: "default",
+				},
+				Spec: bdv1.BOSHDeploymentSpec{
+					Manifest: bdv1.ResourceReference{
+						Type: bdv1.ConfigMapReference,
+						Name: "base-manifest",
+					},
+					Ops: []bdv1.ResourceReference{
+						{Type: bdv1.ConfigMapReference, Name: "shared-ops", Namespace: "shared"},
+					},
+				},
+			}
+			boshDeploymentBytes, _ = json.Marshal(boshDeployment)
+		})
+
+		It("the manifest is accepted", func() {
+			response := validateBoshDeployment()
+			Expect(response.AdmissionResponse.Allowed).To(BeTrue(), response.Result.String)
+		})
+	})
+
+	Context("with inline ops files", func() {
+		BeforeEach(func() {
+			boshDeployment := bdv1.BOSHDeployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "deployment",
+					Namespace: "default",
+				},
+				Spec: bdv1.BOSHDeploymentSpec{
+					Manifest: bdv1.ResourceReference{
+						Type: bdv1.ConfigMapReference,
+						Name: "base-manifest",
+					},
+					Ops: []bdv1.ResourceReference{
+						{Type: bdv1.InlineReference, Name: "- type: replace\n  path: /name\n  value: inline\n"},
+					},
+				},
+			}
+			boshDeploymentBytes, _ = json.Marshal(boshDeployment)
+		})
+
+		It("the manifest is accepted", func() {
+			response := validateBoshDeployment()
+			Expect(response.AdmissionResponse.Allowed).To(BeTrue(), response.Result.String)
+		})
+	})
+
 	Context("with an invalid canary_watch_time", func() {
 		BeforeEach(func() {
 			manifest.Update.CanaryWatchTime = "notANumber"
@@ -2,7 +2,10 @@ package boshdns
 
 import (
 	"fmt"
+	"net"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -17,6 +20,8 @@ import (
 type Corefile struct {
 	Aliases  []Alias   `json:"aliases"`
 	Handlers []Handler `json:"handlers"`
+	// Recursors replace the cluster's resolvers for queries, which don't match an alias or handler
+	Recursors []string `json:"recursors"`
 }
 
 // Alias of domain alias.
@@ -64,15 +69,104 @@ func (c *Corefile) Add(props map[string]interface{}) error {
 	if err := mapstructure.Decode(props, tmp); err != nil {
 		return errors.Wrapf(err, "failed to load dns addon config")
 	}
-	c.Aliases = append(c.Aliases, tmp.Aliases...)
+	for _, alias := range tmp.Aliases {
+		if err := c.addAlias(alias); err != nil {
+			return err
+		}
+	}
 	c.Handlers = append(c.Handlers, tmp.Handlers...)
+	c.Recursors = append(c.Recursors, tmp.Recursors...)
 
 	return nil
 }
 
-// Create the coredns corefile
-func (c *Corefile) Create(namespace string, instanceGroups bdm.InstanceGroups) (string, error) {
-	rewrites := make([]string, 0)
+// Validate checks that all recursors are a host with an optional port and
+// that no handler zone contains an alias domain, as the handler would
+// shadow the alias.
+func (c *Corefile) Validate() error {
+	for _, recursor := range c.Recursors {
+		if err := validateRecursor(recursor); err != nil {
+			return err
+		}
+	}
+
+	for _, h := range c.Handlers {
+		for _, recursor := range h.Source.Recursors {
+			if err := validateRecursor(recursor); err != nil {
+				return errors.Wrapf(err, "invalid handler for domain '%s'", h.Domain)
+			}
+		}
+
+		zone := h.Zone()
+		for _, alias := range c.Aliases {
+			domain := strings.TrimRight(alias.Domain, ".")
+			if domain == zone || strings.HasSuffix(domain, "."+zone) {
+				return fmt.Errorf("handler domain '%s' overlaps with alias '%s'", h.Domain, alias.Domain)
+			}
+		}
+	}
+
+	return nil
+}
+
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([-a-zA-Z0-9.]*[a-zA-Z0-9])?$`)
+
+// validateRecursor checks the recursor is a host or host:port
+func validateRecursor(recursor string) error {
+	host, port, err := net.SplitHostPort(recursor)
+	if err != nil {
+		// no port, coredns defaults to 53
+		host, port = recursor, ""
+	}
+
+	if net.ParseIP(host) == nil && !hostnameRegexp.MatchString(host) {
+		return fmt.Errorf("invalid recursor '%s': expected host:port", recursor)
+	}
+	if port != "" {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("invalid recursor '%s': invalid port '%s'", recursor, port)
+		}
+	}
+
+	return nil
+}
+
+// addAlias adds the alias, unless an identical alias exists. Aliases for the
+// same domain with different targets are an error, because the resolved
+// address would be nondeterministic.
+func (c *Corefile) addAlias(alias Alias) error {
+	for _, existing := range c.Aliases {
+		if existing.Domain != alias.Domain {
+			continue
+		}
+		if reflect.DeepEqual(existing.Targets, alias.Targets) {
+			return nil
+		}
+		return fmt.Errorf("conflicting aliases for domain '%s': targets [%s] and [%s]",
+			alias.Domain, describeTargets(existing.Targets), describeTargets(alias.Targets))
+	}
+
+	c.Aliases = append(c.Aliases, alias)
+	return nil
+}
+
+func describeTargets(targets []Target) string {
+	desc := make([]string, len(targets))
+	for i, t := range targets {
+		desc[i] = fmt.Sprintf("%s/%s", t.InstanceGroup, t.Query)
+	}
+	return strings.Join(desc, ", ")
+}
+
+// rewrite maps an alias domain to a kube service name
+type rewrite struct {
+	from string
+	to   string
+}
+
+// rewrites resolves the aliases to kube service names
+func (c *Corefile) rewrites(namespace string, instanceGroups bdm.InstanceGroups) []rewrite {
+	rewrites := make([]rewrite, 0)
 	for _, alias := range c.Aliases {
 		for _, target := range alias.Targets {
 			// Implement BOSH DNS placeholder alias: https://bosh.io/docs/dns/#placeholder-alias.
@@ -94,12 +188,28 @@ func (c *Corefile) Create(namespace string, instanceGroups bdm.InstanceGroups) (
 		}
 	}
 
+	return rewrites
+}
+
+// Create the coredns corefile
+func (c *Corefile) Create(namespace string, instanceGroups bdm.InstanceGroups) (string, error) {
+	rewrites := []string{}
+	for _, r := range c.rewrites(namespace, instanceGroups) {
+		rewrites = append(rewrites, newTemplate(r.from, r.to))
+	}
+
+	upstream := "/etc/resolv.conf"
+	if len(c.Recursors) > 0 {
+		upstream = strings.Join(c.Recursors, " ")
+	}
+
 	tmpl := template.Must(template.New("Corefile").Parse(corefileTemplate))
 	var config strings.Builder
 	data := struct {
 		Rewrites []string
 		Handlers []Handler
-	}{rewrites, c.Handlers}
+		Upstream string
+	}{rewrites, c.Handlers, upstream}
 	if err := tmpl.Execute(&config, data); err != nil {
 		return "", errors.Wrapf(err, "failed to generate Corefile")
 	}
@@ -107,10 +217,24 @@ func (c *Corefile) Create(namespace string, instanceGroups bdm.InstanceGroups) (
 	return config.String(), nil
 }
 
-func gatherSimpleRewrites(rewrites []string,
+// AliasTargets returns the kube service names the aliases resolve to, indexed
+// by domain. Multiple targets for one domain are separated by commas.
+func (c *Corefile) AliasTargets(namespace string, instanceGroups bdm.InstanceGroups) map[string]string {
+	targets := map[string]string{}
+	for _, r := range c.rewrites(namespace, instanceGroups) {
+		if t, ok := targets[r.from]; ok {
+			targets[r.from] = t + "," + r.to
+			continue
+		}
+		targets[r.from] = r.to
+	}
+	return targets
+}
+
+func gatherSimpleRewrites(rewrites []rewrite,
 	target Target,
 	namespace string,
-	alias Alias) []string {
+	alias Alias) []rewrite {
 
 	// We can't do simple rewrites for indexes
 	if target.Query == "_" {
@@ -119,16 +243,16 @@ func gatherSimpleRewrites(rewrites []string,
 
 	from := alias.Domain
 	to := fmt.Sprintf("%s.%s.svc.%s", target.InstanceGroup, namespace, clusterDomain)
-	rewrites = append(rewrites, newTemplate(from, to))
+	rewrites = append(rewrites, rewrite{from: from, to: to})
 
 	return rewrites
 }
 
-func gatherAllRewrites(rewrites []string,
+func gatherAllRewrites(rewrites []rewrite,
 	instanceGroup bdm.InstanceGroup,
 	target Target,
 	namespace string,
-	alias Alias) []string {
+	alias Alias) []rewrite {
 	if target.Query == "_" {
 		if len(instanceGroup.AZs) > 0 {
 			for azIndex := range instanceGroup.AZs {
@@ -153,18 +277,18 @@ func gatherAllRewrites(rewrites []string,
 			names.ServiceName(target.InstanceGroup),
 			namespace,
 			clusterDomain)
-		rewrites = append(rewrites, newTemplate(from, to))
+		rewrites = append(rewrites, rewrite{from: from, to: to})
 	}
 
 	return rewrites
 }
 
-func gatherRewritesForInstances(rewrites []string,
+func gatherRewritesForInstances(rewrites []rewrite,
 	instanceGroup bdm.InstanceGroup,
 	target Target,
 	namespace string,
 	azIndex int,
-	alias Alias) []string {
+	alias Alias) []rewrite {
 	id := ""
 	for i := 0; i < instanceGroup.Instances; i++ {
 		if azIndex > -1 {
@@ -175,7 +299,7 @@ func gatherRewritesForInstances(rewrites []string,
 		from := strings.Replace(alias.Domain, "_", id, 1)
 		serviceName := instanceGroup.IndexedServiceName(i, azIndex)
 		to := fmt.Sprintf("%s.%s.svc.%s", serviceName, namespace, clusterDomain)
-		rewrites = append(rewrites, newTemplate(from, to))
+		rewrites = append(rewrites, rewrite{from: from, to: to})
 	}
 
 	return rewrites
@@ -194,7 +318,7 @@ const corefileTemplate = `
 	{{- range $rewrite := .Rewrites }}
 	{{ $rewrite }}
 	{{- end }}
-	forward . /etc/resolv.conf
+	forward . {{ .Upstream }}
 	cache 30
 	loop
 	reload
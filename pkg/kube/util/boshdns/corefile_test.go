@@ -105,6 +105,69 @@ var _ = Describe("Corefile", func() {
 			})
 		})
 
+		When("adding the same alias twice", func() {
+			It("dedups identical aliases", func() {
+				err := corefile.Add(load(aliasAddon))
+				Expect(err).NotTo(HaveOccurred())
+				err = corefile.Add(load(aliasAddon))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(corefile.Aliases).To(HaveLen(2))
+			})
+
+			It("fails if the targets are different", func() {
+				err := corefile.Add(load(aliasAddon))
+				Expect(err).NotTo(HaveOccurred())
+				err = corefile.Add(load(strings.Replace(aliasAddon, `"instance_group": "bits"`, `"instance_group": "bits-service"`, 1)))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("conflicting aliases for domain 'bits.service.cf.internal': targets [bits/*] and [bits-service/*]"))
+			})
+		})
+
+		When("setting recursors", func() {
+			It("forwards other queries to the recursors", func() {
+				err := corefile.Add(load(`{"recursors": ["10.0.0.3:53", "dns.corp.local"]}`))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(corefile.Validate()).To(Succeed())
+
+				corefile, err := corefile.Create("default", igs)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(corefile).To(ContainSubstring(`forward . 10.0.0.3:53 dns.corp.local`))
+				Expect(corefile).NotTo(ContainSubstring(`/etc/resolv.conf`))
+			})
+
+			It("fails for malformed recursors", func() {
+				err := corefile.Add(load(`{"recursors": ["10.0.0.3:dns"]}`))
+				Expect(err).NotTo(HaveOccurred())
+
+				err = corefile.Validate()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("invalid recursor '10.0.0.3:dns'"))
+			})
+
+			It("fails for malformed handler recursors", func() {
+				err := corefile.Add(load(strings.Replace(handlerAddon, "10.0.0.2", "10.0.0.2:0", 1)))
+				Expect(err).NotTo(HaveOccurred())
+
+				err = corefile.Validate()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("invalid handler for domain 'corp.intranet.local.'"))
+			})
+		})
+
+		When("handler domains overlap with aliases", func() {
+			It("fails", func() {
+				err := corefile.Add(load(aliasAddon))
+				Expect(err).NotTo(HaveOccurred())
+				err = corefile.Add(load(strings.Replace(handlerAddon, "corp.intranet.local.", "service.cf.internal.", 1)))
+				Expect(err).NotTo(HaveOccurred())
+
+				err = corefile.Validate()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("handler domain 'service.cf.internal.' overlaps with alias"))
+			})
+		})
+
 		When("setting DNS server type", func() {
 			It("translates to a valid coredns protocol", func() {
 				tests := []struct {
@@ -35,6 +35,9 @@ func New(m bdm.Manifest) (DomainNameService, error) {
 		}
 	}
 	if found {
+		if err := dns.Corefile.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid BOSH DNS configuration")
+		}
 		return dns, nil
 	}
 
@@ -47,6 +50,23 @@ func Validate(m bdm.Manifest) error {
 	return err
 }
 
+// AliasConfigMapData returns the effective DNS aliases of the manifest as
+// ConfigMap data, mapping each alias to its target service names. It is empty
+// if the manifest has no bosh-dns addon.
+func AliasConfigMapData(m bdm.Manifest, namespace string) (map[string]string, error) {
+	dns, err := New(m)
+	if err != nil {
+		return nil, err
+	}
+
+	boshDNS, ok := dns.(*BoshDomainNameService)
+	if !ok {
+		return map[string]string{}, nil
+	}
+
+	return boshDNS.Corefile.AliasTargets(namespace, boshDNS.InstanceGroups), nil
+}
+
 // CustomDNSSetting sets the pod dns policy.
 func CustomDNSSetting(serviceIP, namespace string) (corev1.DNSPolicy, *corev1.PodDNSConfig) {
 	ndots := "5"
@@ -110,6 +110,49 @@ var _ = Describe("Interface", func() {
 		})
 	})
 
+	Context("AliasConfigMapData", func() {
+		It("maps aliases to their targets", func() {
+			m, err := manifest.LoadYAML([]byte(`---
+addons:
+- name: bosh-dns-aliases
+  jobs:
+  - name: bosh-dns-aliases
+    release: bosh-dns-aliases
+    properties:
+      aliases:
+      - domain: 'component.service.cf.internal'
+        targets:
+        - query: '*'
+          instance_group: component1
+      - domain: '_.component.service.cf.internal'
+        targets:
+        - query: '_'
+          instance_group: component1
+instance_groups:
+  - name: component1
+    instances: 2
+`))
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err := boshdns.AliasConfigMapData(*m, "default")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(Equal(map[string]string{
+				"component.service.cf.internal":              "component1.default.svc.",
+				"component1-0.component.service.cf.internal": "component1-0.default.svc.",
+				"component1-1.component.service.cf.internal": "component1-1.default.svc.",
+			}))
+		})
+
+		It("is empty when bosh dns addon is not present", func() {
+			m, err := manifest.LoadYAML([]byte(nodns))
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err := boshdns.AliasConfigMapData(*m, "default")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(BeEmpty())
+		})
+	})
+
 	Context("DNSSetting", func() {
 		It("returns clusterDNSFirst when boshdns addon is not present", func() {
 			m, err := manifest.LoadYAML([]byte(nodns))
@@ -9,13 +9,13 @@ import (
 	"code.cloudfoundry.org/quarks-utils/pkg/podref"
 )
 
-// getConfigMapsReferencedBy returns a list of all names for ConfigMaps referenced by the object
+// getConfigMapsReferencedBy returns a list of all names for ConfigMaps in namespace referenced by the object
 // The object can be an QuarksStatefulSet or a BOSHDeployment
-func getConfigMapsReferencedBy(object apis.Object) (map[string]bool, error) {
+func getConfigMapsReferencedBy(object apis.Object, namespace string) (map[string]bool, error) {
 	// Figure out the type of object
 	switch object := object.(type) {
 	case *bdv1.BOSHDeployment:
-		return getConfMapRefFromBdpl(*object), nil
+		return getConfMapRefFromBdpl(*object, namespace), nil
 	case *corev1.Pod:
 		return podref.GetConfMapRefFromPod(object.Spec), nil
 	default:
@@ -23,15 +23,19 @@ func getConfigMapsReferencedBy(object apis.Object) (map[string]bool, error) {
 	}
 }
 
-func getConfMapRefFromBdpl(object bdv1.BOSHDeployment) map[string]bool {
+// getConfMapRefFromBdpl returns the names of the ConfigMaps in namespace,
+// which are referenced by the bdpl. References can point to other namespaces
+// than the bdpl's.
+func getConfMapRefFromBdpl(object bdv1.BOSHDeployment, namespace string) map[string]bool {
 	result := map[string]bool{}
 
-	if object.Spec.Manifest.Type == bdv1.ConfigMapReference {
-		result[object.Spec.Manifest.Name] = true
+	manifest := object.Spec.Manifest
+	if manifest.Type == bdv1.ConfigMapReference && manifest.NamespaceOr(object.Namespace) == namespace {
+		result[manifest.Name] = true
 	}
 
 	for _, ops := range object.Spec.Ops {
-		if ops.Type == bdv1.ConfigMapReference {
+		if ops.Type == bdv1.ConfigMapReference && ops.NamespaceOr(object.Namespace) == namespace {
 			result[ops.Name] = true
 		}
 	}
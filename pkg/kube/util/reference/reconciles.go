@@ -61,7 +61,13 @@ func GetReconcilesForBDPL(ctx context.Context, client crc.Client, object apis.Ob
 		return nil, errors.Wrap(err, "failed to list BOSHDeployments for ConfigMap reconciles")
 	}
 
-	for _, boshDeployment := range boshDeployments.Items {
+	// BOSHDeployments of other namespaces can reference the object, too
+	crossNamespace, err := crossNamespaceBDPLs(ctx, client, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, boshDeployment := range append(boshDeployments.Items, crossNamespace...) {
 		isRef, err := references(ctx, client, &boshDeployment, object)
 		if err != nil {
 			return nil, err
@@ -79,6 +85,30 @@ func GetReconcilesForBDPL(ctx context.Context, client crc.Client, object apis.Ob
 	return reconciles, nil
 }
 
+// crossNamespaceBDPLs returns the BOSHDeployments of other namespaces, which
+// have manifest or ops references to namespace
+func crossNamespaceBDPLs(ctx context.Context, client crc.Client, namespace string) ([]bdv1.BOSHDeployment, error) {
+	all := &bdv1.BOSHDeploymentList{}
+	err := client.List(ctx, all)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list BOSHDeployments of all namespaces for cross namespace references")
+	}
+
+	result := []bdv1.BOSHDeployment{}
+	for _, bdpl := range all.Items {
+		if bdpl.Namespace == namespace {
+			continue
+		}
+		for _, ref := range append([]bdv1.ResourceReference{bdpl.Spec.Manifest}, bdpl.Spec.Ops...) {
+			if ref.NamespaceOr(bdpl.Namespace) == namespace {
+				result = append(result, bdpl)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
 // references returns true if parent uses object, e.g. as an env source
 func references(ctx context.Context, client crc.Client, parent apis.Object, object apis.Object) (bool, error) {
 	var (
@@ -89,9 +119,9 @@ func references(ctx context.Context, client crc.Client, parent apis.Object, obje
 
 	switch object := object.(type) {
 	case *corev1.ConfigMap:
-		objectReferences, err = getConfigMapsReferencedBy(parent)
+		objectReferences, err = getConfigMapsReferencedBy(parent, object.Namespace)
 	case *corev1.Secret:
-		objectReferences, err = getSecretsReferencedBy(ctx, client, parent)
+		objectReferences, err = getSecretsReferencedBy(ctx, client, parent, object.Namespace)
 		versionedSecret = vss.IsVersionedSecret(*object)
 	default:
 		return false, errors.New("can't get reconciles for unknown object type; supported types are ConfigMap and Secret")
@@ -13,12 +13,12 @@ import (
 	"code.cloudfoundry.org/quarks-utils/pkg/podref"
 )
 
-// GetSecretsReferencedBy returns a list of all names for Secrets referenced by the object
+// GetSecretsReferencedBy returns a list of all names for Secrets in namespace referenced by the object
 // The object can be an QuarksStatefulSet or a BOSHDeployment
-func getSecretsReferencedBy(ctx context.Context, client crc.Client, object interface{}) (map[string]bool, error) {
+func getSecretsReferencedBy(ctx context.Context, client crc.Client, object interface{}, namespace string) (map[string]bool, error) {
 	switch object := object.(type) {
 	case *bdv1.BOSHDeployment:
-		return getSecretRefFromBdpl(ctx, client, *object)
+		return getSecretRefFromBdpl(ctx, client, *object, namespace)
 	case *corev1.Pod:
 		return podref.GetSecretRefFromPodSpec(object.Spec), nil
 	default:
@@ -26,19 +26,27 @@ func getSecretsReferencedBy(ctx context.Context, client crc.Client, object inter
 	}
 }
 
-func getSecretRefFromBdpl(ctx context.Context, client crc.Client, object bdv1.BOSHDeployment) (map[string]bool, error) {
+// getSecretRefFromBdpl returns the names of the Secrets in namespace, which
+// are referenced by the bdpl. Manifest and ops references can point to other
+// namespaces than the bdpl's, variable secrets are always in its namespace.
+func getSecretRefFromBdpl(ctx context.Context, client crc.Client, object bdv1.BOSHDeployment, namespace string) (map[string]bool, error) {
 	result := map[string]bool{}
 
-	if object.Spec.Manifest.Type == bdv1.SecretReference {
-		result[object.Spec.Manifest.Name] = true
+	manifest := object.Spec.Manifest
+	if manifest.Type == bdv1.SecretReference && manifest.NamespaceOr(object.Namespace) == namespace {
+		result[manifest.Name] = true
 	}
 
 	for _, ops := range object.Spec.Ops {
-		if ops.Type == bdv1.SecretReference {
+		if ops.Type == bdv1.SecretReference && ops.NamespaceOr(object.Namespace) == namespace {
 			result[ops.Name] = true
 		}
 	}
 
+	if namespace != object.Namespace {
+		return result, nil
+	}
+
 	for _, userVar := range object.Spec.Vars {
 		result[userVar.Secret] = true
 	}
@@ -56,5 +64,14 @@ func getSecretRefFromBdpl(ctx context.Context, client crc.Client, object bdv1.BO
 		result[iv] = true
 	}
 
+	// Include secrets of variables in ops files
+	opsVars, err := withops.OpsVariableSecrets(ctx, &object, object.Namespace)
+	if err != nil {
+		return map[string]bool{}, errors.Wrap(err, fmt.Sprintf("Failed to read the ops file variables for BOSHDeployment '%s/%s'", object.Namespace, object.Name))
+	}
+	for _, secName := range opsVars {
+		result[secName] = true
+	}
+
 	return result, nil
 }
@@ -0,0 +1,93 @@
+package withops
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SUSE/go-patch/patch"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	bdv1 "code.cloudfoundry.org/quarks-operator/pkg/kube/apis/boshdeployment/v1alpha1"
+	"code.cloudfoundry.org/quarks-utils/pkg/ctxlog"
+)
+
+// matchingOps returns the ops files of the bdpl, whose conditions match the
+// manifest. Conditions are evaluated against the manifest before any ops
+// file is applied, so the result doesn't depend on the order of the ops
+// files. Skipped ops files are logged.
+func matchingOps(ctx context.Context, bdpl *bdv1.BOSHDeployment, manifest string) ([]bdv1.ResourceReference, error) {
+	var doc interface{}
+	parsed := false
+
+	ops := make([]bdv1.ResourceReference, 0, len(bdpl.Spec.Ops))
+	for _, op := range bdpl.Spec.Ops {
+		if op.Condition == nil {
+			ops = append(ops, op)
+			continue
+		}
+
+		if !parsed {
+			if err := yaml.Unmarshal([]byte(manifest), &doc); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse manifest of bosh deployment '%s' to evaluate ops conditions", bdpl.Name)
+			}
+			parsed = true
+		}
+
+		reason, err := opsConditionMismatch(doc, op.Condition)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to evaluate condition of ops file '%s'", op.Name)
+		}
+		if reason != "" {
+			ctxlog.Infof(ctx, "Skipping ops file '%s' of bosh deployment '%s': %s", op.Name, bdpl.Name, reason)
+			continue
+		}
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// opsConditionMismatch returns why the condition doesn't match the manifest,
+// or an empty string if it matches
+func opsConditionMismatch(doc interface{}, cond *bdv1.OpsCondition) (string, error) {
+	if cond.InstanceGroup != "" && !hasInstanceGroup(doc, cond.InstanceGroup) {
+		return fmt.Sprintf("instance group '%s' doesn't exist", cond.InstanceGroup), nil
+	}
+
+	if cond.Property != nil {
+		ptr, err := patch.NewPointerFromString(cond.Property.Path)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid property path '%s'", cond.Property.Path)
+		}
+
+		value, err := patch.FindOp{Path: ptr}.Apply(doc)
+		if err != nil {
+			return fmt.Sprintf("property '%s' doesn't exist", cond.Property.Path), nil
+		}
+		if fmt.Sprintf("%v", value) != cond.Property.Value {
+			return fmt.Sprintf("property '%s' is not '%s'", cond.Property.Path, cond.Property.Value), nil
+		}
+	}
+
+	return "", nil
+}
+
+// hasInstanceGroup returns true if the parsed manifest has an instance group
+// with the name
+func hasInstanceGroup(doc interface{}, name string) bool {
+	m, ok := doc.(map[interface{}]interface{})
+	if !ok {
+		return false
+	}
+	igs, ok := m["instance_groups"].([]interface{})
+	if !ok {
+		return false
+	}
+	for _, ig := range igs {
+		if ig, ok := ig.(map[interface{}]interface{}); ok && ig["name"] == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package withops
+
+import (
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+var (
+	// ErrSecretNotFound is returned if a secret referenced by the bdpl doesn't exist
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrSecretKeyMissing is returned if a secret doesn't contain the referenced key
+	ErrSecretKeyMissing = errors.New("secret key missing")
+	// ErrInterpolation is returned if ops files or variables can't be applied to the manifest
+	ErrInterpolation = errors.New("interpolation failed")
+)
+
+// ResolveError describes why the resolver failed. Use errors.Is with one of
+// the Err* kinds to check for the failure class and errors.As to access the
+// resource involved.
+type ResolveError struct {
+	// Kind is one of the Err* errors of this package
+	Kind      error
+	Namespace string
+	// Name of the secret or the bdpl for interpolation errors
+	Name string
+	// Key of the secret, if any
+	Key string
+	Err error
+}
+
+func newResolveError(kind error, namespace, name, key string, err error) error {
+	return &ResolveError{Kind: kind, Namespace: namespace, Name: name, Key: key, Err: err}
+}
+
+// secretGetError returns wrapped as an ErrSecretNotFound error, if the secret
+// doesn't exist. Other errors of the client are returned as they are.
+func secretGetError(err error, namespace, name string, wrapped error) error {
+	if apierrors.IsNotFound(err) {
+		return newResolveError(ErrSecretNotFound, namespace, name, "", wrapped)
+	}
+	return wrapped
+}
+
+// Error returns the message of the underlying error
+func (e *ResolveError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the underlying error for errors.Cause
+func (e *ResolveError) Cause() error {
+	return e.Err
+}
+
+// Is matches the kind of the error
+func (e *ResolveError) Is(target error) bool {
+	return target == e.Kind
+}
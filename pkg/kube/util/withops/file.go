@@ -0,0 +1,64 @@
+package withops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultFileBaseDir is the base dir of file references for new resolvers
+var defaultFileBaseDir string
+
+// SetDefaultFileBaseDir sets the base dir of file references for resolvers,
+// which are created afterwards. It's configured by the operator's
+// file-references-dir flag.
+func SetDefaultFileBaseDir(dir string) {
+	defaultFileBaseDir = dir
+}
+
+// SetFileBaseDir enables file references. Their paths are resolved relative
+// to dir and must not leave it. File references are disabled if dir is empty.
+func (r *Resolver) SetFileBaseDir(dir string) {
+	r.fileBaseDir = dir
+}
+
+// readFile returns the content of the file at path below the base dir
+func (r *Resolver) readFile(path string, key string) (string, error) {
+	if r.fileBaseDir == "" {
+		return "", fmt.Errorf("failed to read %s from file '%s': file references are disabled", key, path)
+	}
+
+	base, err := filepath.EvalSymlinks(r.fileBaseDir)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve file reference base dir '%s'", r.fileBaseDir)
+	}
+	base, err = filepath.Abs(base)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve file reference base dir '%s'", r.fileBaseDir)
+	}
+
+	// resolve symlinks, so they can't point outside of the base dir either
+	full, err := filepath.EvalSymlinks(filepath.Join(base, path))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %s from file '%s'", key, path)
+	}
+	full, err = filepath.Abs(full)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %s from file '%s'", key, path)
+	}
+
+	rel, err := filepath.Rel(base, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("failed to read %s from file '%s': path is outside of '%s'", key, path, r.fileBaseDir)
+	}
+
+	data, err := ioutil.ReadFile(full)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %s from file '%s'", key, path)
+	}
+
+	return string(data), nil
+}
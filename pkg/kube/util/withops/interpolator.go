@@ -3,8 +3,10 @@ package withops
 import (
 	"github.com/SUSE/go-patch/patch"
 	boshtpl "github.com/cloudfoundry/bosh-cli/director/template"
+	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
+	sigsyaml "sigs.k8s.io/yaml"
 )
 
 // Interpolator renders BOSH manifests by operations files
@@ -15,7 +17,13 @@ type Interpolator interface {
 
 // InterpolatorImpl applies desired changes from BOSH operations files to to BOSH manifest
 type InterpolatorImpl struct {
-	ops patch.Ops
+	steps []opsStep
+}
+
+// opsStep is either a list of go-patch ops or a RFC 6902 JSON patch
+type opsStep struct {
+	ops       patch.Ops
+	jsonPatch jsonpatch.Patch
 }
 
 // NewInterpolator constructs an interpolator
@@ -23,8 +31,23 @@ func NewInterpolator() *InterpolatorImpl {
 	return &InterpolatorImpl{}
 }
 
-// AddOps unmarshals ops definitions, processes them and holds them in memory
+// AddOps unmarshals ops definitions, processes them and holds them in memory.
+// Ops files can either use the go-patch format or be RFC 6902 JSON patches,
+// whose operations have an 'op' instead of a 'type' key.
 func (i *InterpolatorImpl) AddOps(opsBytes []byte) error {
+	if isJSONPatch(opsBytes) {
+		jsonBytes, err := sigsyaml.YAMLToJSON(opsBytes)
+		if err != nil {
+			return errors.Wrapf(err, "Converting JSON patch %s failed", string(opsBytes))
+		}
+		p, err := jsonpatch.DecodePatch(jsonBytes)
+		if err != nil {
+			return errors.Wrapf(err, "Decoding JSON patch %s failed", string(opsBytes))
+		}
+		i.steps = append(i.steps, opsStep{jsonPatch: p})
+		return nil
+	}
+
 	var opDefs []patch.OpDefinition
 	err := yaml.Unmarshal(opsBytes, &opDefs)
 	if err != nil {
@@ -35,12 +58,60 @@ func (i *InterpolatorImpl) AddOps(opsBytes []byte) error {
 	if err != nil {
 		return errors.Wrapf(err, "Building ops from opDefs failed")
 	}
-	i.ops = append(i.ops, ops)
+
+	// Consecutive go-patch ops are evaluated together
+	if n := len(i.steps); n > 0 && i.steps[n-1].jsonPatch == nil {
+		i.steps[n-1].ops = append(i.steps[n-1].ops, ops)
+		return nil
+	}
+	i.steps = append(i.steps, opsStep{ops: patch.Ops{ops}})
 	return nil
 }
 
+// isJSONPatch returns true if all operations of the ops file have an 'op',
+// but no 'type' key
+func isJSONPatch(opsBytes []byte) bool {
+	var opDefs []map[string]interface{}
+	if err := yaml.Unmarshal(opsBytes, &opDefs); err != nil || len(opDefs) == 0 {
+		return false
+	}
+
+	for _, opDef := range opDefs {
+		_, hasOp := opDef["op"]
+		_, hasType := opDef["type"]
+		if !hasOp || hasType {
+			return false
+		}
+	}
+	return true
+}
+
 // Interpolate returns manifest which is rendered by operations files
 func (i *InterpolatorImpl) Interpolate(manifestBytes []byte) ([]byte, error) {
+	if len(i.steps) == 0 {
+		return evaluate(manifestBytes, patch.Ops{})
+	}
+
+	var err error
+	for n, step := range i.steps {
+		if step.jsonPatch == nil {
+			manifestBytes, err = evaluate(manifestBytes, step.ops)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		manifestBytes, err = applyJSONPatch(manifestBytes, step.jsonPatch)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not apply JSON patch %d", n)
+		}
+	}
+	return manifestBytes, nil
+}
+
+// evaluate applies go-patch ops to the manifest
+func evaluate(manifestBytes []byte, ops patch.Ops) ([]byte, error) {
 	tpl := boshtpl.NewTemplate(manifestBytes)
 
 	// Following options are empty for quarks-operator
@@ -49,9 +120,24 @@ func (i *InterpolatorImpl) Interpolate(manifestBytes []byte) ([]byte, error) {
 		ExpectAllVarsUsed: false,
 	}
 
-	bytes, err := tpl.Evaluate(boshtpl.StaticVariables{}, i.ops, evalOpts)
+	bytes, err := tpl.Evaluate(boshtpl.StaticVariables{}, ops, evalOpts)
 	if err != nil {
 		return nil, errors.Wrapf(err, "could not evaluate variables")
 	}
 	return bytes, nil
 }
+
+// applyJSONPatch applies a JSON patch to the yaml manifest
+func applyJSONPatch(manifestBytes []byte, p jsonpatch.Patch) ([]byte, error) {
+	jsonBytes, err := sigsyaml.YAMLToJSON(manifestBytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to convert manifest to JSON")
+	}
+
+	jsonBytes, err = p.Apply(jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return sigsyaml.JSONToYAML(jsonBytes)
+}
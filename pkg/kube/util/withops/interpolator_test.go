@@ -170,6 +170,61 @@ new_key: 1234abcd
 			Expect(err.Error()).To(ContainSubstring("Expected to find a map key 'missing_key'"))
 		})
 
+		It("appends variables, creating the list if needed", func() {
+			ops = []byte(`
+- type: replace
+  path: /variables?/-
+  value:
+    name: router_ca
+    type: certificate
+    options:
+      is_ca: true
+      common_name: ((system_domain))
+`)
+			expectedManifest = []byte(`
+name: my-deployment
+director_uuid: 1234abcd
+dns:
+- 192.168.0.1
+- 192.168.0.2
+instance_groups:
+  - name: diego
+    instances: 3
+  - name: mysql
+    instances: 2
+variables:
+- name: router_ca
+  type: certificate
+  options:
+    is_ca: true
+    common_name: ((system_domain))
+`)
+
+			err := interpolator.AddOps(ops)
+			Expect(err).ToNot(HaveOccurred())
+
+			result, err := interpolator.Interpolate(baseManifest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(MatchYAML(expectedManifest))
+		})
+
+		It("throws an error if appending to a missing variables list", func() {
+			ops = []byte(`
+- type: replace
+  path: /variables/-
+  value:
+    name: router_ca
+    type: certificate
+`)
+
+			err := interpolator.AddOps(ops)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = interpolator.Interpolate(baseManifest)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Expected to find a map key 'variables'"))
+		})
+
 		//Teste for Array
 		It("works for setting an item", func() {
 			ops = []byte(`
@@ -588,4 +643,202 @@ This is a wrong ops format file
 			Expect(err.Error()).To(ContainSubstring("found character that cannot start any token"))
 		})
 	})
+
+	Describe("JSON patches", func() {
+		BeforeEach(func() {
+			baseManifest = []byte(`
+name: my-deployment
+director_uuid: 1234abcd
+instance_groups:
+  - name: diego
+    instances: 3
+  - name: mysql
+    instances: 2
+`)
+		})
+
+		It("works for adding, removing and replacing", func() {
+			ops = []byte(`
+- op: add
+  path: /instance_groups/-
+  value:
+    name: nats
+    instances: 1
+- op: remove
+  path: /director_uuid
+- op: replace
+  path: /instance_groups/0/instances
+  value: 4
+`)
+			expectedManifest = []byte(`
+name: my-deployment
+instance_groups:
+  - name: diego
+    instances: 4
+  - name: mysql
+    instances: 2
+  - name: nats
+    instances: 1
+`)
+
+			err := interpolator.AddOps(ops)
+			Expect(err).ToNot(HaveOccurred())
+
+			result, err := interpolator.Interpolate(baseManifest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(MatchYAML(expectedManifest))
+		})
+
+		It("accepts JSON patches in JSON syntax", func() {
+			ops = []byte(`[{"op": "add", "path": "/update", "value": {"canaries": 1}}]`)
+			expectedManifest = []byte(`
+name: my-deployment
+director_uuid: 1234abcd
+instance_groups:
+  - name: diego
+    instances: 3
+  - name: mysql
+    instances: 2
+update:
+  canaries: 1
+`)
+
+			err := interpolator.AddOps(ops)
+			Expect(err).ToNot(HaveOccurred())
+
+			result, err := interpolator.Interpolate(baseManifest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(MatchYAML(expectedManifest))
+		})
+
+		It("applies mixed go-patch and JSON patch ops files in order", func() {
+			ops1 := []byte(`
+- type: replace
+  path: /instance_groups/name=diego/instances
+  value: 5
+`)
+			ops2 := []byte(`
+- op: replace
+  path: /instance_groups/0/name
+  value: cell
+`)
+			ops3 := []byte(`
+- type: remove
+  path: /instance_groups/name=mysql
+`)
+			expectedManifest = []byte(`
+name: my-deployment
+director_uuid: 1234abcd
+instance_groups:
+  - name: cell
+    instances: 5
+`)
+
+			for _, o := range [][]byte{ops1, ops2, ops3} {
+				err := interpolator.AddOps(o)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			result, err := interpolator.Interpolate(baseManifest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(MatchYAML(expectedManifest))
+		})
+
+		It("keeps variable placeholders", func() {
+			ops = []byte(`
+- op: add
+  path: /instance_groups/0/properties
+  value:
+    password: ((password))
+`)
+
+			err := interpolator.AddOps(ops)
+			Expect(err).ToNot(HaveOccurred())
+
+			result, err := interpolator.Interpolate(baseManifest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(result)).To(ContainSubstring("password: ((password))"))
+		})
+
+		It("throws an error if applying the JSON patch fails", func() {
+			ops = []byte(`
+- op: remove
+  path: /missing
+`)
+
+			err := interpolator.AddOps(ops)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = interpolator.Interpolate(baseManifest)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("could not apply JSON patch 0"))
+		})
+	})
+
+	Describe("TracingInterpolator", func() {
+		var tracing *ipl.TracingInterpolator
+
+		BeforeEach(func() {
+			tracing = ipl.NewTracingInterpolator(ipl.NewInterpolator())
+			baseManifest = []byte(`
+name: my-deployment
+director_uuid: 1234abcd
+instance_groups:
+  - name: diego
+    instances: 3
+`)
+		})
+
+		It("records the operations of each ops file after interpolating", func() {
+			err := tracing.AddOps([]byte(`
+- type: replace
+  path: /instance_groups/name=diego/instances
+  value: 4
+- type: remove
+  path: /director_uuid
+`))
+			Expect(err).ToNot(HaveOccurred())
+			err = tracing.AddOps([]byte(`
+- op: add
+  path: /update
+  value: {}
+`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tracing.Trace()).To(BeEmpty())
+
+			_, err = tracing.Interpolate(baseManifest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tracing.Trace()).To(Equal([]ipl.OpTrace{
+				{OpsFile: 0, Operation: "replace", Path: "/instance_groups/name=diego/instances"},
+				{OpsFile: 0, Operation: "remove", Path: "/director_uuid"},
+				{OpsFile: 1, Operation: "add", Path: "/update"},
+			}))
+			Expect(tracing.Trace()[0].String()).To(Equal("replace /instance_groups/name=diego/instances"))
+		})
+
+		It("doesn't record ops files, which fail to be added", func() {
+			err := tracing.AddOps([]byte(`
+- type: invalid-ops
+  path: /name
+`))
+			Expect(err).To(HaveOccurred())
+
+			_, err = tracing.Interpolate(baseManifest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tracing.Trace()).To(BeEmpty())
+		})
+
+		It("doesn't update the trace if interpolating fails", func() {
+			err := tracing.AddOps([]byte(`
+- type: replace
+  path: /missing/key
+  value: 1
+`))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = tracing.Interpolate(baseManifest)
+			Expect(err).To(HaveOccurred())
+			Expect(tracing.Trace()).To(BeEmpty())
+		})
+	})
 })
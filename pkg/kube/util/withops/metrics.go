@@ -0,0 +1,49 @@
+package withops
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Metrics receives measurements of the resolver, e.g. to export them as
+// Prometheus metrics. Implementations have to be safe for concurrent use, as
+// secrets are fetched in parallel.
+type Metrics interface {
+	// ObserveResolve records the duration of resolving a manifest. Method is
+	// 'Manifest' or 'ManifestDetailed'.
+	ObserveResolve(method string, duration time.Duration)
+	// AddOpsApplied counts the ops files applied to manifests
+	AddOpsApplied(n int)
+	// IncSecretFetches counts the secrets fetched from the API server
+	IncSecretFetches()
+}
+
+// noopMetrics discards all measurements
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveResolve(string, time.Duration) {}
+func (noopMetrics) AddOpsApplied(int)                    {}
+func (noopMetrics) IncSecretFetches()                    {}
+
+// SetMetrics sets the receiver of the resolver's measurements. Passing nil
+// disables them again.
+func (r *Resolver) SetMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+	r.metrics = m
+}
+
+// observeResolve records the time since start for the method
+func (r *Resolver) observeResolve(method string, start time.Time) {
+	r.metrics.ObserveResolve(method, time.Since(start))
+}
+
+// getSecret gets a secret and counts the fetch
+func (r *Resolver) getSecret(ctx context.Context, key types.NamespacedName, secret *corev1.Secret) error {
+	r.metrics.IncSecretFetches()
+	return r.client.Get(ctx, key, secret)
+}
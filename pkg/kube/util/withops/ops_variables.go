@@ -0,0 +1,181 @@
+package withops
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/SUSE/go-patch/patch"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	bdm "code.cloudfoundry.org/quarks-operator/pkg/bosh/manifest"
+	bdv1 "code.cloudfoundry.org/quarks-operator/pkg/kube/apis/boshdeployment/v1alpha1"
+	"code.cloudfoundry.org/quarks-operator/pkg/kube/util/names"
+	boshtpl "github.com/cloudfoundry/bosh-cli/director/template"
+)
+
+// WithOpsVariables makes the resolver interpolate variables in the contents
+// of ops files, before they are applied. This allows ops files to inject
+// secret values at paths, which don't exist in the manifest yet. Implicit
+// variables are read from their secrets. Explicit variables are read from
+// the secrets generated for them, if these exist already, otherwise they
+// are interpolated later with the rest of the manifest.
+func WithOpsVariables() ResolverOption {
+	return func(r *Resolver) {
+		r.opsVariables = true
+	}
+}
+
+// opsVariablesManifest returns the explicit variables of the manifest text,
+// which are needed to interpolate variables in ops files. It returns nil, if
+// variables in ops files are not interpolated.
+func (r *Resolver) opsVariablesManifest(m string, ops []bdv1.ResourceReference) (*bdm.Manifest, error) {
+	if !r.opsVariables || len(ops) == 0 {
+		return nil, nil
+	}
+	return explicitVariablesOf(m)
+}
+
+// explicitVariablesOf returns a manifest, which only contains the explicit
+// variables of the manifest text. The manifest text may still contain
+// placeholders, which would fail to load as a whole.
+func explicitVariablesOf(m string) (*bdm.Manifest, error) {
+	vars := struct {
+		Variables []bdm.Variable `json:"variables,omitempty"`
+	}{}
+	if err := yaml.Unmarshal([]byte(m), &vars); err != nil {
+		return nil, errors.Wrapf(err, "failed to read explicit variables of manifest")
+	}
+	return &bdm.Manifest{Variables: vars.Variables}, nil
+}
+
+// OpsVariableSecrets returns the sorted names of the secrets, which are
+// referenced by variables in the contents of the bdpl's ops files. These are
+// read when ops variables are interpolated, see WithOpsVariables.
+func (r *Resolver) OpsVariableSecrets(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string) ([]string, error) {
+	cache := resourceCache{}
+	m, err := r.resourceData(ctx, cache, namespace, bdpl.Spec.Manifest, bdv1.ManifestSpecName)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := matchingOps(ctx, bdpl, m)
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) == 0 {
+		return []string{}, nil
+	}
+	manifest, err := explicitVariablesOf(m)
+	if err != nil {
+		return nil, err
+	}
+
+	secNames := map[string]bool{}
+	for _, op := range ops {
+		opsData, err := r.resourceData(ctx, cache, namespace, op, bdv1.OpsSpecName)
+		if err != nil {
+			return nil, err
+		}
+		implicitNames, explicitNames := opsVariableNames(manifest, opsData)
+		secRefs, err := implicitSecretRefs(implicitNames)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse implicit variable names of ops file '%s'", op.Name)
+		}
+		for secName := range secRefs {
+			secNames[secName] = true
+		}
+		for _, name := range explicitNames {
+			secNames[names.SecretVariableName(name)] = true
+		}
+	}
+
+	result := make([]string, 0, len(secNames))
+	for secName := range secNames {
+		result = append(result, secName)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// opsVariableNames returns the sorted names of the implicit and explicit
+// variables in the ops file contents. Variables are classified by the
+// explicit variables of the manifest.
+func opsVariableNames(manifest *bdm.Manifest, opsData string) ([]string, []string) {
+	implicit := map[string]bool{}
+	explicit := map[string]bool{}
+	for _, ref := range bdm.FindVariableReferences([]byte(opsData)) {
+		name := strings.TrimPrefix(ref, "!")
+		switch bdm.ClassifyVariable(manifest, name) {
+		case bdm.ExplicitVariable:
+			explicit[strings.SplitN(name, ".", 2)[0]] = true
+		case bdm.ImplicitVariable:
+			if !bdm.SlashedVariable(name) {
+				name = strings.SplitN(name, ".", 2)[0]
+			}
+			implicit[name] = true
+		}
+	}
+
+	implicitNames := make([]string, 0, len(implicit))
+	for name := range implicit {
+		implicitNames = append(implicitNames, name)
+	}
+	sort.Strings(implicitNames)
+	explicitNames := make([]string, 0, len(explicit))
+	for name := range explicit {
+		explicitNames = append(explicitNames, name)
+	}
+	sort.Strings(explicitNames)
+	return implicitNames, explicitNames
+}
+
+// interpolateOpsVariables interpolates the variables in the ops file
+// contents. Variables are classified by the explicit variables of the
+// manifest.
+func (r *Resolver) interpolateOpsVariables(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string, manifest *bdm.Manifest, op bdv1.ResourceReference, opsData string) (string, error) {
+	implicitNames, explicitNames := opsVariableNames(manifest, opsData)
+	if len(implicitNames) == 0 && len(explicitNames) == 0 {
+		return opsData, nil
+	}
+
+	secRefs, err := implicitSecretRefs(implicitNames)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse implicit variable names of ops file '%s'", op.Name)
+	}
+	vars, err := r.implicitVariableValues(ctx, namespace, secRefs)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range explicitNames {
+		secName := names.SecretVariableName(name)
+		secret := &corev1.Secret{}
+		err := r.getSecret(ctx, types.NamespacedName{Name: secName, Namespace: namespace}, secret)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to get secret '%s/%s'", namespace, secName)
+		}
+
+		value, err := explicitVariableValue(secret)
+		if err != nil {
+			return "", err
+		}
+		if value != nil {
+			vars[name] = value
+		}
+	}
+
+	tpl := boshtpl.NewTemplate([]byte(opsData))
+	evalOpts := boshtpl.EvaluateOpts{ExpectAllKeys: false, ExpectAllVarsUsed: false}
+	bytes, err := tpl.Evaluate(vars, patch.Ops{}, evalOpts)
+	if err != nil {
+		return "", newResolveError(ErrInterpolation, namespace, bdpl.Name, "", errors.Wrapf(err, "could not evaluate variables of ops file '%s'", op.Name))
+	}
+	return string(bytes), nil
+}
@@ -2,16 +2,20 @@
 package withops
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/SUSE/go-patch/patch"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -26,26 +30,87 @@ import (
 	boshtpl "github.com/cloudfoundry/bosh-cli/director/template"
 )
 
+// DefaultSecretFetchConcurrency is the default number of implicit variable
+// secrets, which are fetched in parallel
+const DefaultSecretFetchConcurrency = 8
+
+// DefaultManifestSizeWarnRatio is the default fraction of the secret size
+// limit, above which the resolver warns about the manifest's size
+const DefaultManifestSizeWarnRatio = 0.8
+
 // Resolver resolves references from bdpl CR to a BOSH manifest
 type Resolver struct {
-	client               client.Client
-	versionedSecretStore versionedsecretstore.VersionedSecretStore
-	newInterpolatorFunc  NewInterpolatorFunc
+	client                 client.Client
+	versionedSecretStore   versionedsecretstore.VersionedSecretStore
+	newInterpolatorFunc    NewInterpolatorFunc
+	secretFetchConcurrency int
+	urlAttempts            int
+	urlBackoff             time.Duration
+	urlTimeout             time.Duration
+	httpClient             HTTPClient
+	fileBaseDir            string
+	metrics                Metrics
+	transforms             []ManifestTransform
+	sizeWarnRatio          float64
+	opsVariables           bool
 }
 
 // NewInterpolatorFunc returns a fresh Interpolator
 type NewInterpolatorFunc func() Interpolator
 
+// ManifestTransform changes the manifest after ops files are applied, but
+// before variables are interpolated and addons are applied
+type ManifestTransform func(*bdm.Manifest) error
+
+// ResolverOption configures a resolver
+type ResolverOption func(*Resolver)
+
+// WithManifestTransform adds a transform to the resolver. Transforms run in
+// the order they are added. An error of a transform aborts the resolve.
+func WithManifestTransform(t ManifestTransform) ResolverOption {
+	return func(r *Resolver) {
+		r.transforms = append(r.transforms, t)
+	}
+}
+
 // NewResolver constructs a resolver
-func NewResolver(client client.Client, f NewInterpolatorFunc) *Resolver {
-	return &Resolver{
-		client:               client,
-		newInterpolatorFunc:  f,
-		versionedSecretStore: versionedsecretstore.NewVersionedSecretStore(client),
+func NewResolver(client client.Client, f NewInterpolatorFunc, opts ...ResolverOption) *Resolver {
+	r := &Resolver{
+		client:                 client,
+		newInterpolatorFunc:    f,
+		versionedSecretStore:   versionedsecretstore.NewVersionedSecretStore(client),
+		secretFetchConcurrency: DefaultSecretFetchConcurrency,
+		urlAttempts:            DefaultURLAttempts,
+		urlBackoff:             DefaultURLBackoff,
+		urlTimeout:             DefaultURLTimeout,
+		httpClient:             defaultHTTPClient,
+		fileBaseDir:            defaultFileBaseDir,
+		metrics:                noopMetrics{},
+		sizeWarnRatio:          DefaultManifestSizeWarnRatio,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// SetSecretFetchConcurrency sets the number of implicit variable secrets,
+// which are fetched in parallel
+func (r *Resolver) SetSecretFetchConcurrency(n int) {
+	if n < 1 {
+		n = 1
 	}
+	r.secretFetchConcurrency = n
 }
 
-func (r *Resolver) load(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string) (*bdm.Manifest, error) {
+// SetManifestSizeWarnRatio sets the fraction of the secret size limit, above
+// which the resolver warns about the size of the resolved manifest. A ratio
+// of zero or less disables the warning.
+func (r *Resolver) SetManifestSizeWarnRatio(ratio float64) {
+	r.sizeWarnRatio = ratio
+}
+
+func (r *Resolver) load(ctx context.Context, cache resourceCache, bdpl *bdv1.BOSHDeployment, namespace string) (*bdm.Manifest, error) {
 	var (
 		m            string
 		err          error
@@ -53,22 +118,35 @@ func (r *Resolver) load(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespac
 		spec         = bdpl.Spec
 	)
 
-	m, err = r.resourceData(ctx, namespace, spec.Manifest.Type, spec.Manifest.Name, bdv1.ManifestSpecName)
+	m, err = r.resourceData(ctx, cache, namespace, spec.Manifest, bdv1.ManifestSpecName)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Interpolation failed for bosh deployment '%s' in '%s'", bdpl.Name, namespace)
 	}
 
 	// Interpolate manifest with ops
-	ops := spec.Ops
+	ops, err := matchingOps(ctx, bdpl, m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Interpolation failed for bosh deployment '%s' in '%s'", bdpl.Name, namespace)
+	}
+	opsVars, err := r.opsVariablesManifest(m, ops)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Interpolation failed for bosh deployment '%s' in '%s'", bdpl.Name, namespace)
+	}
 
 	for _, op := range ops {
-		opsData, err := r.resourceData(ctx, namespace, op.Type, op.Name, bdv1.OpsSpecName)
+		opsData, err := r.resourceData(ctx, cache, namespace, op, bdv1.OpsSpecName)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Interpolation failed for bosh deployment '%s' in '%s'", bdpl.Name, namespace)
 		}
+		if opsVars != nil {
+			opsData, err = r.interpolateOpsVariables(ctx, bdpl, namespace, opsVars, op, opsData)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Interpolation failed for bosh deployment '%s' in '%s'", bdpl.Name, namespace)
+			}
+		}
 		err = interpolator.AddOps([]byte(opsData))
 		if err != nil {
-			return nil, errors.Wrapf(err, "Interpolation failed for bosh deployment '%s' in '%s'", bdpl.Name, namespace)
+			return nil, errors.Wrapf(err, "Interpolation failed for bosh deployment '%s' in '%s': ops file '%s' is invalid", bdpl.Name, namespace, op.Name)
 		}
 	}
 
@@ -76,31 +154,65 @@ func (r *Resolver) load(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespac
 	if len(ops) != 0 {
 		bytes, err = interpolator.Interpolate([]byte(m))
 		if err != nil {
-			return nil, errors.Wrapf(err, "Failed to interpolate %#v in interpolation task", m)
+			return nil, newResolveError(ErrInterpolation, namespace, bdpl.Name, "", errors.Wrapf(err, "Failed to interpolate %#v in interpolation task", m))
 		}
+		r.metrics.AddOpsApplied(len(ops))
 	}
 
 	manifest, err := bdm.LoadYAML(bytes)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Loading yaml failed in interpolation task after applying ops %#v", m)
 	}
+	if err := r.transform(manifest, bdpl, namespace); err != nil {
+		return nil, err
+	}
 	return manifest, nil
 }
 
+// transform runs the manifest transforms
+func (r *Resolver) transform(manifest *bdm.Manifest, bdpl *bdv1.BOSHDeployment, namespace string) error {
+	for i, t := range r.transforms {
+		if err := t(manifest); err != nil {
+			return errors.Wrapf(err, "manifest transform %d failed for bosh deployment '%s' in '%s'", i, bdpl.Name, namespace)
+		}
+	}
+	return nil
+}
+
 // Manifest returns manifest and a list of implicit variables referenced by our bdpl CRD
 // The resulting manifest has variables interpolated and ops files applied.
 // It is the 'with-ops' manifest.
 func (r *Resolver) Manifest(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string) (*bdm.Manifest, error) {
-	manifest, err := r.load(ctx, bdpl, namespace)
+	defer r.observeResolve("Manifest", time.Now())
+
+	manifest, err := r.load(ctx, resourceCache{}, bdpl, namespace)
 	if err != nil {
 		return nil, err
 	}
 	return r.applyVariables(ctx, bdpl, namespace, manifest, "manifest-addons")
 }
 
+// Render returns the yaml of the manifest, which Manifest resolves for the
+// bdpl, for previews. It only reads the referenced resources and has no
+// side effects on the cluster state. Addons and the update block are applied
+// to the returned copy only. The output is in canonical form, without
+// anchors.
+func (r *Resolver) Render(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string) ([]byte, error) {
+	manifest, err := r.Manifest(ctx, bdpl, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := manifest.MarshalCanonical()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal manifest of bosh deployment '%s' in '%s'", bdpl.Name, namespace)
+	}
+	return rendered, nil
+}
+
 // ImplicitVariables returns the implicit variables found in the manifest
 func (r *Resolver) ImplicitVariables(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string) ([]string, error) {
-	manifest, err := r.load(ctx, bdpl, namespace)
+	manifest, err := r.load(ctx, resourceCache{}, bdpl, namespace)
 	if err != nil {
 		return nil, err
 	}
@@ -125,67 +237,198 @@ func (r *Resolver) ImplicitVariables(ctx context.Context, bdpl *bdv1.BOSHDeploym
 	return varSecrets, nil
 }
 
+// MissingVariableSecrets returns the implicit variables, for which the secret
+// or the key in the secret doesn't exist, as listed by ResolveVariableRefs.
+func (r *Resolver) MissingVariableSecrets(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string) ([]string, error) {
+	manifest, err := r.load(ctx, resourceCache{}, bdpl, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	_, missing, err := r.ResolveVariableRefs(ctx, manifest, namespace)
+	return missing, err
+}
+
+// ExpectedSecretNames returns the sorted names of the variable secrets of the
+// bdpl. These are the secrets generated for explicit variables and the
+// secrets referenced by implicit variables.
+func (r *Resolver) ExpectedSecretNames(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string) ([]string, error) {
+	manifest, err := r.load(ctx, resourceCache{}, bdpl, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := buildSecretRefs(manifest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse all implicit variable names")
+	}
+
+	secNames := map[string]bool{}
+	for secName := range refs {
+		secNames[secName] = true
+	}
+	for _, v := range manifest.Variables {
+		secNames[names.SecretVariableName(v.Name)] = true
+	}
+
+	result := make([]string, 0, len(secNames))
+	for secName := range secNames {
+		result = append(result, secName)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
 // ManifestDetailed returns manifest and a list of implicit variables referenced by our bdpl CRD
 // The resulting manifest has variables interpolated and ops files applied.
 // It is the 'with-ops' manifest. This variant processes each ops file individually, so it's more debuggable - but slower.
 func (r *Resolver) ManifestDetailed(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string) (*bdm.Manifest, error) {
+	manifest, _, err := r.ManifestDetailedWithTrace(ctx, bdpl, namespace)
+	return manifest, err
+}
+
+// ManifestDetailedWithTrace works like ManifestDetailed, but also returns the
+// ordered list of applied ops files as 'type/name'. If an error occurs, the
+// trace lists the ops files which were applied successfully before.
+func (r *Resolver) ManifestDetailedWithTrace(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string) (*bdm.Manifest, []string, error) {
+	defer r.observeResolve("ManifestDetailed", time.Now())
+
+	m, bytes, trace, err := r.applyOpsDetailed(ctx, bdpl, namespace)
+	if err != nil {
+		return nil, trace, err
+	}
+
+	manifest, err := bdm.LoadYAML(bytes)
+	if err != nil {
+		return nil, trace, errors.Wrapf(err, "Loading yaml failed in interpolation task after applying ops %#v", m)
+	}
+	if err := r.transform(manifest, bdpl, namespace); err != nil {
+		return nil, trace, err
+	}
+
+	manifest, err = r.applyVariables(ctx, bdpl, namespace, manifest, "detailed-manifest-addons")
+	if err != nil {
+		return nil, trace, errors.Wrapf(err, "Loading yaml failed after applying variable: %#v", m)
+	}
+	return manifest, trace, nil
+}
+
+// ManifestWithComments returns the manifest text after applying the ops
+// files, for reviewing it. Variables and addons are not applied. Applying
+// ops files strips the comments, so they are only preserved if the bdpl has
+// no ops files.
+func (r *Resolver) ManifestWithComments(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string) (string, error) {
+	_, bytes, _, err := r.applyOpsDetailed(ctx, bdpl, namespace)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// applyOpsDetailed applies the ops files one by one. It returns the original
+// manifest, the result and the trace of applied ops files.
+func (r *Resolver) applyOpsDetailed(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string) (string, []byte, []string, error) {
 	var (
-		m    string
-		err  error
-		spec = bdpl.Spec
+		m     string
+		err   error
+		spec  = bdpl.Spec
+		cache = resourceCache{}
+		trace = []string{}
 	)
 
-	m, err = r.resourceData(ctx, namespace, spec.Manifest.Type, spec.Manifest.Name, bdv1.ManifestSpecName)
+	m, err = r.resourceData(ctx, cache, namespace, spec.Manifest, bdv1.ManifestSpecName)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Interpolation failed for bosh deployment %s", namespace)
+		return m, nil, trace, errors.Wrapf(err, "Interpolation failed for bosh deployment %s", namespace)
 	}
 
 	// Interpolate manifest with ops
-	ops := spec.Ops
+	ops, err := matchingOps(ctx, bdpl, m)
+	if err != nil {
+		return m, nil, trace, errors.Wrapf(err, "Interpolation failed for bosh deployment '%s' in '%s'", bdpl.Name, namespace)
+	}
+	opsVars, err := r.opsVariablesManifest(m, ops)
+	if err != nil {
+		return m, nil, trace, errors.Wrapf(err, "Interpolation failed for bosh deployment '%s' in '%s'", bdpl.Name, namespace)
+	}
 	bytes := []byte(m)
+	guard := opsGuard{log: ctxlog.ExtractLogger(ctx)}
 
 	for _, op := range ops {
 		interpolator := r.newInterpolatorFunc()
 
-		opsData, err := r.resourceData(ctx, namespace, op.Type, op.Name, bdv1.OpsSpecName)
+		opsData, err := r.resourceData(ctx, cache, namespace, op, bdv1.OpsSpecName)
 		if err != nil {
-			return nil, errors.Wrapf(err, "Failed to get resource data for interpolation of bosh deployment '%s' and ops '%s' in '%s'", bdpl.Name, op.Name, namespace)
+			return m, nil, trace, errors.Wrapf(err, "Failed to get resource data for interpolation of bosh deployment '%s' and ops '%s' in '%s'", bdpl.Name, op.Name, namespace)
+		}
+		if opsVars != nil {
+			opsData, err = r.interpolateOpsVariables(ctx, bdpl, namespace, opsVars, op, opsData)
+			if err != nil {
+				return m, nil, trace, err
+			}
 		}
 		err = interpolator.AddOps([]byte(opsData))
 		if err != nil {
-			return nil, errors.Wrapf(err, "Interpolation failed for bosh deployment '%s' and ops '%s' in '%s'", bdpl.Name, op.Name, namespace)
+			return m, nil, trace, errors.Wrapf(err, "ops file '%s' is invalid", op.Name)
 		}
 
+		previous := bytes
 		bytes, err = interpolator.Interpolate(bytes)
 		if err != nil {
-			return nil, errors.Wrapf(err, "Failed to interpolate ops '%s' for manifest '%s' in '%s'", op.Name, bdpl.Name, namespace)
+			return m, nil, trace, newResolveError(ErrInterpolation, namespace, bdpl.Name, "", errors.Wrapf(err, "ops file '%s' could not be applied (path not found?)", op.Name))
 		}
+		guard.check(op.Name, previous, bytes)
+		trace = append(trace, op.Type+"/"+op.Name)
+		r.metrics.AddOpsApplied(1)
 	}
 
-	manifest, err := bdm.LoadYAML(bytes)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Loading yaml failed in interpolation task after applying ops %#v", m)
+	return m, bytes, trace, nil
+}
+
+const (
+	// opsNoopWarnThreshold is the number of consecutive ops files without any effect, which triggers a warning
+	opsNoopWarnThreshold = 2
+	// opsGrowthWarnFactor is the factor by which a single ops file may grow the manifest, before a warning is logged
+	opsGrowthWarnFactor = 4
+)
+
+// opsGuard warns about suspicious ops files, which have no effect or let the
+// manifest grow unexpectedly. This can be a hint for ops files which undo
+// each others changes.
+type opsGuard struct {
+	log   *zap.SugaredLogger
+	noops int
+}
+
+func (g *opsGuard) check(opName string, before []byte, after []byte) {
+	if bytes.Equal(before, after) {
+		g.noops++
+		if g.noops >= opsNoopWarnThreshold {
+			g.log.Warnf("Ops file '%s' is the %d. consecutive ops file, which didn't change the manifest", opName, g.noops)
+		}
+	} else {
+		g.noops = 0
 	}
 
-	manifest, err = r.applyVariables(ctx, bdpl, namespace, manifest, "detailed-manifest-addons")
-	if err != nil {
-		return nil, errors.Wrapf(err, "Loading yaml failed after applying variable: %#v", m)
+	if len(before) > 0 && len(after) > opsGrowthWarnFactor*len(before) {
+		g.log.Warnf("Ops file '%s' grew the manifest from %d to %d bytes", opName, len(before), len(after))
 	}
-	return manifest, nil
 }
 
 type secretInfo struct {
 	key      string
 	variable string
+	// path navigates into the JSON value of the key, e.g. 'c' for 'a/b/c'
+	path []string
 }
 
 // secretRefs references secrets and keys. It also stores the original variable usage (name/key).
 // If the variable has no slash the default key is 'value', so 'name/value' is identical to just 'name'.
 type secretRefs map[string][]secretInfo
 
-func (s secretRefs) add(variable string, secName string, key string) {
+func (s secretRefs) add(variable string, secName string, key string, path []string) {
 	si := s[secName]
-	si = append(si, secretInfo{variable: variable, key: key})
+	si = append(si, secretInfo{variable: variable, key: key, path: path})
 	s[secName] = si
 }
 
@@ -196,25 +439,33 @@ func buildSecretRefs(manifest *bdm.Manifest) (secretRefs, error) {
 		return nil, errors.Wrapf(err, "failed to list implicit variables")
 	}
 
+	return implicitSecretRefs(vars)
+}
+
+// implicitSecretRefs indexes the implicit variables by secret name
+func implicitSecretRefs(vars []string) (secretRefs, error) {
 	refs := make(secretRefs, len(vars))
 	for _, v := range vars {
 		key := ""
 		secName := ""
-		// implicit variables can have a slash to specify the key in the secret
+		var path []string
+		// implicit variables can have a slash to specify the key in the
+		// secret, further segments navigate into its JSON value
 		if bdm.SlashedVariable(v) {
 			parts := strings.Split(v, "/")
-			if len(parts) != 2 {
-				return refs, fmt.Errorf("expected one / separator for implicit variable/key name, have %d", len(parts))
+			if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+				return refs, fmt.Errorf("expected implicit variable name of the form name/key[/path], have '%s'", v)
 			}
 
 			secName = names.SecretVariableName(parts[0])
 			key = parts[1]
+			path = parts[2:]
 		} else {
 			secName = names.SecretVariableName(v)
 			key = bdv1.ImplicitVariableKeyName
 		}
 
-		refs.add(v, secName, key)
+		refs.add(v, secName, key, path)
 	}
 	return refs, nil
 }
@@ -226,144 +477,453 @@ func (r *Resolver) applyVariables(ctx context.Context, bdpl *bdv1.BOSHDeployment
 		return nil, errors.Wrapf(err, "failed to parse all implicit variable names")
 	}
 
-	// fetch each secret for implicit variables
-	impVars := boshtpl.StaticVariables{}
-	for secName, infos := range refs {
-		secret := &corev1.Secret{}
-		err := r.client.Get(ctx, types.NamespacedName{Name: secName, Namespace: namespace}, secret)
+	impVars, err := r.implicitVariableValues(ctx, namespace, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Interpolate variables, without implicit variables the manifest stays
+	// the same
+	if len(impVars) > 0 {
+		boshManifestBytes, err := manifest.Marshal()
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to get secret '%s/%s'", namespace, secName)
+			return nil, errors.Wrapf(err, "failed to marshal manifest")
+		}
+		tpl := boshtpl.NewTemplate(boshManifestBytes)
+		evalOpts := boshtpl.EvaluateOpts{ExpectAllKeys: false, ExpectAllVarsUsed: false}
+		yamlBytes, err := tpl.Evaluate(impVars, patch.Ops{}, evalOpts)
+		if err != nil {
+			return nil, newResolveError(ErrInterpolation, namespace, bdpl.Name, "", errors.Wrapf(err, "could not evaluate variables"))
 		}
 
-		for _, info := range infos {
-			val, ok := secret.Data[info.key]
-			if !ok {
-				return nil, fmt.Errorf("secret '%s/%s' doesn't contain key '%s' for variable '%s'", namespace, secName, info.key, info.variable)
-			}
-
-			if t, ok := secret.Annotations[bdv1.AnnotationJSONValue]; ok && t == "true" {
-				var js interface{}
-				err := json.Unmarshal(val, &js)
-				if err != nil {
-					return nil, errors.Wrapf(err, "failed to unmarshal JSON in '%s' from secret '%s/%s'", info.variable, namespace, secName)
-				}
-				impVars[info.variable] = js
-			} else {
-				impVars[info.variable] = string(val)
-			}
-
+		manifest, err = bdm.LoadYAML(yamlBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load manifest with evaluated variables")
 		}
 	}
 
-	// Interpolate variables
-	boshManifestBytes, _ := manifest.Marshal()
+	// Apply addons
+	log := ctxlog.ExtractLogger(ctx)
+	err = manifest.ApplyAddons(logger.TraceFilter(log, logName))
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to marshal manifest")
+		return nil, errors.Wrapf(err, "failed to apply addons")
 	}
-	tpl := boshtpl.NewTemplate(boshManifestBytes)
-	evalOpts := boshtpl.EvaluateOpts{ExpectAllKeys: false, ExpectAllVarsUsed: false}
-	yamlBytes, err := tpl.Evaluate(impVars, patch.Ops{}, evalOpts)
+
+	manifest, marshalled, err := r.applyUserVariables(ctx, bdpl, namespace, manifest)
 	if err != nil {
-		return nil, errors.Wrapf(err, "could not evaluate variables")
+		return nil, err
 	}
 
-	manifest, err = bdm.LoadYAML(yamlBytes)
+	err = boshdns.Validate(*manifest)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to load manifest with evaluated variables")
+		return nil, err
 	}
+	_ = manifest.ValidateSerial(log, false)
+	manifest.ApplyUpdateBlock()
+	r.checkSize(log, manifest, marshalled, bdpl)
 
-	// Apply addons
-	log := ctxlog.ExtractLogger(ctx)
-	err = manifest.ApplyAddons(logger.TraceFilter(log, logName))
+	return manifest, err
+}
+
+// implicitVariableValues fetches the secrets of the implicit variables and
+// returns their values
+func (r *Resolver) implicitVariableValues(ctx context.Context, namespace string, refs secretRefs) (boshtpl.StaticVariables, error) {
+	secNames := make([]string, 0, len(refs))
+	for secName := range refs {
+		secNames = append(secNames, secName)
+	}
+	sort.Strings(secNames)
+
+	secrets, err := r.fetchSecrets(ctx, namespace, secNames)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to apply addons")
+		return nil, err
+	}
+
+	impVars := boshtpl.StaticVariables{}
+	for i, secName := range secNames {
+		for _, info := range refs[secName] {
+			impVars[info.variable], err = secretVariableValue(secrets[i], info)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return impVars, nil
+}
+
+// checkSize warns if the manifest approaches the secret size limit, as
+// storing the desired manifest would fail. If the manifest was already
+// marshalled with manifest.Marshal while resolving it, the size of those
+// bytes is used instead of marshalling it again.
+func (r *Resolver) checkSize(log *zap.SugaredLogger, manifest *bdm.Manifest, marshalled []byte, bdpl *bdv1.BOSHDeployment) {
+	if r.sizeWarnRatio <= 0 {
+		return
+	}
+
+	size := len(marshalled)
+	if marshalled == nil {
+		var err error
+		size, err = manifest.EstimateStoredSize()
+		if err != nil {
+			log.Debugf("Failed to estimate the size of the manifest of bosh deployment '%s': %v", bdpl.Name, err)
+			return
+		}
+	}
+	if float64(size) > r.sizeWarnRatio*bdm.MaxSecretSize {
+		log.Warnf("Manifest of bosh deployment '%s' has %d bytes, which is close to the secret size limit of %d bytes. Consider storing it compressed.", bdpl.Name, size, bdm.MaxSecretSize)
+	}
+}
+
+// applyUserVariables interpolates the user-provided explicit variables. If
+// the bdpl has none, the interpolation is skipped. The manifest is still
+// reloaded if addons were applied, so addon jobs don't share their
+// properties. Reloading keeps the in-memory addons flag. It also returns the
+// bytes of manifest.Marshal it reloaded, or nil if it didn't reload them
+// as they are.
+func (r *Resolver) applyUserVariables(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string, manifest *bdm.Manifest) (*bdm.Manifest, []byte, error) {
+	if len(bdpl.Spec.Vars) == 0 && len(manifest.AddOns) == 0 {
+		return manifest, nil, nil
 	}
+	addonsApplied := manifest.AddonsApplied()
 
-	// Interpolate user-provided explicit variables
 	bytes, err := manifest.Marshal()
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to marshal bdpl '%s/%s' after applying addons", bdpl.Namespace, bdpl.Name)
+		return nil, nil, errors.Wrapf(err, "failed to marshal bdpl '%s/%s' after applying addons", bdpl.Namespace, bdpl.Name)
+	}
+
+	if len(bdpl.Spec.Vars) == 0 {
+		manifest, err = bdm.LoadYAML(bytes)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to reload manifest after applying addons")
+		}
+		manifest.AddOnsApplied = addonsApplied
+		return manifest, bytes, nil
 	}
 
 	var userVars []boshtpl.Variables
 	for _, userVar := range bdpl.Spec.Vars {
 		varName := userVar.Name
 		varSecretName := userVar.Secret
+		if err := ctx.Err(); err != nil {
+			return nil, nil, errors.Wrapf(err, "aborted fetching secret '%s/%s'", namespace, varSecretName)
+		}
 		secret := &corev1.Secret{}
-		err := r.client.Get(ctx, types.NamespacedName{Name: varSecretName, Namespace: namespace}, secret)
+		err := r.getSecret(ctx, types.NamespacedName{Name: varSecretName, Namespace: namespace}, secret)
+		if err != nil {
+			return nil, nil, secretGetError(err, namespace, varSecretName, errors.Wrapf(err, "failed to retrieve secret '%s/%s' via client.Get", namespace, varSecretName))
+		}
+		value, err := explicitVariableValue(secret)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to retrieve secret '%s/%s' via client.Get", namespace, varSecretName)
+			return nil, nil, err
 		}
 		staticVars := boshtpl.StaticVariables{}
-		for key, varBytes := range secret.Data {
-			switch key {
-			case "password":
-				staticVars[varName] = string(varBytes)
-			default:
-				staticVars[varName] = MergeStaticVar(staticVars[varName], key, string(varBytes))
-			}
+		if value != nil {
+			staticVars[varName] = value
 		}
 		userVars = append(userVars, staticVars)
 	}
 
 	bytes, err = InterpolateExplicitVariables(bytes, userVars, false)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to interpolate user provided explicit variables manifest '%s' in '%s'", bdpl.Name, namespace)
+		return nil, nil, newResolveError(ErrInterpolation, namespace, bdpl.Name, "", errors.Wrapf(err, "Failed to interpolate user provided explicit variables manifest '%s' in '%s'", bdpl.Name, namespace))
 	}
 
 	manifest, err = bdm.LoadYAML(bytes)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Loading yaml failed in interpolation task after applying user explicit vars")
+		return nil, nil, errors.Wrapf(err, "Loading yaml failed in interpolation task after applying user explicit vars")
 	}
+	manifest.AddOnsApplied = addonsApplied
 
-	err = boshdns.Validate(*manifest)
+	// The interpolated bytes differ from what Marshal stores, e.g. anchors
+	// are expanded, so they can't be used to check the size.
+	return manifest, nil, nil
+}
+
+// ResolveVariableRefs resolves the implicit variables of the manifest. It
+// returns the values it could resolve and the missing secrets and keys.
+// Missing secrets are listed by name, missing keys as 'secret/key'. Values,
+// which can't be resolved by their JSON path, e.g. because a nested key is
+// missing or the secret isn't JSON, are listed as 'secret/key/path'. Only
+// unexpected API errors are returned as an error.
+func (r *Resolver) ResolveVariableRefs(ctx context.Context, manifest *bdm.Manifest, namespace string) (boshtpl.StaticVariables, []string, error) {
+	refs, err := buildSecretRefs(manifest)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to parse all implicit variable names")
+	}
+
+	secNames := make([]string, 0, len(refs))
+	for secName := range refs {
+		secNames = append(secNames, secName)
+	}
+	sort.Strings(secNames)
+
+	secrets, err := r.fetchExistingSecrets(ctx, namespace, secNames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resolved := boshtpl.StaticVariables{}
+	missing := []string{}
+	for i, secName := range secNames {
+		secret := secrets[i]
+		if secret == nil {
+			missing = append(missing, secName)
+			continue
+		}
+
+		missingKeys := map[string]bool{}
+		for _, info := range refs[secName] {
+			val, ok := secret.Data[info.key]
+			if !ok {
+				if cert, ok := certificateValue(secret, info); ok {
+					resolved[info.variable] = cert
+					continue
+				}
+				if !missingKeys[info.key] {
+					missingKeys[info.key] = true
+					missing = append(missing, secName+"/"+info.key)
+				}
+				continue
+			}
+
+			value, err := variableValue(secret, info, val)
+			if err != nil {
+				ref := strings.Join(append([]string{secName, info.key}, info.path...), "/")
+				if !missingKeys[ref] {
+					missingKeys[ref] = true
+					missing = append(missing, ref)
+				}
+				continue
+			}
+			resolved[info.variable] = value
+		}
+	}
+
+	return resolved, missing, nil
+}
+
+// ResolveVariable returns the value, which would be substituted for the
+// implicit variable varName of the bdpl. It's meant for debugging single
+// variables and resolves them like Manifest does.
+func (r *Resolver) ResolveVariable(ctx context.Context, bdpl *bdv1.BOSHDeployment, namespace string, varName string) (interface{}, error) {
+	manifest, err := r.load(ctx, resourceCache{}, bdpl, namespace)
 	if err != nil {
 		return nil, err
 	}
-	manifest.ApplyUpdateBlock()
 
-	return manifest, err
+	refs, err := buildSecretRefs(manifest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse all implicit variable names")
+	}
+
+	for secName, infos := range refs {
+		for _, info := range infos {
+			if info.variable != varName {
+				continue
+			}
+
+			secrets, err := r.fetchSecrets(ctx, namespace, []string{secName})
+			if err != nil {
+				return nil, err
+			}
+			return secretVariableValue(secrets[0], info)
+		}
+	}
+
+	return nil, fmt.Errorf("variable '%s' is not an implicit variable of bosh deployment '%s' in '%s'", varName, bdpl.Name, namespace)
+}
+
+// secretVariableValue returns the value of an implicit variable from its
+// secret
+func secretVariableValue(secret *corev1.Secret, info secretInfo) (interface{}, error) {
+	val, ok := secret.Data[info.key]
+	if !ok {
+		if cert, ok := certificateValue(secret, info); ok {
+			return cert, nil
+		}
+		return nil, newResolveError(ErrSecretKeyMissing, secret.Namespace, secret.Name, info.key,
+			fmt.Errorf("secret '%s/%s' doesn't contain key '%s' for variable '%s'", secret.Namespace, secret.Name, info.key, info.variable))
+	}
+
+	return variableValue(secret, info, val)
+}
+
+// certificateKeys are the keys of a certificate secret, which are available
+// as fields of the implicit variable
+var certificateKeys = []string{"certificate", "private_key", "ca"}
+
+// certificateValue returns the keys of a certificate secret as a map, if the
+// implicit variable doesn't select a key. This allows using the whole
+// certificate as an object, e.g. '((ssl))' or '((ssl.certificate))', while
+// '((ssl/certificate))' still only reads a single key.
+func certificateValue(secret *corev1.Secret, info secretInfo) (map[interface{}]interface{}, bool) {
+	if bdm.SlashedVariable(info.variable) {
+		return nil, false
+	}
+	if _, ok := secret.Data["certificate"]; !ok {
+		return nil, false
+	}
+
+	cert := map[interface{}]interface{}{}
+	for _, key := range certificateKeys {
+		if val, ok := secret.Data[key]; ok {
+			cert[key] = string(val)
+		}
+	}
+	return cert, true
+}
+
+// variableValue returns the value of the implicit variable from the secret's
+// data. JSON values are decoded and navigated by the variable's path.
+func variableValue(secret *corev1.Secret, info secretInfo, val []byte) (interface{}, error) {
+	if secret.Annotations[bdv1.AnnotationJSONValue] != "true" {
+		if len(info.path) > 0 {
+			return nil, fmt.Errorf("secret '%s/%s' is not annotated as JSON, can't resolve nested variable '%s'", secret.Namespace, secret.Name, info.variable)
+		}
+		return string(val), nil
+	}
+
+	var js interface{}
+	err := json.Unmarshal(val, &js)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal JSON in '%s' from secret '%s/%s'", info.variable, secret.Namespace, secret.Name)
+	}
+	js, err = jsonPath(js, info.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve '%s' from secret '%s/%s'", info.variable, secret.Namespace, secret.Name)
+	}
+	// the template engine only navigates dotted sub keys of interface maps
+	return interfaceMaps(js), nil
+}
+
+// fetchSecrets gets the secrets in parallel, but limits the number of
+// concurrent requests. The result has the same order as secNames.
+func (r *Resolver) fetchSecrets(ctx context.Context, namespace string, secNames []string) ([]*corev1.Secret, error) {
+	return r.fetchSecretList(ctx, namespace, secNames, false)
+}
+
+// fetchExistingSecrets is like fetchSecrets, but returns nil for secrets,
+// which don't exist, instead of an error
+func (r *Resolver) fetchExistingSecrets(ctx context.Context, namespace string, secNames []string) ([]*corev1.Secret, error) {
+	return r.fetchSecretList(ctx, namespace, secNames, true)
 }
 
+func (r *Resolver) fetchSecretList(ctx context.Context, namespace string, secNames []string, allowMissing bool) ([]*corev1.Secret, error) {
+	secrets := make([]*corev1.Secret, len(secNames))
+	sem := make(chan struct{}, r.secretFetchConcurrency)
+
+	group := errgroup.Group{}
+	for i, secName := range secNames {
+		i, secName := i, secName // https://golang.org/doc/faq#closures_and_goroutines
+		group.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := ctx.Err(); err != nil {
+				return errors.Wrapf(err, "aborted fetching secret '%s/%s'", namespace, secName)
+			}
+
+			secret := &corev1.Secret{}
+			err := r.getSecret(ctx, types.NamespacedName{Name: secName, Namespace: namespace}, secret)
+			if allowMissing && apierrors.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return secretGetError(err, namespace, secName, errors.Wrapf(err, "failed to get secret '%s/%s'", namespace, secName))
+			}
+			secrets[i] = secret
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// resourceKey identifies a resource referenced by a bdpl
+type resourceKey struct {
+	resType   bdv1.ReferenceType
+	namespace string
+	name      string
+}
+
+// resourceCache stores the resources fetched by resourceData. It must only be
+// used for a single resolve call, so changes to resources are picked up.
+type resourceCache map[resourceKey]interface{}
+
 // resourceData resolves different manifest reference types and returns the resource's data
-func (r *Resolver) resourceData(ctx context.Context, namespace string, resType bdv1.ReferenceType, name string, key string) (string, error) {
+func (r *Resolver) resourceData(ctx context.Context, cache resourceCache, namespace string, ref bdv1.ResourceReference, key string) (string, error) {
 	var (
-		data string
-		ok   bool
+		data    string
+		ok      bool
+		resType = ref.Type
+		name    = ref.Name
 	)
 
+	if ref.Namespace != "" && ref.Namespace != namespace {
+		ctxlog.Infof(ctx, "Using %s %s '%s' from namespace '%s' instead of '%s'", key, resType, name, ref.Namespace, namespace)
+		namespace = ref.Namespace
+	}
+
+	ck := resourceKey{resType: resType, namespace: namespace, name: name}
 	switch resType {
 	case bdv1.ConfigMapReference:
-		opsConfig := &corev1.ConfigMap{}
-		err := r.client.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, opsConfig)
-		if err != nil {
-			return data, errors.Wrapf(err, "failed to retrieve %s from configmap '%s/%s' via client.Get", key, namespace, name)
+		opsConfig, cached := cache[ck].(*corev1.ConfigMap)
+		if !cached {
+			opsConfig = &corev1.ConfigMap{}
+			err := r.client.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, opsConfig)
+			if err != nil {
+				return data, errors.Wrapf(err, "failed to retrieve %s from configmap '%s/%s' via client.Get", key, namespace, name)
+			}
+			cache[ck] = opsConfig
 		}
 		data, ok = opsConfig.Data[key]
 		if !ok {
 			return data, fmt.Errorf("configMap '%s/%s' doesn't contain key '%s'", namespace, name, key)
 		}
 	case bdv1.SecretReference:
-		opsSecret := &corev1.Secret{}
-		err := r.client.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, opsSecret)
-		if err != nil {
-			return data, errors.Wrapf(err, "failed to retrieve %s from secret '%s/%s' via client.Get", key, namespace, name)
+		opsSecret, cached := cache[ck].(*corev1.Secret)
+		if !cached {
+			opsSecret = &corev1.Secret{}
+			err := r.getSecret(ctx, types.NamespacedName{Name: name, Namespace: namespace}, opsSecret)
+			if err != nil {
+				return data, secretGetError(err, namespace, name, errors.Wrapf(err, "failed to retrieve %s from secret '%s/%s' via client.Get", key, namespace, name))
+			}
+			cache[ck] = opsSecret
 		}
 		encodedData, ok := opsSecret.Data[key]
 		if !ok {
-			return data, fmt.Errorf("secret '%s/%s' doesn't contain key '%s'", namespace, name, key)
+			return data, newResolveError(ErrSecretKeyMissing, namespace, name, key, fmt.Errorf("secret '%s/%s' doesn't contain key '%s'", namespace, name, key))
 		}
 		data = string(encodedData)
 	case bdv1.URLReference:
-		httpResponse, err := http.Get(name)
+		if body, cached := cache[ck].(string); cached {
+			return body, nil
+		}
+		headers, err := r.urlHeaders(ctx, namespace, ref.HeadersSecret)
+		if err != nil {
+			return data, err
+		}
+		body, err := r.fetchURL(ctx, name, key, headers)
 		if err != nil {
-			return data, errors.Wrapf(err, "failed to resolve %s from url '%s' via http.Get", key, name)
+			return data, err
 		}
-		body, err := ioutil.ReadAll(httpResponse.Body)
+		data = body
+		cache[ck] = data
+	case bdv1.FileReference:
+		if body, cached := cache[ck].(string); cached {
+			return body, nil
+		}
+		body, err := r.readFile(name, key)
 		if err != nil {
-			return data, errors.Wrapf(err, "failed to read %s response body '%s' via ioutil", key, name)
+			return data, err
 		}
-		data = string(body)
+		data = body
+		cache[ck] = data
+	case bdv1.InlineReference:
+		data = name
 	default:
 		return data, fmt.Errorf("unrecognized %s ref type %s", key, name)
 	}
@@ -372,6 +932,11 @@ func (r *Resolver) resourceData(ctx context.Context, namespace string, resType b
 }
 
 // InterpolateVariableFromSecrets reads explicit secrets and writes an interpolated manifest into desired manifest secret.
+// The explicit variables are collected from the with-ops manifest, so ops files
+// may add entries to the variables block, e.g. with 'path: /variables?/-'. The
+// optional marker is needed if the manifest has no variables block. Ops files
+// are applied before the manifest's variables are interpolated. They can only
+// use variable values if the resolver interpolates them, see WithOpsVariables.
 func (r *Resolver) InterpolateVariableFromSecrets(ctx context.Context, withOpsManifestData []byte, namespace string, boshdeploymentName string) ([]byte, error) {
 	var vars []boshtpl.Variables
 
@@ -385,6 +950,9 @@ func (r *Resolver) InterpolateVariableFromSecrets(ctx context.Context, withOpsMa
 
 		varName := variable.Name
 		varSecretName := names.SecretVariableName(varName)
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrapf(err, "aborted fetching secret '%s/%s'", namespace, varSecretName)
+		}
 
 		varQuarksSecret := &qsv1a1.QuarksSecret{}
 		err = r.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: varSecretName}, varQuarksSecret)
@@ -397,43 +965,80 @@ func (r *Resolver) InterpolateVariableFromSecrets(ctx context.Context, withOpsMa
 		}
 
 		varSecret := &corev1.Secret{}
-		err = r.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: varSecretName}, varSecret)
+		err = r.getSecret(ctx, types.NamespacedName{Namespace: namespace, Name: varSecretName}, varSecret)
 		if err != nil {
-			return nil, err
+			return nil, secretGetError(err, namespace, varSecretName, err)
 		}
 
-		varSecretData := varSecret.Data
-		for key, value := range varSecretData {
-			switch key {
-			case "password":
-				staticVars[varName] = string(value)
-			default:
-				staticVars[varName] = MergeStaticVar(staticVars[varName], key, string(value))
-			}
+		value, err := explicitVariableValue(varSecret)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			staticVars[varName] = value
 		}
 		vars = append(vars, staticVars)
 	}
 	desiredManifestBytes, err := InterpolateExplicitVariables(withOpsManifestData, vars, true)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to interpolate explicit variables")
+		return nil, newResolveError(ErrInterpolation, namespace, boshdeploymentName, "", errors.Wrap(err, "failed to interpolate explicit variables"))
 	}
 
 	return desiredManifestBytes, nil
 }
 
+// explicitVariableValue returns the value of an explicit variable from its
+// secret. The 'password' key is used as a plain value, other keys are
+// merged into a map in sorted order, so the result doesn't depend on map
+// iteration.
+func explicitVariableValue(secret *corev1.Secret) (interface{}, error) {
+	if password, ok := secret.Data["password"]; ok {
+		return string(password), nil
+	}
+
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var (
+		value  interface{}
+		err    error
+		isJSON = secret.Annotations[bdv1.AnnotationJSONValue] == "true"
+	)
+	for _, key := range keys {
+		if isJSON {
+			value, err = MergeStaticJSONVar(value, key, secret.Data[key])
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read JSON value of key '%s' in secret '%s/%s'", key, secret.Namespace, secret.Name)
+			}
+			continue
+		}
+		value = MergeStaticVar(value, key, string(secret.Data[key]))
+	}
+	return value, nil
+}
+
 // InterpolateExplicitVariables interpolates explicit variables in the manifest
 // Expects an array of maps, each element being a variable: [{ "name":"foo", "password": "value" }, {"name": "bar", "ca": "---"} ]
 // Returns the new manifest as a byte array
 func InterpolateExplicitVariables(boshManifestBytes []byte, vars []boshtpl.Variables, expectAllKeys bool) ([]byte, error) {
+	return InterpolateExplicitVariablesWithOpts(boshManifestBytes, vars, boshtpl.EvaluateOpts{
+		ExpectAllKeys:     expectAllKeys,
+		ExpectAllVarsUsed: false,
+	})
+}
+
+// InterpolateExplicitVariablesWithOpts works like InterpolateExplicitVariables,
+// but allows strict evaluation, e.g. setting ExpectAllVarsUsed to fail if
+// not all of the given variables are used by the manifest.
+func InterpolateExplicitVariablesWithOpts(boshManifestBytes []byte, vars []boshtpl.Variables, evalOpts boshtpl.EvaluateOpts) ([]byte, error) {
 	multiVars := boshtpl.NewMultiVars(vars)
 	tpl := boshtpl.NewTemplate(boshManifestBytes)
 
 	// Following options are empty for quarks-operator
 	op := patch.Ops{}
-	evalOpts := boshtpl.EvaluateOpts{
-		ExpectAllKeys:     expectAllKeys,
-		ExpectAllVarsUsed: false,
-	}
 
 	yamlBytes, err := tpl.Evaluate(multiVars, op, evalOpts)
 	if err != nil {
@@ -453,6 +1058,46 @@ func InterpolateExplicitVariables(boshManifestBytes []byte, vars []boshtpl.Varia
 	return yamlBytes, nil
 }
 
+// InterpolateKnownVariables interpolates the given explicit variables, but
+// keeps the placeholders of all other variables verbatim, so they can be
+// interpolated by a later stage.
+func InterpolateKnownVariables(boshManifestBytes []byte, vars []boshtpl.Variables) ([]byte, error) {
+	multiVars := boshtpl.NewMultiVars(vars)
+
+	// hide the placeholders of unknown variables from the template engine
+	deferred := map[string]string{}
+	masked := string(boshManifestBytes)
+	for _, ref := range bdm.FindVariableReferences(boshManifestBytes) {
+		placeholder := "((" + ref + "))"
+		if !strings.Contains(masked, placeholder) {
+			continue
+		}
+
+		name := strings.SplitN(strings.TrimPrefix(ref, "!"), ".", 2)[0]
+		_, found, err := multiVars.Get(boshtpl.VariableDefinition{Name: name})
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not look up variable '%s'", name)
+		}
+		if found {
+			continue
+		}
+
+		token := fmt.Sprintf("__quarks_deferred_variable_%d__", len(deferred))
+		deferred[token] = placeholder
+		masked = strings.ReplaceAll(masked, placeholder, token)
+	}
+
+	yamlBytes, err := InterpolateExplicitVariablesWithOpts([]byte(masked), vars, boshtpl.EvaluateOpts{})
+	if err != nil {
+		return nil, err
+	}
+
+	for token, placeholder := range deferred {
+		yamlBytes = bytes.ReplaceAll(yamlBytes, []byte(token), []byte(placeholder))
+	}
+	return yamlBytes, nil
+}
+
 // MergeStaticVar builds a map of values used for BOSH explicit variable interpolation
 func MergeStaticVar(staticVar interface{}, field string, value string) interface{} {
 	if staticVar == nil {
@@ -467,3 +1112,68 @@ func MergeStaticVar(staticVar interface{}, field string, value string) interface
 
 	return staticVar
 }
+
+// MergeStaticJSONVar works like MergeStaticVar, but for JSON encoded values.
+// If the value is an array, it becomes the value of the variable, e.g. a list
+// of trusted CAs. An array can't be combined with other keys. Objects are
+// merged as structured values, so their keys can be referenced like
+// '((var.field.key))'. Other values are merged decoded, like implicit
+// variables.
+func MergeStaticJSONVar(staticVar interface{}, field string, value []byte) (interface{}, error) {
+	var js interface{}
+	if err := json.Unmarshal(value, &js); err != nil {
+		return nil, err
+	}
+
+	if v, ok := js.([]interface{}); ok {
+		if staticVar != nil {
+			return nil, fmt.Errorf("array value of key '%s' can't be combined with other keys", field)
+		}
+		return interfaceMaps(v), nil
+	}
+
+	staticVarMap, ok := staticVar.(map[interface{}]interface{})
+	if !ok {
+		if staticVar != nil {
+			return nil, fmt.Errorf("key '%s' can't be combined with an array value", field)
+		}
+		staticVarMap = map[interface{}]interface{}{}
+	}
+	staticVarMap[field] = interfaceMaps(js)
+	return staticVarMap, nil
+}
+
+// jsonPath follows the path segments through nested JSON objects
+func jsonPath(js interface{}, path []string) (interface{}, error) {
+	for i, segment := range path {
+		m, ok := js.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected a JSON object at '%s', found %T", strings.Join(path[:i], "/"), js)
+		}
+		js, ok = m[segment]
+		if !ok {
+			return nil, fmt.Errorf("missing key '%s'", strings.Join(path[:i+1], "/"))
+		}
+	}
+	return js, nil
+}
+
+// interfaceMaps converts decoded JSON objects to map[interface{}]interface{}
+// recursively
+func interfaceMaps(js interface{}) interface{} {
+	switch v := js.(type) {
+	case map[string]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, val := range v {
+			m[k] = interfaceMaps(val)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, val := range v {
+			l[i] = interfaceMaps(val)
+		}
+		return l
+	}
+	return js
+}
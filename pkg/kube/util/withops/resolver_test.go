@@ -3,7 +3,14 @@ package withops_test
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	boshtpl "github.com/cloudfoundry/bosh-cli/director/template"
 	"github.com/go-test/deep"
@@ -11,9 +18,12 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
@@ -21,10 +31,79 @@ import (
 	bdc "code.cloudfoundry.org/quarks-operator/pkg/kube/apis/boshdeployment/v1alpha1"
 	"code.cloudfoundry.org/quarks-operator/pkg/kube/controllers/fakes"
 	"code.cloudfoundry.org/quarks-operator/pkg/kube/util/withops"
+	qsv1a1 "code.cloudfoundry.org/quarks-secret/pkg/kube/apis/quarkssecret/v1alpha1"
 	"code.cloudfoundry.org/quarks-utils/pkg/ctxlog"
 	"code.cloudfoundry.org/quarks-utils/testing/testhelper"
 )
 
+// stubHTTPClient answers requests without a server
+type stubHTTPClient func(req *http.Request) (*http.Response, error)
+
+func (f stubHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// recordingMetrics records the measurements of the resolver
+type recordingMetrics struct {
+	mu            sync.Mutex
+	resolves      []string
+	opsApplied    int
+	secretFetches int
+}
+
+func (m *recordingMetrics) ObserveResolve(method string, _ time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resolves = append(m.resolves, method)
+}
+
+func (m *recordingMetrics) AddOpsApplied(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.opsApplied += n
+}
+
+func (m *recordingMetrics) IncSecretFetches() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.secretFetches++
+}
+
+// trackingClient counts the gets of the wrapped client and the maximum number
+// of concurrent gets. Gets of the objects in delays are slowed down.
+type trackingClient struct {
+	client.Client
+	delays map[string]time.Duration
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	gets        map[string]int
+}
+
+func newTrackingClient(c client.Client) *trackingClient {
+	return &trackingClient{Client: c, delays: map[string]time.Duration{}, gets: map[string]int{}}
+}
+
+func (c *trackingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.gets[key.Name]++
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.inFlight--
+		c.mu.Unlock()
+	}()
+
+	time.Sleep(c.delays[key.Name])
+	return c.Client.Get(ctx, key, obj)
+}
+
 var _ = Describe("WithOps", func() {
 	var (
 		replaceOpsStr string
@@ -43,10 +122,12 @@ var _ = Describe("WithOps", func() {
 		remoteFileServer *ghttp.Server
 		expectedManifest *bdm.Manifest
 		deployment       *bdc.BOSHDeployment
+		logs             *observer.ObservedLogs
 	)
 
 	BeforeEach(func() {
-		_, log := testhelper.NewTestLogger()
+		var log *zap.SugaredLogger
+		logs, log = testhelper.NewTestLogger()
 		ctx = ctxlog.NewParentContext(log)
 		validManifestPath = "/valid-manifest.yml"
 		validOpsPath = "/valid-ops.yml"
@@ -199,6 +280,24 @@ instance_groups:
     instances: 1
     properties:
       host: 'foo.((system_domain))'
+`},
+				},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "manifest-with-missing-implicit-vars",
+						Namespace: "default",
+					},
+					Data: map[string]string{bdc.ManifestSpecName: `---
+name: foo
+instance_groups:
+  - name: component1
+    instances: 1
+    properties:
+      domain: ((system_domain))
+      ca: ((ssl/ca))
+      missing_key: ((ssl/missing))
+      missing_secret: ((unknown))
+      missing_slashed: ((other/key))
 `},
 				},
 				&corev1.ConfigMap{
@@ -232,6 +331,35 @@ instance_groups:
     instances: 2
     properties:
       nested: ((implicit_struct))
+`},
+				},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "manifest-with-nested-json-implicit-vars",
+						Namespace: "default",
+					},
+					Data: map[string]string{bdc.ManifestSpecName: `---
+name: foo
+instance_groups:
+  - name: component1
+    instances: 1
+    properties:
+      dotted: ((implicit_struct.a.b))
+      slashed: ((implicit_struct/value/a))
+`},
+				},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "manifest-with-nested-plain-implicit-var",
+						Namespace: "default",
+					},
+					Data: map[string]string{bdc.ManifestSpecName: `---
+name: foo
+instance_groups:
+  - name: component1
+    instances: 1
+    properties:
+      ca: ((ssl/ca/chain))
 `},
 				},
 				&corev1.Secret{
@@ -259,6 +387,17 @@ instance_groups:
 						"key":  []byte("the-key"),
 					},
 				},
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "var-router-cert",
+						Namespace: "default",
+					},
+					Data: map[string][]byte{
+						"ca":          []byte("the-router-ca"),
+						"certificate": []byte("the-router-cert"),
+						"private_key": []byte("the-router-key"),
+					},
+				},
 				&corev1.Secret{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      "var-implicit-struct",
@@ -278,6 +417,13 @@ instance_groups:
 					},
 					Data: map[string]string{bdc.OpsSpecName: replaceOpsStr},
 				},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "shared-ops",
+						Namespace: "shared",
+					},
+					Data: map[string]string{bdc.OpsSpecName: removeOpsStr},
+				},
 				&corev1.ConfigMap{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      "remove-ops",
@@ -477,6 +623,251 @@ instance_groups:
 			Expect(deep.Equal(manifest, expectedManifest)).To(HaveLen(0))
 		})
 
+		It("retries URL references on server errors", func() {
+			calls := 0
+			remoteFileServer.RouteToHandler("GET", "/flaky-manifest.yml", func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				_, _ = w.Write([]byte(`---
+instance_groups:
+  - name: component5
+    instances: 1`))
+			})
+			resolver.SetURLRetry(3, time.Millisecond, time.Second)
+
+			deployment := &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.URLReference,
+						Name: remoteFileServer.URL() + "/flaky-manifest.yml",
+					},
+				},
+			}
+
+			manifest, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.InstanceGroups).To(HaveLen(1))
+			Expect(calls).To(Equal(2))
+		})
+
+		It("doesn't retry URL references on client errors", func() {
+			remoteFileServer.RouteToHandler("GET", "/forbidden-manifest.yml", ghttp.RespondWith(http.StatusForbidden, ""))
+			resolver.SetURLRetry(3, time.Millisecond, time.Second)
+
+			deployment := &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.URLReference,
+						Name: remoteFileServer.URL() + "/forbidden-manifest.yml",
+					},
+				},
+			}
+
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to fetch manifest from URL '" + remoteFileServer.URL() + "/forbidden-manifest.yml': unexpected status 403"))
+		})
+
+		It("doesn't retry URL references if the context is canceled", func() {
+			cctx, cancel := context.WithCancel(ctx)
+			calls := 0
+			remoteFileServer.RouteToHandler("GET", "/canceled-manifest.yml", func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				cancel()
+				w.WriteHeader(http.StatusServiceUnavailable)
+			})
+			resolver.SetURLRetry(3, time.Millisecond, time.Second)
+
+			deployment := &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.URLReference,
+						Name: remoteFileServer.URL() + "/canceled-manifest.yml",
+					},
+				},
+			}
+
+			_, err := resolver.Manifest(cctx, deployment, "default")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Cause(err)).To(Equal(context.Canceled))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("uses the configured HTTP client for URL references", func() {
+			var requested string
+			resolver.SetHTTPClient(stubHTTPClient(func(req *http.Request) (*http.Response, error) {
+				requested = req.URL.String()
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body: ioutil.NopCloser(strings.NewReader(`---
+instance_groups:
+  - name: component5
+    instances: 1`)),
+				}, nil
+			}))
+
+			deployment := &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.URLReference,
+						Name: "https://artifacts.example.com/manifest.yml",
+					},
+				},
+			}
+
+			manifest, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.InstanceGroups).To(HaveLen(1))
+			Expect(requested).To(Equal("https://artifacts.example.com/manifest.yml"))
+		})
+
+		It("sends the headers from the secret for URL references", func() {
+			remoteFileServer.RouteToHandler("GET", "/protected-manifest.yml", ghttp.CombineHandlers(
+				ghttp.VerifyHeaderKV("Authorization", "Bearer token"),
+				ghttp.RespondWith(http.StatusOK, `---
+instance_groups:
+  - name: component5
+    instances: 1`),
+			))
+			err := client.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "url-headers", Namespace: "default"},
+				Data:       map[string][]byte{"Authorization": []byte("Bearer token")},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			deployment := &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type:          bdc.URLReference,
+						Name:          remoteFileServer.URL() + "/protected-manifest.yml",
+						HeadersSecret: "url-headers",
+					},
+				},
+			}
+
+			manifest, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.InstanceGroups).To(HaveLen(1))
+		})
+
+		It("throws an error if the URL reference is not found", func() {
+			remoteFileServer.RouteToHandler("GET", "/missing-ops.yml", ghttp.RespondWith(http.StatusNotFound, "<html>not found</html>"))
+
+			deployment := &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.ConfigMapReference,
+						Name: "base-manifest",
+					},
+					Ops: []bdc.ResourceReference{
+						{
+							Type: bdc.URLReference,
+							Name: remoteFileServer.URL() + "/missing-ops.yml",
+						},
+					},
+				},
+			}
+
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to fetch ops from URL '" + remoteFileServer.URL() + "/missing-ops.yml': unexpected status 404"))
+			Expect(interpolator.AddOpsCallCount()).To(Equal(0))
+		})
+
+		Context("when using file references", func() {
+			var baseDir string
+
+			BeforeEach(func() {
+				var err error
+				baseDir, err = ioutil.TempDir("", "withops-files")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(os.Mkdir(filepath.Join(baseDir, "manifests"), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(baseDir, "manifests", "manifest.yml"), []byte(`---
+instance_groups:
+  - name: component6
+    instances: 1
+`), 0644)).To(Succeed())
+
+				resolver.SetFileBaseDir(filepath.Join(baseDir, "manifests"))
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(baseDir)).To(Succeed())
+			})
+
+			It("reads the manifest from the file", func() {
+				deployment := &bdc.BOSHDeployment{
+					Spec: bdc.BOSHDeploymentSpec{
+						Manifest: bdc.ResourceReference{
+							Type: bdc.FileReference,
+							Name: "manifest.yml",
+						},
+					},
+				}
+
+				manifest, err := resolver.Manifest(ctx, deployment, "default")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(manifest.InstanceGroups).To(HaveLen(1))
+				Expect(manifest.InstanceGroups[0].Name).To(Equal("component6"))
+			})
+
+			It("doesn't read files outside of the base dir", func() {
+				Expect(ioutil.WriteFile(filepath.Join(baseDir, "outside.yml"), []byte("---\n"), 0644)).To(Succeed())
+
+				deployment := &bdc.BOSHDeployment{
+					Spec: bdc.BOSHDeploymentSpec{
+						Manifest: bdc.ResourceReference{
+							Type: bdc.FileReference,
+							Name: "../outside.yml",
+						},
+					},
+				}
+
+				_, err := resolver.Manifest(ctx, deployment, "default")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("path is outside of"))
+			})
+
+			It("fails if file references are disabled", func() {
+				resolver.SetFileBaseDir("")
+
+				deployment := &bdc.BOSHDeployment{
+					Spec: bdc.BOSHDeploymentSpec{
+						Manifest: bdc.ResourceReference{
+							Type: bdc.FileReference,
+							Name: "manifest.yml",
+						},
+					},
+				}
+
+				_, err := resolver.Manifest(ctx, deployment, "default")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("file references are disabled"))
+			})
+
+			It("uses the default base dir for new resolvers", func() {
+				withops.SetDefaultFileBaseDir(filepath.Join(baseDir, "manifests"))
+				defer withops.SetDefaultFileBaseDir("")
+				resolver = withops.NewResolver(client, func() withops.Interpolator { return interpolator })
+
+				deployment := &bdc.BOSHDeployment{
+					Spec: bdc.BOSHDeploymentSpec{
+						Manifest: bdc.ResourceReference{
+							Type: bdc.FileReference,
+							Name: "manifest.yml",
+						},
+					},
+				}
+
+				manifest, err := resolver.Manifest(ctx, deployment, "default")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(manifest.InstanceGroups[0].Name).To(Equal("component6"))
+			})
+		})
+
 		It("works for valid CRs containing one ops", func() {
 			interpolator.InterpolateReturns([]byte(`---
 instance_groups:
@@ -532,11 +923,11 @@ instance_groups:
 			Expect(string(opsBytes)).To(Equal(replaceOpsStr))
 		})
 
-		It("works for valid CRs containing multi ops", func() {
+		It("uses the namespace of the ops reference if set", func() {
 			interpolator.InterpolateReturns([]byte(`---
 instance_groups:
   - name: component1
-    instances: 4
+    instances: 1
 `), nil)
 
 			deployment := &bdc.BOSHDeployment{
@@ -547,85 +938,252 @@ instance_groups:
 					},
 					Ops: []bdc.ResourceReference{
 						{
-							Type: bdc.ConfigMapReference,
-							Name: "replace-ops",
-						},
-						{
-							Type: bdc.SecretReference,
-							Name: "opaque-ops",
-						},
-						{
-							Type: bdc.URLReference,
-							Name: remoteFileServer.URL() + validOpsPath,
-						},
-						{
-							Type: bdc.ConfigMapReference,
-							Name: "remove-ops",
+							Type:      bdc.ConfigMapReference,
+							Name:      "shared-ops",
+							Namespace: "shared",
 						},
 					},
 				},
 			}
-			expectedManifest = &bdm.Manifest{
-				InstanceGroups: []*bdm.InstanceGroup{
-					{
-						Name:      "component1",
-						Instances: 4,
-						Properties: bdm.InstanceGroupProperties{
-							Properties: map[string]interface{}{},
-						},
-					},
-				},
-				AddOnsApplied: true,
-			}
 
 			manifest, err := resolver.Manifest(ctx, deployment, "default")
-
 			Expect(err).ToNot(HaveOccurred())
-			Expect(manifest).ToNot(Equal(nil))
-			Expect(len(manifest.InstanceGroups)).To(Equal(1))
-			Expect(deep.Equal(manifest, expectedManifest)).To(HaveLen(0))
+			Expect(manifest.InstanceGroups).To(HaveLen(1))
 
-			Expect(interpolator.AddOpsCallCount()).To(Equal(4))
+			Expect(interpolator.AddOpsCallCount()).To(Equal(1))
 			opsBytes := interpolator.AddOpsArgsForCall(0)
-			Expect(string(opsBytes)).To(Equal(replaceOpsStr))
-			opsBytes = interpolator.AddOpsArgsForCall(1)
-			Expect(string(opsBytes)).To(Equal(opaqueOpsStr))
-			opsBytes = interpolator.AddOpsArgsForCall(2)
-			Expect(string(opsBytes)).To(Equal(urlOpsStr))
-			opsBytes = interpolator.AddOpsArgsForCall(3)
 			Expect(string(opsBytes)).To(Equal(removeOpsStr))
 		})
 
-		It("works for resource requirements", func() {
+		It("uses header secrets of URL references from the reference's namespace", func() {
+			remoteFileServer.RouteToHandler("GET", "/protected-manifest.yml", ghttp.CombineHandlers(
+				ghttp.VerifyHeader(http.Header{"Authorization": []string{"Bearer token"}}),
+				ghttp.RespondWith(http.StatusOK, `---
+instance_groups:
+  - name: component5
+    instances: 1`),
+			))
+			err := client.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "url-headers", Namespace: "shared"},
+				Data:       map[string][]byte{"Authorization": []byte("Bearer token")},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
 			deployment := &bdc.BOSHDeployment{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "foo-deployment",
-				},
 				Spec: bdc.BOSHDeploymentSpec{
 					Manifest: bdc.ResourceReference{
-						Type: bdc.ConfigMapReference,
-						Name: "manifest-with-resources",
+						Type:          bdc.URLReference,
+						Name:          remoteFileServer.URL() + "/protected-manifest.yml",
+						HeadersSecret: "url-headers",
+						Namespace:     "shared",
 					},
 				},
 			}
 
 			manifest, err := resolver.Manifest(ctx, deployment, "default")
-
 			Expect(err).ToNot(HaveOccurred())
-			Expect(manifest).ToNot(Equal(nil))
-			Expect(len(manifest.InstanceGroups)).To(Equal(1))
-			resourceList := manifest.InstanceGroups[0].Jobs[0].Properties.Quarks.BPM.Processes[0].Requests
-			Expect(resourceList.Memory().String()).To(Equal("128Mi"))
-			Expect(resourceList.Cpu().String()).To(Equal("5m"))
+			Expect(manifest.InstanceGroups).To(HaveLen(1))
+			Expect(manifest.InstanceGroups[0].Name).To(Equal("component5"))
 		})
 
-		It("throws an error if the manifest can not be found", func() {
+		It("interpolates JSON values of explicit variables as structured values", func() {
+			Expect(client.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "json-config",
+					Namespace:   "default",
+					Annotations: map[string]string{bdc.AnnotationJSONValue: "true"},
+				},
+				Data: map[string][]byte{"config": []byte(`{"limits":{"memory":"1G","cpus":2}}`)},
+			})).To(Succeed())
+
 			deployment := &bdc.BOSHDeployment{
 				Spec: bdc.BOSHDeploymentSpec{
 					Manifest: bdc.ResourceReference{
-						Type: bdc.ConfigMapReference,
-						Name: "not-existing",
-					},
+						Type: bdc.InlineReference,
+						Name: `---
+instance_groups:
+  - name: component1
+    instances: 1
+    properties:
+      limits: ((app.config.limits))
+`,
+					},
+					Vars: []bdc.VarReference{{Name: "app", Secret: "json-config"}},
+				},
+			}
+
+			manifest, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+
+			limits, err := json.Marshal(manifest.InstanceGroups[0].Properties.Properties["limits"])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(limits)).To(MatchJSON(`{"memory":"1G","cpus":2}`))
+		})
+
+		It("fails for JSON explicit variables with an array key next to a scalar key", func() {
+			Expect(client.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "json-mixed",
+					Namespace:   "default",
+					Annotations: map[string]string{bdc.AnnotationJSONValue: "true"},
+				},
+				Data: map[string][]byte{
+					"cas":  []byte(`["ca1","ca2"]`),
+					"name": []byte(`"trusted"`),
+				},
+			})).To(Succeed())
+
+			deployment := &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.InlineReference,
+						Name: `---
+instance_groups:
+  - name: component1
+    instances: 1
+    properties:
+      cas: ((app))
+`,
+					},
+					Vars: []bdc.VarReference{{Name: "app", Secret: "json-mixed"}},
+				},
+			}
+
+			for i := 0; i < 10; i++ {
+				_, err := resolver.Manifest(ctx, deployment, "default")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("failed to read JSON value of key 'name' in secret 'default/json-mixed': key 'name' can't be combined with an array value"))
+			}
+		})
+
+		It("uses the content of inline references", func() {
+			interpolator.InterpolateReturns([]byte(`---
+instance_groups:
+  - name: component1
+    instances: 1
+`), nil)
+
+			deployment := &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.InlineReference,
+						Name: `---
+instance_groups:
+  - name: component1
+    instances: 2
+`,
+					},
+					Ops: []bdc.ResourceReference{
+						{
+							Type: bdc.InlineReference,
+							Name: replaceOpsStr,
+						},
+					},
+				},
+			}
+
+			manifest, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.InstanceGroups).To(HaveLen(1))
+
+			Expect(string(interpolator.AddOpsArgsForCall(0))).To(Equal(replaceOpsStr))
+			Expect(string(interpolator.InterpolateArgsForCall(0))).To(ContainSubstring("instances: 2"))
+		})
+
+		It("works for valid CRs containing multi ops", func() {
+			interpolator.InterpolateReturns([]byte(`---
+instance_groups:
+  - name: component1
+    instances: 4
+`), nil)
+
+			deployment := &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.ConfigMapReference,
+						Name: "base-manifest",
+					},
+					Ops: []bdc.ResourceReference{
+						{
+							Type: bdc.ConfigMapReference,
+							Name: "replace-ops",
+						},
+						{
+							Type: bdc.SecretReference,
+							Name: "opaque-ops",
+						},
+						{
+							Type: bdc.URLReference,
+							Name: remoteFileServer.URL() + validOpsPath,
+						},
+						{
+							Type: bdc.ConfigMapReference,
+							Name: "remove-ops",
+						},
+					},
+				},
+			}
+			expectedManifest = &bdm.Manifest{
+				InstanceGroups: []*bdm.InstanceGroup{
+					{
+						Name:      "component1",
+						Instances: 4,
+						Properties: bdm.InstanceGroupProperties{
+							Properties: map[string]interface{}{},
+						},
+					},
+				},
+				AddOnsApplied: true,
+			}
+
+			manifest, err := resolver.Manifest(ctx, deployment, "default")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest).ToNot(Equal(nil))
+			Expect(len(manifest.InstanceGroups)).To(Equal(1))
+			Expect(deep.Equal(manifest, expectedManifest)).To(HaveLen(0))
+
+			Expect(interpolator.AddOpsCallCount()).To(Equal(4))
+			opsBytes := interpolator.AddOpsArgsForCall(0)
+			Expect(string(opsBytes)).To(Equal(replaceOpsStr))
+			opsBytes = interpolator.AddOpsArgsForCall(1)
+			Expect(string(opsBytes)).To(Equal(opaqueOpsStr))
+			opsBytes = interpolator.AddOpsArgsForCall(2)
+			Expect(string(opsBytes)).To(Equal(urlOpsStr))
+			opsBytes = interpolator.AddOpsArgsForCall(3)
+			Expect(string(opsBytes)).To(Equal(removeOpsStr))
+		})
+
+		It("works for resource requirements", func() {
+			deployment := &bdc.BOSHDeployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "foo-deployment",
+				},
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.ConfigMapReference,
+						Name: "manifest-with-resources",
+					},
+				},
+			}
+
+			manifest, err := resolver.Manifest(ctx, deployment, "default")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest).ToNot(Equal(nil))
+			Expect(len(manifest.InstanceGroups)).To(Equal(1))
+			resourceList := manifest.InstanceGroups[0].Jobs[0].Properties.Quarks.BPM.Processes[0].Requests
+			Expect(resourceList.Memory().String()).To(Equal("128Mi"))
+			Expect(resourceList.Cpu().String()).To(Equal("5m"))
+		})
+
+		It("throws an error if the manifest can not be found", func() {
+			deployment := &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.ConfigMapReference,
+						Name: "not-existing",
+					},
 				},
 			}
 			_, err := resolver.Manifest(ctx, deployment, "default")
@@ -756,6 +1314,29 @@ instance_groups:
 			_, err := resolver.Manifest(ctx, deployment, "default")
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("Failed to interpolate"))
+			Expect(errors.Is(err, withops.ErrInterpolation)).To(BeTrue())
+		})
+
+		It("returns a secret key missing error if the manifest secret has no manifest", func() {
+			Expect(client.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "empty-manifest", Namespace: "default"},
+			})).To(Succeed())
+			deployment := &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.SecretReference,
+						Name: "empty-manifest",
+					},
+				},
+			}
+
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(errors.Is(err, withops.ErrSecretKeyMissing)).To(BeTrue())
+
+			var resolveErr *withops.ResolveError
+			Expect(errors.As(err, &resolveErr)).To(BeTrue())
+			Expect(resolveErr.Name).To(Equal("empty-manifest"))
+			Expect(resolveErr.Key).To(Equal(bdc.ManifestSpecName))
 		})
 
 		It("throws an error if containing unsupported ops type", func() {
@@ -885,6 +1466,15 @@ instance_groups:
 				Expect(len(implicitVars)).To(Equal(1))
 				Expect(implicitVars[0]).To(Equal("var-system-domain"))
 			})
+
+			It("stops fetching secrets if the context is canceled", func() {
+				ctx, cancel := context.WithCancel(ctx)
+				cancel()
+
+				_, err := resolver.Manifest(ctx, deployment, "default")
+				Expect(err).To(HaveOccurred())
+				Expect(errors.Cause(err)).To(Equal(context.Canceled))
+			})
 		})
 
 		It("verify does not return an error for valid addon job properties", func() {
@@ -1007,6 +1597,124 @@ instance_groups:
 			})
 		})
 
+		When("certificate implicit variables", func() {
+			BeforeEach(func() {
+				deployment = &bdc.BOSHDeployment{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "foo-deployment",
+					},
+					Spec: bdc.BOSHDeploymentSpec{
+						Manifest: bdc.ResourceReference{
+							Type: bdc.InlineReference,
+							Name: `---
+instance_groups:
+- name: component1
+  properties:
+    tls: ((router_cert))
+    cert: ((router_cert.certificate))
+    key: ((router_cert/private_key))
+`,
+						},
+					},
+				}
+			})
+
+			It("uses the whole certificate as an object or single keys", func() {
+				m, err := resolver.Manifest(ctx, deployment, "default")
+				Expect(err).ToNot(HaveOccurred())
+
+				props := m.InstanceGroups[0].Properties.Properties
+				Expect(props["tls"]).To(Equal(map[string]interface{}{
+					"ca":          "the-router-ca",
+					"certificate": "the-router-cert",
+					"private_key": "the-router-key",
+				}))
+				Expect(props["cert"]).To(Equal("the-router-cert"))
+				Expect(props["key"]).To(Equal("the-router-key"))
+			})
+
+			It("doesn't report the whole certificate as missing", func() {
+				missing, err := resolver.MissingVariableSecrets(ctx, deployment, "default")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(missing).To(BeEmpty())
+			})
+		})
+
+		When("implicit variables are missing", func() {
+			BeforeEach(func() {
+				deployment = &bdc.BOSHDeployment{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "foo-deployment",
+					},
+					Spec: bdc.BOSHDeploymentSpec{
+						Manifest: bdc.ResourceReference{
+							Type: bdc.ConfigMapReference,
+							Name: "manifest-with-missing-implicit-vars",
+						},
+						Ops: []bdc.ResourceReference{},
+					},
+				}
+			})
+
+			It("lists all missing secrets and keys", func() {
+				missing, err := resolver.MissingVariableSecrets(ctx, deployment, "default")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(missing).To(Equal([]string{"var-other", "var-ssl/missing", "var-unknown"}))
+			})
+
+			It("resolves the existing variables and lists the missing ones", func() {
+				m, err := bdm.LoadYAML([]byte(`---
+instance_groups:
+  - name: component1
+    instances: 1
+    properties:
+      domain: ((system_domain))
+      ca: ((ssl/ca))
+      missing_key: ((ssl/missing))
+      missing_secret: ((unknown))
+`))
+				Expect(err).ToNot(HaveOccurred())
+
+				resolved, missing, err := resolver.ResolveVariableRefs(ctx, m, "default")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(missing).To(Equal([]string{"var-ssl/missing", "var-unknown"}))
+				Expect(resolved).To(HaveKeyWithValue("ssl/ca", "the-ca"))
+				Expect(resolved).To(HaveKey("system_domain"))
+				Expect(resolved).To(HaveLen(2))
+			})
+
+			It("lists unresolvable JSON paths as missing", func() {
+				m, err := bdm.LoadYAML([]byte(`---
+instance_groups:
+  - name: component1
+    instances: 1
+    properties:
+      nested: ((implicit-struct/value/a/b))
+      missing_path: ((implicit-struct/value/a/c))
+      not_json: ((ssl/ca/nested))
+`))
+				Expect(err).ToNot(HaveOccurred())
+
+				resolved, missing, err := resolver.ResolveVariableRefs(ctx, m, "default")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(missing).To(ConsistOf("var-implicit-struct/value/a/c", "var-ssl/ca/nested"))
+				Expect(resolved).To(HaveKey("implicit-struct/value/a/b"))
+				Expect(resolved).To(HaveLen(1))
+			})
+
+			It("returns a secret not found error", func() {
+				_, err := resolver.Manifest(ctx, deployment, "default")
+				Expect(err).To(HaveOccurred())
+				Expect(errors.Is(err, withops.ErrSecretNotFound)).To(BeTrue())
+				Expect(errors.Is(err, withops.ErrSecretKeyMissing)).To(BeFalse())
+
+				var resolveErr *withops.ResolveError
+				Expect(errors.As(err, &resolveErr)).To(BeTrue())
+				Expect(resolveErr.Namespace).To(Equal("default"))
+				Expect(resolveErr.Name).To(BeElementOf("var-other", "var-unknown"))
+			})
+		})
+
 		When("replacing implicit variables", func() {
 			BeforeEach(func() {
 				deployment = &bdc.BOSHDeployment{
@@ -1042,25 +1750,975 @@ instance_groups:
 				Expect(implicitVars).To(HaveLen(1))
 				Expect(implicitVars).To(ContainElement("var-implicit-struct"))
 			})
+
+			It("navigates into json content of implicit vars", func() {
+				deployment.Spec.Manifest.Name = "manifest-with-nested-json-implicit-vars"
+
+				m, err := resolver.Manifest(ctx, deployment, "default")
+				Expect(err).ToNot(HaveOccurred())
+
+				props := m.InstanceGroups[0].Properties.Properties
+				Expect(fmt.Sprintf("%v", props["dotted"])).To(Equal("3"))
+
+				bytes, err := json.Marshal(props["slashed"])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(bytes)).To(Equal(`{"b":3}`))
+			})
+
+			It("fails to navigate into implicit vars which are not json", func() {
+				deployment.Spec.Manifest.Name = "manifest-with-nested-plain-implicit-var"
+
+				_, err := resolver.Manifest(ctx, deployment, "default")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("is not annotated as JSON"))
+			})
 		})
 	})
 
-	Context("Interpolate variables correctly", func() {
-		var (
-			baseManifest          []byte
-			incorrectBaseManifest []byte
-			vars                  []boshtpl.Variables
-		)
-
+	Describe("ManifestDetailedWithTrace", func() {
 		BeforeEach(func() {
-			baseManifest = []byte(`
----
-director_uuid: ((password1))
+			interpolator.InterpolateReturns([]byte(`---
 instance_groups:
-- name: ((value1.key1))
-- name: ((value2.key2))
-- name: ((value2.key3))
-`)
+  - name: component1
+    instances: 2
+`), nil)
+
+			deployment = &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.ConfigMapReference,
+						Name: "base-manifest",
+					},
+					Ops: []bdc.ResourceReference{
+						{
+							Type: bdc.ConfigMapReference,
+							Name: "replace-ops",
+						},
+						{
+							Type: bdc.SecretReference,
+							Name: "opaque-ops",
+						},
+					},
+				},
+			}
+		})
+
+		It("returns the applied ops files in order", func() {
+			manifest, trace, err := resolver.ManifestDetailedWithTrace(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.InstanceGroups).To(HaveLen(1))
+			Expect(trace).To(Equal([]string{"configmap/replace-ops", "secret/opaque-ops"}))
+		})
+
+		It("returns the ops files applied before an error", func() {
+			deployment.Spec.Ops = append(deployment.Spec.Ops, bdc.ResourceReference{
+				Type: bdc.ConfigMapReference,
+				Name: "not-found-ops",
+			})
+
+			_, trace, err := resolver.ManifestDetailedWithTrace(ctx, deployment, "default")
+			Expect(err).To(HaveOccurred())
+			Expect(trace).To(Equal([]string{"configmap/replace-ops", "secret/opaque-ops"}))
+		})
+
+		It("reports ops files, which fail to parse", func() {
+			interpolator.AddOpsReturnsOnCall(1, errors.New("fake-parse-error"))
+
+			_, trace, err := resolver.ManifestDetailedWithTrace(ctx, deployment, "default")
+			Expect(err).To(MatchError("ops file 'opaque-ops' is invalid: fake-parse-error"))
+			Expect(trace).To(Equal([]string{"configmap/replace-ops"}))
+		})
+
+		It("reports ops files, which fail to apply", func() {
+			interpolator.InterpolateReturnsOnCall(0, nil, errors.New("fake-apply-error"))
+
+			_, _, err := resolver.ManifestDetailedWithTrace(ctx, deployment, "default")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("ops file 'replace-ops' could not be applied (path not found?): fake-apply-error"))
+			Expect(errors.Is(err, withops.ErrInterpolation)).To(BeTrue())
+		})
+	})
+
+	Describe("conditional ops files", func() {
+		BeforeEach(func() {
+			interpolator.InterpolateReturns([]byte(`---
+instance_groups:
+  - name: component1
+    instances: 2
+`), nil)
+
+			deployment = &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.ConfigMapReference,
+						Name: "base-manifest",
+					},
+					Ops: []bdc.ResourceReference{
+						{
+							Type: bdc.ConfigMapReference,
+							Name: "replace-ops",
+						},
+					},
+				},
+			}
+		})
+
+		It("applies ops files without a condition", func() {
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(interpolator.AddOpsCallCount()).To(Equal(1))
+		})
+
+		It("applies ops files if the instance group exists", func() {
+			deployment.Spec.Ops[0].Condition = &bdc.OpsCondition{InstanceGroup: "component2"}
+
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(interpolator.AddOpsCallCount()).To(Equal(1))
+			Expect(interpolator.InterpolateCallCount()).To(Equal(1))
+		})
+
+		It("skips ops files if the instance group doesn't exist", func() {
+			deployment.Spec.Ops[0].Condition = &bdc.OpsCondition{InstanceGroup: "diego-cell"}
+
+			manifest, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(interpolator.AddOpsCallCount()).To(Equal(0))
+			Expect(interpolator.InterpolateCallCount()).To(Equal(0))
+			Expect(manifest.InstanceGroups[0].Instances).To(Equal(1))
+		})
+
+		It("applies ops files if the property has the value", func() {
+			deployment.Spec.Ops[0].Condition = &bdc.OpsCondition{
+				Property: &bdc.PropertyCondition{Path: "/instance_groups/name=component1/instances", Value: "1"},
+			}
+
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(interpolator.AddOpsCallCount()).To(Equal(1))
+		})
+
+		It("skips ops files if the property has a different value or doesn't exist", func() {
+			deployment.Spec.Ops[0].Condition = &bdc.OpsCondition{
+				Property: &bdc.PropertyCondition{Path: "/instance_groups/name=component1/instances", Value: "3"},
+			}
+			deployment.Spec.Ops = append(deployment.Spec.Ops, bdc.ResourceReference{
+				Type: bdc.ConfigMapReference,
+				Name: "replace-ops",
+				Condition: &bdc.OpsCondition{
+					Property: &bdc.PropertyCondition{Path: "/instance_groups/name=component1/azs", Value: "z1"},
+				},
+			})
+
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(interpolator.AddOpsCallCount()).To(Equal(0))
+		})
+
+		It("fails for invalid property paths", func() {
+			deployment.Spec.Ops[0].Condition = &bdc.OpsCondition{
+				Property: &bdc.PropertyCondition{Path: "instance_groups", Value: "1"},
+			}
+
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to evaluate condition of ops file 'replace-ops'"))
+		})
+
+		It("doesn't trace skipped ops files", func() {
+			deployment.Spec.Ops = append(deployment.Spec.Ops, bdc.ResourceReference{
+				Type:      bdc.SecretReference,
+				Name:      "opaque-ops",
+				Condition: &bdc.OpsCondition{InstanceGroup: "diego-cell"},
+			})
+
+			_, trace, err := resolver.ManifestDetailedWithTrace(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(trace).To(Equal([]string{"configmap/replace-ops"}))
+		})
+	})
+
+	Describe("ops file warnings", func() {
+		const (
+			noopWarning   = "which didn't change the manifest"
+			growthWarning = "grew the manifest"
+		)
+
+		BeforeEach(func() {
+			deployment = &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.InlineReference,
+						Name: "instance_groups: [{name: component1, instances: 1}]",
+					},
+					Ops: []bdc.ResourceReference{
+						{Type: bdc.InlineReference, Name: "first"},
+						{Type: bdc.InlineReference, Name: "second"},
+					},
+				},
+			}
+		})
+
+		It("doesn't warn about ops files changing the manifest", func() {
+			interpolator.InterpolateCalls(func(m []byte) ([]byte, error) {
+				return []byte(strings.Replace(string(m), "instances: 1", "instances: 2", 1) + "\n"), nil
+			})
+
+			_, err := resolver.ManifestDetailed(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(logs.FilterMessageSnippet(noopWarning).Len()).To(Equal(0))
+			Expect(logs.FilterMessageSnippet(growthWarning).Len()).To(Equal(0))
+		})
+
+		It("warns about consecutive ops files without effect", func() {
+			interpolator.InterpolateCalls(func(m []byte) ([]byte, error) {
+				return m, nil
+			})
+
+			_, err := resolver.ManifestDetailed(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			warnings := logs.FilterMessageSnippet(noopWarning)
+			Expect(warnings.Len()).To(Equal(1))
+			Expect(warnings.All()[0].Level).To(Equal(zap.WarnLevel))
+			Expect(warnings.All()[0].Message).To(ContainSubstring("Ops file 'second' is the 2. consecutive ops file"))
+		})
+
+		It("doesn't warn about a single ops file without effect", func() {
+			deployment.Spec.Ops = deployment.Spec.Ops[:1]
+			interpolator.InterpolateCalls(func(m []byte) ([]byte, error) {
+				return m, nil
+			})
+
+			_, err := resolver.ManifestDetailed(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(logs.FilterMessageSnippet(noopWarning).Len()).To(Equal(0))
+		})
+
+		It("warns about ops files growing the manifest", func() {
+			deployment.Spec.Ops = deployment.Spec.Ops[:1]
+			interpolator.InterpolateCalls(func(m []byte) ([]byte, error) {
+				return append(m, []byte("\n# "+strings.Repeat("x", 10*len(m))+"\n")...), nil
+			})
+
+			_, err := resolver.ManifestDetailed(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			warnings := logs.FilterMessageSnippet(growthWarning)
+			Expect(warnings.Len()).To(Equal(1))
+			Expect(warnings.All()[0].Level).To(Equal(zap.WarnLevel))
+			Expect(warnings.All()[0].Message).To(ContainSubstring("Ops file 'first' grew the manifest"))
+		})
+	})
+
+	Describe("ManifestWithComments", func() {
+		const commented = `---
+# the only instance group
+instance_groups:
+  - name: component1
+    instances: 1 # scaled later
+`
+
+		BeforeEach(func() {
+			Expect(client.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "commented-manifest", Namespace: "default"},
+				Data:       map[string]string{bdc.ManifestSpecName: commented},
+			})).To(Succeed())
+
+			deployment = &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.ConfigMapReference,
+						Name: "commented-manifest",
+					},
+				},
+			}
+		})
+
+		It("preserves the comments if there are no ops files", func() {
+			text, err := resolver.ManifestWithComments(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(text).To(Equal(commented))
+		})
+
+		It("returns the manifest with ops files applied", func() {
+			interpolator.InterpolateReturns([]byte("instance_groups: []\n"), nil)
+			deployment.Spec.Ops = []bdc.ResourceReference{{Type: bdc.ConfigMapReference, Name: "replace-ops"}}
+
+			text, err := resolver.ManifestWithComments(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(text).To(Equal("instance_groups: []\n"))
+		})
+	})
+
+	Describe("ExpectedSecretNames", func() {
+		BeforeEach(func() {
+			deployment = &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.InlineReference,
+						Name: `---
+instance_groups:
+- name: component1
+  properties:
+    ca: ((ssl/ca))
+    key: ((ssl/private_key))
+    password: ((adminpass))
+    domain: ((system_domain))
+variables:
+- name: adminpass
+  type: password
+- name: router_ca
+  type: certificate
+`,
+					},
+				},
+			}
+		})
+
+		It("returns the sorted secret names of explicit and implicit variables", func() {
+			secretNames, err := resolver.ExpectedSecretNames(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(secretNames).To(Equal([]string{"var-adminpass", "var-router-ca", "var-ssl", "var-system-domain"}))
+		})
+	})
+
+	Describe("ResolveVariable", func() {
+		BeforeEach(func() {
+			deployment = &bdc.BOSHDeployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "foo-deployment",
+				},
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.InlineReference,
+						Name: `---
+instance_groups:
+- name: component1
+  properties:
+    ca: ((ssl/ca))
+    missing: ((ssl/missing))
+    domain: ((system_domain))
+    tls: ((router_cert))
+`,
+					},
+				},
+			}
+		})
+
+		It("returns the value of a single implicit variable", func() {
+			value, err := resolver.ResolveVariable(ctx, deployment, "default", "ssl/ca")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("the-ca"))
+
+			value, err = resolver.ResolveVariable(ctx, deployment, "default", "system_domain")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("example.com"))
+		})
+
+		It("returns certificates as objects", func() {
+			value, err := resolver.ResolveVariable(ctx, deployment, "default", "router_cert")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(map[interface{}]interface{}{
+				"ca":          "the-router-ca",
+				"certificate": "the-router-cert",
+				"private_key": "the-router-key",
+			}))
+		})
+
+		It("returns a secret key missing error", func() {
+			_, err := resolver.ResolveVariable(ctx, deployment, "default", "ssl/missing")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, withops.ErrSecretKeyMissing)).To(BeTrue())
+		})
+
+		It("fails for variables, which are not used by the manifest", func() {
+			_, err := resolver.ResolveVariable(ctx, deployment, "default", "unknown")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("variable 'unknown' is not an implicit variable of bosh deployment 'foo-deployment'"))
+		})
+	})
+
+	Describe("Render", func() {
+		BeforeEach(func() {
+			deployment = &bdc.BOSHDeployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "foo-deployment",
+				},
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.InlineReference,
+						Name: `---
+name: foo
+instance_groups:
+- name: component1
+  instances: 1
+  properties:
+    ca: ((ssl/ca))
+    domain: ((system_domain))
+`,
+					},
+				},
+			}
+		})
+
+		It("returns the resolved manifest without changing the cluster", func() {
+			before := &corev1.SecretList{}
+			Expect(client.List(ctx, before)).To(Succeed())
+
+			rendered, err := resolver.Render(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(rendered)).To(ContainSubstring("ca: the-ca"))
+			Expect(string(rendered)).To(ContainSubstring("domain: example.com"))
+
+			m, err := bdm.LoadYAML(rendered)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.InstanceGroups[0].Name).To(Equal("component1"))
+
+			after := &corev1.SecretList{}
+			Expect(client.List(ctx, after)).To(Succeed())
+			Expect(after.Items).To(Equal(before.Items))
+		})
+
+		It("fails like Manifest", func() {
+			deployment.Spec.Manifest.Name = "instance_groups: [{name: ig, properties: {x: ((unknown))}}]"
+
+			_, err := resolver.Render(ctx, deployment, "default")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, withops.ErrSecretNotFound)).To(BeTrue())
+		})
+	})
+
+	Describe("WithOpsVariables", func() {
+		BeforeEach(func() {
+			interpolator.InterpolateReturns([]byte(`---
+instance_groups:
+  - name: component1
+    instances: 2
+`), nil)
+
+			deployment = &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.InlineReference,
+						Name: `---
+instance_groups:
+- name: component1
+  instances: ((instances))
+variables:
+- name: router-cert
+  type: certificate
+- name: adminpass
+  type: password
+`,
+					},
+					Ops: []bdc.ResourceReference{
+						{
+							Type: bdc.InlineReference,
+							Name: `---
+- type: replace
+  path: /instance_groups/name=component1/properties?/domain
+  value: ((system-domain))
+- type: replace
+  path: /instance_groups/name=component1/properties?/cert
+  value: ((router-cert.certificate))
+- type: replace
+  path: /instance_groups/name=component1/properties?/password
+  value: ((adminpass))
+`,
+						},
+					},
+				},
+			}
+		})
+
+		It("applies ops files verbatim by default", func() {
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(interpolator.AddOpsArgsForCall(0))).To(ContainSubstring("((system-domain))"))
+		})
+
+		It("interpolates variables in ops files before applying them", func() {
+			resolver = withops.NewResolver(client, func() withops.Interpolator { return interpolator }, withops.WithOpsVariables())
+
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			ops := string(interpolator.AddOpsArgsForCall(0))
+			Expect(ops).To(ContainSubstring("value: example.com"))
+			Expect(ops).To(ContainSubstring("value: the-router-cert"))
+			Expect(ops).To(ContainSubstring("value: ((adminpass))"))
+		})
+
+		It("interpolates variables in ops files for detailed manifests", func() {
+			resolver = withops.NewResolver(client, func() withops.Interpolator { return interpolator }, withops.WithOpsVariables())
+
+			_, err := resolver.ManifestDetailed(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(interpolator.AddOpsArgsForCall(0))).To(ContainSubstring("value: example.com"))
+		})
+
+		It("fails if the secret of an implicit variable is missing", func() {
+			resolver = withops.NewResolver(client, func() withops.Interpolator { return interpolator }, withops.WithOpsVariables())
+			deployment.Spec.Ops[0].Name = `---
+- type: replace
+  path: /instance_groups/name=component1/properties?/domain
+  value: ((unknown-domain))
+`
+
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("var-unknown-domain"))
+		})
+
+		It("lists the secrets of the variables in ops files", func() {
+			secNames, err := resolver.OpsVariableSecrets(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(secNames).To(Equal([]string{"var-adminpass", "var-router-cert", "var-system-domain"}))
+		})
+	})
+
+	Describe("resolving a resolved manifest", func() {
+		BeforeEach(func() {
+			deployment = &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.InlineReference,
+						Name: `---
+releases:
+- name: redis
+  version: "1"
+instance_groups:
+- name: component1
+  instances: 1
+  jobs:
+  - name: redis-server
+    release: redis
+addons:
+- name: test
+  jobs:
+  - name: addon-job
+    release: redis
+  include:
+    instance_groups:
+    - component1
+`,
+					},
+				},
+			}
+		})
+
+		It("applies the addons again, without duplicating their jobs", func() {
+			manifest, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.AddonsApplied()).To(BeTrue())
+			Expect(manifest.InstanceGroups[0].Jobs).To(HaveLen(2))
+
+			bytes, err := manifest.Marshal()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(bytes)).ToNot(ContainSubstring("addons_applied"))
+
+			deployment.Spec.Manifest.Name = string(bytes)
+			manifest, err = resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.AddonsApplied()).To(BeTrue())
+			Expect(manifest.InstanceGroups[0].Jobs).To(HaveLen(2))
+			Expect(manifest.InstanceGroups[0].Jobs[1].Name).To(Equal("addon-job"))
+			Expect(manifest.InstanceGroups[0].Jobs[1].Properties.Quarks.AddOnName).To(Equal("test"))
+		})
+	})
+
+	Describe("WithManifestTransform", func() {
+		var calls []string
+
+		BeforeEach(func() {
+			calls = []string{}
+			deployment = &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.InlineReference,
+						Name: `---
+instance_groups:
+- name: component1
+  instances: 1
+`,
+					},
+				},
+			}
+		})
+
+		newResolver := func(transforms ...withops.ManifestTransform) *withops.Resolver {
+			opts := []withops.ResolverOption{}
+			for _, t := range transforms {
+				opts = append(opts, withops.WithManifestTransform(t))
+			}
+			return withops.NewResolver(client, func() withops.Interpolator { return interpolator }, opts...)
+		}
+
+		It("runs the transforms in order before interpolating variables", func() {
+			resolver = newResolver(
+				func(m *bdm.Manifest) error {
+					calls = append(calls, "first")
+					m.InstanceGroups = append(m.InstanceGroups, &bdm.InstanceGroup{
+						Name:       "injected",
+						Instances:  1,
+						Properties: bdm.InstanceGroupProperties{Properties: map[string]interface{}{"domain": "((system_domain))"}},
+					})
+					return nil
+				},
+				func(m *bdm.Manifest) error {
+					calls = append(calls, "second")
+					Expect(m.InstanceGroupNames()).To(Equal([]string{"component1", "injected"}))
+					return nil
+				},
+			)
+
+			for _, resolve := range []func(context.Context, *bdc.BOSHDeployment, string) (*bdm.Manifest, error){resolver.Manifest, resolver.ManifestDetailed} {
+				m, err := resolve(ctx, deployment, "default")
+				Expect(err).ToNot(HaveOccurred())
+				ig, ok := m.InstanceGroup("injected")
+				Expect(ok).To(BeTrue())
+				Expect(ig.Properties.Properties["domain"]).To(Equal("example.com"))
+			}
+			Expect(calls).To(Equal([]string{"first", "second", "first", "second"}))
+		})
+
+		It("aborts the resolve if a transform fails", func() {
+			resolver = newResolver(
+				func(m *bdm.Manifest) error { return errors.New("policy violation") },
+				func(m *bdm.Manifest) error {
+					calls = append(calls, "second")
+					return nil
+				},
+			)
+
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("manifest transform 0 failed"))
+			Expect(err.Error()).To(ContainSubstring("policy violation"))
+			Expect(calls).To(BeEmpty())
+		})
+	})
+
+	Describe("fetching referenced resources", func() {
+		var tracking *trackingClient
+
+		BeforeEach(func() {
+			tracking = newTrackingClient(client)
+			resolver = withops.NewResolver(tracking, func() withops.Interpolator { return interpolator })
+
+			interpolator.InterpolateReturns([]byte(`---
+instance_groups:
+- name: component1
+  instances: 2
+`), nil)
+			deployment = &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.ConfigMapReference,
+						Name: "base-manifest",
+					},
+					Ops: []bdc.ResourceReference{
+						{Type: bdc.ConfigMapReference, Name: "replace-ops"},
+						{Type: bdc.ConfigMapReference, Name: "replace-ops"},
+					},
+				},
+			}
+		})
+
+		It("gets a resource referenced twice only once per resolve", func() {
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tracking.gets["replace-ops"]).To(Equal(1))
+			Expect(tracking.gets["base-manifest"]).To(Equal(1))
+			Expect(interpolator.AddOpsCallCount()).To(Equal(2))
+		})
+
+		It("gets the resources again for the next resolve", func() {
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tracking.gets["replace-ops"]).To(Equal(2))
+			Expect(tracking.gets["base-manifest"]).To(Equal(2))
+		})
+	})
+
+	Describe("Manifest without variables", func() {
+		const text = `---
+name: simple
+instance_groups:
+- name: component1
+  instances: 2
+  jobs:
+  - name: job1
+    release: release1
+    properties:
+      port: 8080
+      ratio: 0.5
+      list: [a, b]
+      text: "a long text value which is long enough to be replaced by an anchor"
+      other: "a long text value which is long enough to be replaced by an anchor"
+releases:
+- name: release1
+  version: 1
+update:
+  canaries: 1
+  max_in_flight: 1
+`
+
+		checksum := func(deployment *bdc.BOSHDeployment) string {
+			m, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			sum, err := m.Checksum()
+			Expect(err).ToNot(HaveOccurred())
+			return sum
+		}
+
+		It("returns the same manifest as interpolating unused variables", func() {
+			Expect(client.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "unused-var", Namespace: "default"},
+				Data:       map[string][]byte{"password": []byte("secret")},
+			})).To(Succeed())
+
+			deployment = &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{Type: bdc.InlineReference, Name: text},
+				},
+			}
+			fast := checksum(deployment)
+
+			deployment.Spec.Vars = []bdc.VarReference{{Name: "unused", Secret: "unused-var"}}
+			Expect(checksum(deployment)).To(Equal(fast))
+		})
+	})
+
+	Describe("SetManifestSizeWarnRatio", func() {
+		BeforeEach(func() {
+			deployment = &bdc.BOSHDeployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo-deployment"},
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.InlineReference,
+						Name: "instance_groups: [{name: component1, instances: 1}]",
+					},
+				},
+			}
+		})
+
+		It("doesn't warn about small manifests by default", func() {
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(logs.FilterMessageSnippet("close to the secret size limit").Len()).To(Equal(0))
+		})
+
+		It("warns if the manifest exceeds the fraction of the size limit", func() {
+			resolver.SetManifestSizeWarnRatio(0.00001)
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(logs.FilterMessageSnippet("Manifest of bosh deployment 'foo-deployment' has").Len()).To(Equal(1))
+		})
+
+		It("warns about manifests, which were marshalled while applying addons", func() {
+			deployment.Spec.Manifest.Name = `---
+instance_groups: [{name: component1, instances: 1, jobs: [{name: redis-server, release: redis}]}]
+addons: [{name: test, jobs: [{name: addon-job, release: redis}], include: {instance_groups: [component1]}}]
+`
+			resolver.SetManifestSizeWarnRatio(0.00001)
+			m, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.InstanceGroups[0].Jobs).To(HaveLen(2))
+			Expect(logs.FilterMessageSnippet("Manifest of bosh deployment 'foo-deployment' has").Len()).To(Equal(1))
+		})
+
+		It("estimates the stored size of manifests with explicit variables", func() {
+			Expect(client.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "text-var", Namespace: "default"},
+				Data:       map[string][]byte{"password": []byte("a long text value which is long enough to be replaced by an anchor")},
+			})).To(Succeed())
+			deployment.Spec.Manifest.Name = `---
+instance_groups: [{name: component1, instances: 1, properties: {text: ((text)), other: ((text))}}]
+`
+			deployment.Spec.Vars = []bdc.VarReference{{Name: "text", Secret: "text-var"}}
+
+			resolver.SetManifestSizeWarnRatio(0.00001)
+			m, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			size, err := m.EstimateStoredSize()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(logs.FilterMessageSnippet(fmt.Sprintf("has %d bytes", size)).Len()).To(Equal(1))
+		})
+
+		It("doesn't check the size if the warning is disabled", func() {
+			resolver.SetManifestSizeWarnRatio(0)
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(logs.FilterMessageSnippet("Manifest of bosh deployment").Len()).To(Equal(0))
+		})
+	})
+
+	Describe("SetMetrics", func() {
+		var metrics *recordingMetrics
+
+		BeforeEach(func() {
+			metrics = &recordingMetrics{}
+			resolver.SetMetrics(metrics)
+
+			interpolator.InterpolateReturns([]byte(`---
+instance_groups:
+- name: component1
+  properties:
+    domain: ((system_domain))
+    ca: ((ssl/ca))
+`), nil)
+			deployment = &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.InlineReference,
+						Name: "instance_groups: []",
+					},
+					Ops: []bdc.ResourceReference{
+						{Type: bdc.InlineReference, Name: "[]"},
+						{Type: bdc.InlineReference, Name: "[]"},
+					},
+				},
+			}
+		})
+
+		It("records the resolve duration, the applied ops and the secret fetches", func() {
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(metrics.resolves).To(Equal([]string{"Manifest"}))
+			Expect(metrics.opsApplied).To(Equal(2))
+			Expect(metrics.secretFetches).To(Equal(2))
+		})
+
+		It("records the detailed resolve", func() {
+			_, err := resolver.ManifestDetailed(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(metrics.resolves).To(Equal([]string{"ManifestDetailed"}))
+			Expect(metrics.opsApplied).To(Equal(2))
+			Expect(metrics.secretFetches).To(Equal(2))
+		})
+
+		It("can be disabled again", func() {
+			resolver.SetMetrics(nil)
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metrics.resolves).To(BeEmpty())
+		})
+	})
+
+	Describe("SetSecretFetchConcurrency", func() {
+		var tracking *trackingClient
+
+		BeforeEach(func() {
+			names := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot"}
+			tracking = newTrackingClient(client)
+			for i, name := range names {
+				err := client.Create(ctx, &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "var-" + name, Namespace: "default"},
+					Data:       map[string][]byte{"value": []byte(name + "-value")},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				// the first secrets are returned last
+				tracking.delays["var-"+name] = time.Duration(len(names)-i) * 10 * time.Millisecond
+			}
+			resolver = withops.NewResolver(tracking, func() withops.Interpolator { return interpolator })
+
+			interpolator.InterpolateReturns([]byte(`---
+instance_groups:
+- name: component1
+  properties:
+    alpha: ((alpha))
+    bravo: ((bravo))
+    charlie: ((charlie))
+    delta: ((delta))
+    echo: ((echo))
+    foxtrot: ((foxtrot))
+`), nil)
+			deployment = &bdc.BOSHDeployment{
+				Spec: bdc.BOSHDeploymentSpec{
+					Manifest: bdc.ResourceReference{
+						Type: bdc.InlineReference,
+						Name: "instance_groups: []",
+					},
+					Ops: []bdc.ResourceReference{
+						{Type: bdc.InlineReference, Name: "[]"},
+					},
+				},
+			}
+		})
+
+		It("limits the number of concurrent fetches", func() {
+			resolver.SetSecretFetchConcurrency(2)
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tracking.maxInFlight).To(BeNumerically("<=", 2))
+			Expect(tracking.gets).To(HaveLen(6))
+		})
+
+		It("fetches one secret at a time for values below one", func() {
+			resolver.SetSecretFetchConcurrency(0)
+			_, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tracking.maxInFlight).To(Equal(1))
+		})
+
+		It("assigns the values in the order of the variables", func() {
+			m, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tracking.maxInFlight).To(BeNumerically(">", 1))
+
+			props := m.InstanceGroups[0].Properties.Properties
+			for _, name := range []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot"} {
+				Expect(props).To(HaveKeyWithValue(name, name+"-value"))
+			}
+		})
+
+		It("fails the resolve if one secret is missing", func() {
+			err := client.Delete(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "var-delta", Namespace: "default"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			m, err := resolver.Manifest(ctx, deployment, "default")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, withops.ErrSecretNotFound)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("var-delta"))
+			Expect(m).To(BeNil())
+		})
+
+		It("applies the limit when listing missing secrets", func() {
+			resolver.SetSecretFetchConcurrency(2)
+			err := client.Delete(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "var-delta", Namespace: "default"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			missing, err := resolver.MissingVariableSecrets(ctx, deployment, "default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(missing).To(Equal([]string{"var-delta"}))
+			Expect(tracking.maxInFlight).To(BeNumerically("<=", 2))
+			Expect(tracking.gets).To(HaveLen(6))
+		})
+	})
+
+	Context("Interpolate variables correctly", func() {
+		var (
+			baseManifest          []byte
+			incorrectBaseManifest []byte
+			vars                  []boshtpl.Variables
+		)
+
+		BeforeEach(func() {
+			baseManifest = []byte(`
+---
+director_uuid: ((password1))
+instance_groups:
+- name: ((value1.key1))
+- name: ((value2.key2))
+- name: ((value2.key3))
+`)
 			incorrectBaseManifest = []byte(`
 ---
 director_uuid: ((password2))
@@ -1157,5 +2815,202 @@ instance_groups:
 			_, err := withops.InterpolateExplicitVariables(incorrectBaseManifest, vars, true)
 			Expect(err).To(HaveOccurred())
 		})
+
+		It("raises error when not all variables are used in strict mode", func() {
+			vars = []boshtpl.Variables{
+				0: boshtpl.StaticVariables{
+					"password1": "password1data",
+					"password2": "password2data",
+				},
+				1: boshtpl.StaticVariables{
+					"value1": map[interface{}]interface{}{
+						"key1": "key1data",
+					},
+					"value2": map[interface{}]interface{}{
+						"key2": "key2data",
+						"key3": "ky3data",
+					},
+				},
+			}
+			_, err := withops.InterpolateExplicitVariables(baseManifest, vars, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = withops.InterpolateExplicitVariablesWithOpts(baseManifest, vars, boshtpl.EvaluateOpts{
+				ExpectAllKeys:     true,
+				ExpectAllVarsUsed: true,
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("password2"))
+		})
+	})
+
+	Describe("InterpolateKnownVariables", func() {
+		It("keeps the placeholders of unknown variables", func() {
+			manifest := []byte(`---
+instance_groups:
+- name: component1
+  instances: 1
+  properties:
+    password: ((known))
+    ca: ((deferred.ca))
+    url: https://((deferred_host)):443/((known))
+`)
+			vars := []boshtpl.Variables{
+				boshtpl.StaticVariables{"known": "secret"},
+			}
+
+			result, err := withops.InterpolateKnownVariables(manifest, vars)
+			Expect(err).NotTo(HaveOccurred())
+
+			m, err := bdm.LoadYAML(result)
+			Expect(err).NotTo(HaveOccurred())
+			props := m.InstanceGroups[0].Properties.Properties
+			Expect(props["password"]).To(Equal("secret"))
+			Expect(props["ca"]).To(Equal("((deferred.ca))"))
+			Expect(props["url"]).To(Equal("https://((deferred_host)):443/secret"))
+		})
+	})
+
+	Describe("MergeStaticJSONVar", func() {
+		It("uses JSON arrays as the variable value", func() {
+			v, err := withops.MergeStaticJSONVar(nil, "value", []byte(`["ca1","ca2"]`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v).To(Equal([]interface{}{"ca1", "ca2"}))
+		})
+
+		It("merges other values decoded", func() {
+			v, err := withops.MergeStaticJSONVar(nil, "certificate", []byte(`"the-cert"`))
+			Expect(err).NotTo(HaveOccurred())
+			v, err = withops.MergeStaticJSONVar(v, "port", []byte(`8443`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v).To(Equal(map[interface{}]interface{}{"certificate": "the-cert", "port": float64(8443)}))
+		})
+
+		It("fails to combine arrays with other keys", func() {
+			v, err := withops.MergeStaticJSONVar(nil, "cas", []byte(`["ca1","ca2"]`))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = withops.MergeStaticJSONVar(v, "name", []byte(`"trusted"`))
+			Expect(err).To(MatchError("key 'name' can't be combined with an array value"))
+
+			v, err = withops.MergeStaticJSONVar(nil, "name", []byte(`"trusted"`))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = withops.MergeStaticJSONVar(v, "cas", []byte(`["ca1","ca2"]`))
+			Expect(err).To(MatchError("array value of key 'cas' can't be combined with other keys"))
+		})
+
+		It("merges JSON objects as structured values", func() {
+			v, err := withops.MergeStaticJSONVar(nil, "config", []byte(`{"a":{"b":"c"}}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v).To(Equal(map[interface{}]interface{}{
+				"config": map[interface{}]interface{}{
+					"a": map[interface{}]interface{}{"b": "c"},
+				},
+			}))
+		})
+
+		It("fails on invalid JSON", func() {
+			_, err := withops.MergeStaticJSONVar(nil, "value", []byte(`[`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("InterpolateVariableFromSecrets", func() {
+		BeforeEach(func() {
+			scheme := runtime.NewScheme()
+			Expect(corev1.AddToScheme(scheme)).To(Succeed())
+			Expect(qsv1a1.AddToScheme(scheme)).To(Succeed())
+
+			generated := true
+			client = fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(
+					&qsv1a1.QuarksSecret{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "var-sshkey",
+							Namespace: "default",
+						},
+						Spec: qsv1a1.QuarksSecretSpec{
+							Type:       qsv1a1.SSHKey,
+							SecretName: "var-sshkey",
+						},
+						Status: qsv1a1.QuarksSecretStatus{Generated: &generated},
+					},
+					&corev1.Secret{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "var-sshkey",
+							Namespace: "default",
+						},
+						Data: map[string][]byte{
+							"private_key":            []byte("the-private-key"),
+							"public_key":             []byte("the-public-key"),
+							"public_key_fingerprint": []byte("the-fingerprint"),
+						},
+					},
+				).Build()
+			resolver = withops.NewResolver(client, func() withops.Interpolator { return interpolator })
+		})
+
+		It("merges the keys of ssh variables", func() {
+			manifestBytes, err := resolver.InterpolateVariableFromSecrets(ctx, []byte(`---
+instance_groups:
+- name: component1
+  properties:
+    private: ((sshkey.private_key))
+    public: ((sshkey.public_key))
+    fingerprint: ((sshkey.public_key_fingerprint))
+variables:
+- name: sshkey
+  type: ssh
+`), "default", "foo-deployment")
+			Expect(err).NotTo(HaveOccurred())
+
+			m, err := bdm.LoadYAML(manifestBytes)
+			Expect(err).NotTo(HaveOccurred())
+			props := m.InstanceGroups[0].Properties.Properties
+			Expect(props["private"]).To(Equal("the-private-key"))
+			Expect(props["public"]).To(Equal("the-public-key"))
+			Expect(props["fingerprint"]).To(Equal("the-fingerprint"))
+		})
+
+		It("uses variables added by ops files", func() {
+			interpolator := withops.NewInterpolator()
+			Expect(interpolator.AddOps([]byte(`
+- type: replace
+  path: /variables/-
+  value:
+    name: sshkey
+    type: ssh
+`))).To(Succeed())
+
+			withOps, err := interpolator.Interpolate([]byte(`---
+instance_groups:
+- name: component1
+  properties:
+    public: ((sshkey.public_key))
+variables:
+- name: sshkey_old
+  type: ssh
+`))
+			Expect(err).NotTo(HaveOccurred())
+
+			m, err := bdm.LoadYAML(withOps)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m.Variables).To(HaveLen(2))
+			Expect(m.Variables[1].Name).To(Equal("sshkey"))
+			Expect(m.Variables[1].Type).To(Equal("ssh"))
+
+			// only keep the variable which has a quarks secret
+			m.Variables = m.Variables[1:]
+			withOps, err = m.Marshal()
+			Expect(err).NotTo(HaveOccurred())
+
+			manifestBytes, err := resolver.InterpolateVariableFromSecrets(ctx, withOps, "default", "foo-deployment")
+			Expect(err).NotTo(HaveOccurred())
+
+			m, err = bdm.LoadYAML(manifestBytes)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m.InstanceGroups[0].Properties.Properties["public"]).To(Equal("the-public-key"))
+			Expect(m.Variables).To(HaveLen(1))
+		})
 	})
 })
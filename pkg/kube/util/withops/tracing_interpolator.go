@@ -0,0 +1,81 @@
+package withops
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// OpTrace describes a single operation of an ops file
+type OpTrace struct {
+	// OpsFile is the index of the ops file in the order they were added
+	OpsFile int
+	// Operation is the go-patch type or the JSON patch op, e.g. 'replace'
+	Operation string
+	Path      string
+}
+
+// String returns the trace entry as 'operation path'
+func (t OpTrace) String() string {
+	return fmt.Sprintf("%s %s", t.Operation, t.Path)
+}
+
+// TracingInterpolator wraps an interpolator and records the operations of
+// the added ops files. Construct it in a NewInterpolatorFunc to trace which
+// manifest paths each ops file touches.
+type TracingInterpolator struct {
+	Interpolator
+	opsFiles int
+	pending  []OpTrace
+	trace    []OpTrace
+}
+
+var _ Interpolator = &TracingInterpolator{}
+
+// NewTracingInterpolator returns a tracing interpolator wrapping i
+func NewTracingInterpolator(i Interpolator) *TracingInterpolator {
+	return &TracingInterpolator{Interpolator: i}
+}
+
+// AddOps records the operations of the ops file and adds it to the wrapped
+// interpolator
+func (t *TracingInterpolator) AddOps(opsBytes []byte) error {
+	if err := t.Interpolator.AddOps(opsBytes); err != nil {
+		return err
+	}
+
+	var opDefs []map[string]interface{}
+	if err := yaml.Unmarshal(opsBytes, &opDefs); err == nil {
+		for _, opDef := range opDefs {
+			operation := opDef["type"]
+			if operation == nil {
+				operation = opDef["op"]
+			}
+			t.pending = append(t.pending, OpTrace{
+				OpsFile:   t.opsFiles,
+				Operation: fmt.Sprint(operation),
+				Path:      fmt.Sprint(opDef["path"]),
+			})
+		}
+	}
+	t.opsFiles++
+
+	return nil
+}
+
+// Interpolate renders the manifest by the wrapped interpolator. If it
+// succeeds, the recorded operations are available from Trace.
+func (t *TracingInterpolator) Interpolate(manifestBytes []byte) ([]byte, error) {
+	result, err := t.Interpolator.Interpolate(manifestBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	t.trace = append([]OpTrace{}, t.pending...)
+	return result, nil
+}
+
+// Trace returns the operations applied by the last successful Interpolate
+func (t *TracingInterpolator) Trace() []OpTrace {
+	return t.trace
+}
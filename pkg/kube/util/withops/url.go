@@ -0,0 +1,160 @@
+package withops
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// DefaultURLAttempts is the number of attempts to fetch a URL reference
+	DefaultURLAttempts = 3
+	// DefaultURLBackoff is the delay before the first retry, it doubles with every retry
+	DefaultURLBackoff = 500 * time.Millisecond
+	// DefaultURLTimeout limits the duration of a single attempt to fetch a URL reference
+	DefaultURLTimeout = 30 * time.Second
+)
+
+// HTTPClient sends the requests for URL references
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// defaultHTTPClient is shared by all resolvers, so they reuse its connections
+var defaultHTTPClient = newHTTPClient()
+
+// newHTTPClient returns a client with connection timeouts, the duration of
+// the whole request is limited by the resolver's URL timeout
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConns:          10,
+		},
+	}
+}
+
+// SetHTTPClient replaces the client used to fetch URL references, e.g. to
+// trust a custom CA or to use a proxy
+func (r *Resolver) SetHTTPClient(c HTTPClient) {
+	r.httpClient = c
+}
+
+// SetURLRetry configures how URL references are fetched. Attempts is the
+// maximum number of requests, backoff the delay before the first retry and
+// timeout the limit for a single request.
+func (r *Resolver) SetURLRetry(attempts int, backoff time.Duration, timeout time.Duration) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	r.urlAttempts = attempts
+	r.urlBackoff = backoff
+	r.urlTimeout = timeout
+}
+
+// fetchURL gets the body of the URL. Network errors and server errors (5xx)
+// are retried with an exponential backoff, other non-2xx responses fail.
+func (r *Resolver) fetchURL(ctx context.Context, url string, key string, headers http.Header) (string, error) {
+	var (
+		body  string
+		retry bool
+		err   error
+	)
+
+	backoff := r.urlBackoff
+	for attempt := 1; attempt <= r.urlAttempts; attempt++ {
+		body, retry, err = r.fetchURLOnce(ctx, url, headers)
+		if err == nil {
+			return body, nil
+		}
+		// don't retry if the caller gave up
+		if ctx.Err() != nil {
+			return "", errors.Wrapf(ctx.Err(), "failed to fetch %s from URL '%s'", key, url)
+		}
+		if !retry {
+			return "", errors.Wrapf(err, "failed to fetch %s from URL '%s'", key, url)
+		}
+		if attempt == r.urlAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", errors.Wrapf(ctx.Err(), "failed to fetch %s from URL '%s'", key, url)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	return "", errors.Wrapf(err, "failed to fetch %s from URL '%s' after %d attempts", key, url, r.urlAttempts)
+}
+
+// fetchURLOnce does a single request for the URL. It returns whether the
+// request should be retried on error.
+func (r *Resolver) fetchURLOnce(ctx context.Context, url string, headers http.Header) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.urlTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", false, errors.Wrap(err, "failed to create request")
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+
+	httpResponse, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", true, errors.Wrap(err, "request failed")
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode >= http.StatusInternalServerError {
+		return "", true, fmt.Errorf("unexpected status %d", httpResponse.StatusCode)
+	}
+	// Don't feed error pages into the yaml parser
+	if httpResponse.StatusCode < http.StatusOK || httpResponse.StatusCode >= http.StatusMultipleChoices {
+		return "", false, fmt.Errorf("unexpected status %d", httpResponse.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return "", true, errors.Wrap(err, "failed to read response body via ioutil")
+	}
+
+	return string(body), false, nil
+}
+
+// urlHeaders returns the HTTP headers stored in the secret. Each key of the
+// secret is used as a header name, e.g. 'Authorization'.
+func (r *Resolver) urlHeaders(ctx context.Context, namespace string, secretName string) (http.Header, error) {
+	headers := http.Header{}
+	if secretName == "" {
+		return headers, nil
+	}
+
+	secret := &corev1.Secret{}
+	err := r.getSecret(ctx, types.NamespacedName{Name: secretName, Namespace: namespace}, secret)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to retrieve headers from secret '%s/%s' via client.Get", namespace, secretName)
+	}
+
+	for k, v := range secret.Data {
+		headers.Set(k, string(v))
+	}
+	return headers, nil
+}
@@ -354,6 +354,12 @@ instance_groups:
       nats: {from: nats}
 `
 
+// NatsSmokeTestWithExternalLinksDNS is the same as
+// NatsSmokeTestWithExternalLinks, but enables the use_dns_addresses feature
+const NatsSmokeTestWithExternalLinksDNS = NatsSmokeTestWithExternalLinks + `features:
+  use_dns_addresses: true
+`
+
 // Drains is a small manifest with jobs that include drain scripts
 // It can be used in integration tests.
 const Drains = `---